@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -31,14 +33,29 @@ type MemBackend struct {
 	config       *neoq.Config
 	logger       logging.Logger
 	handlers     *sync.Map // map queue names [string] to queue handlers [Handler]
-	fingerprints *sync.Map // map fingerprints [string] to job [Job]
+	allJobs      *sync.Map // map jobIDs [int64] to job [Job] for every job that is active, dead, or recently completed
+	deadJobs     *sync.Map // map jobIDs [int64] to job [Job] for jobs that have exhausted their retries
 	futureJobs   *sync.Map // map jobIDs [int64] to job [Job]
-	queues       *sync.Map // map queue names [string] to queue handler channels [chan Job]
+	queues       *sync.Map // map queue names [string] to the queues of jobs that are due [*priorityQueue]
 	cron         *cron.Cron
 	mu           *sync.Mutex          // mutext to protect mutating state on a pgWorker
 	cancelFuncs  []context.CancelFunc // A collection of cancel functions to be called upon Shutdown()
 	jobCount     int64                // number of jobs that have been queued since start
 	initialized  bool
+	dependents   map[int64][]*jobs.Job  // map jobIDs to the jobs that are waiting for them, protected by mu
+	batches      map[int64]*memBatch    // map batch IDs to batches, protected by mu
+	batchCount   int64                  // number of batches that have been created since start
+	rateLimiter  *rateLimiter           // limits the rate of rate-limited queues' jobs
+	fingerprints map[string][]*jobs.Job // map fingerprints to the jobs that may be duplicated, protected by mu
+	running      map[int64]bool         // the IDs of jobs that are being processed, protected by mu
+	completed    completedJobHeap       // jobs that were processed or cancelled, until they're forgotten, protected by mu
+}
+
+// memBatch tracks the jobs of a batch
+type memBatch struct {
+	status   jobs.BatchStatus
+	callback *jobs.Job
+	sealed   bool // whether all of the batch's jobs have been enqueued, after which the batch may be completed
 }
 
 // Backend is a [neoq.BackendInitializer] that initializes a new memory-backed neoq backend
@@ -50,11 +67,18 @@ func Backend(_ context.Context, opts ...neoq.ConfigOption) (backend neoq.Neoq, e
 		queues:       &sync.Map{},
 		handlers:     &sync.Map{},
 		futureJobs:   &sync.Map{},
-		fingerprints: &sync.Map{},
+		allJobs:      &sync.Map{},
+		deadJobs:     &sync.Map{},
+		dependents:   map[int64][]*jobs.Job{},
+		batches:      map[int64]*memBatch{},
+		rateLimiter:  newRateLimiter(),
+		fingerprints: map[string][]*jobs.Job{},
+		running:      map[int64]bool{},
 		jobCount:     0,
 		cancelFuncs:  []context.CancelFunc{},
 	}
 	mb.cron.Start()
+	mb.config.CompletedJobRetention = defaultCompletedJobRetention
 
 	for _, opt := range opts {
 		opt(mb.config)
@@ -66,9 +90,29 @@ func Backend(_ context.Context, opts ...neoq.ConfigOption) (backend neoq.Neoq, e
 	return
 }
 
+// WithRetention configures the time that completed jobs are kept for, so that they can be looked up with GetJob. By
+// default, completed jobs are kept for an hour.
+//
+// Processed jobs that are unique within a window are kept for at least their window. See [jobs.UniqueWithinWindow].
+//
+// WithRetention is equivalent to [neoq.WithCompletedJobRetention].
+func WithRetention(retention time.Duration) neoq.ConfigOption {
+	return neoq.WithCompletedJobRetention(retention)
+}
+
 // Enqueue queues jobs to be executed asynchronously
 func (m *MemBackend) Enqueue(ctx context.Context, job *jobs.Job) (jobID string, err error) {
-	var queueChan chan *jobs.Job
+	return m.config.InterceptEnqueue(ctx, job, m.enqueue)
+}
+
+// EnqueueMany queues many jobs at once, acquiring the backend's lock only once for the entire batch
+func (m *MemBackend) EnqueueMany(ctx context.Context, js []*jobs.Job) (jobIDs []string, err error) {
+	return m.config.InterceptEnqueueMany(ctx, js, m.enqueueMany)
+}
+
+// enqueue queues jobs that have passed through the configured enqueue interceptors
+func (m *MemBackend) enqueue(_ context.Context, job *jobs.Job) (jobID string, err error) {
+	var queue *priorityQueue
 	var qc any
 	var ok bool
 
@@ -83,7 +127,7 @@ func (m *MemBackend) Enqueue(ctx context.Context, job *jobs.Job) (jobID string,
 		return jobs.UnqueuedJobID, fmt.Errorf("%w: %s", handler.ErrNoProcessorForQueue, job.Queue)
 	}
 
-	queueChan = qc.(chan *jobs.Job)
+	queue = qc.(*priorityQueue)
 
 	// Make sure RunAfter is set to a non-zero value if not provided by the caller
 	// if already set, schedule the future job
@@ -92,31 +136,53 @@ func (m *MemBackend) Enqueue(ctx context.Context, job *jobs.Job) (jobID string,
 		job.RunAfter = now
 	}
 
+	if job.EnqueueMode != nil {
+		job.RunAfter = now.Add(job.EnqueueMode.Window)
+	}
+
 	if job.Queue == "" {
 		err = jobs.ErrNoQueueSpecified
 		return
 	}
 
+	err = jobs.EncodePayload(job, m.config.Codec(job.Queue))
+	if err != nil {
+		return
+	}
+
+	m.applyHandlerUnique(job)
 	err = jobs.FingerprintJob(job)
 	if err != nil {
 		return
 	}
 
-	// if the job fingerprint is already known, don't queue the job
-	if _, found := m.fingerprints.Load(job.Fingerprint); found {
-		return jobs.DuplicateJobID, nil
+	err = m.checkDependencies(job)
+	if err != nil {
+		return
 	}
 
-	m.fingerprints.Store(job.Fingerprint, job)
 	m.mu.Lock()
-	m.jobCount++
+	// if the job is coalesced with a pending job, or duplicates a known job, don't queue the job
+	if job.EnqueueMode != nil {
+		if jobID, ok := m.coalesce(job, now); ok {
+			m.mu.Unlock()
+			return jobID, nil
+		}
+	} else if m.duplicated(job.Fingerprint, job.UniqueScope(), job.UniqueWindow(), now) {
+		m.mu.Unlock()
+		return jobs.DuplicateJobID, nil
+	}
+
+	jobID = m.registerJob(job, now)
+	ready := m.waitForDependencies(job)
 	m.mu.Unlock()
 
-	job.ID = m.jobCount
-	jobID = fmt.Sprint(m.jobCount)
+	if !ready {
+		return jobID, nil
+	}
 
 	if job.RunAfter.Equal(now) {
-		queueChan <- job
+		queue.push(job)
 	} else {
 		m.queueFutureJob(job)
 	}
@@ -124,15 +190,473 @@ func (m *MemBackend) Enqueue(ctx context.Context, job *jobs.Job) (jobID string,
 	return jobID, nil
 }
 
+// enqueueMany queues many jobs that have passed through the configured enqueue interceptors
+func (m *MemBackend) enqueueMany(_ context.Context, js []*jobs.Job) (jobIDs []string, err error) {
+	now := time.Now().UTC()
+	queues := make([]*priorityQueue, len(js))
+	for i, job := range js {
+		if job.Queue == "" {
+			err = jobs.ErrNoQueueSpecified
+			return
+		}
+
+		qc, ok := m.queues.Load(job.Queue)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", handler.ErrNoProcessorForQueue, job.Queue)
+		}
+		queues[i] = qc.(*priorityQueue)
+
+		if job.RunAfter.IsZero() {
+			job.RunAfter = now
+		}
+
+		if job.EnqueueMode != nil {
+			job.RunAfter = now.Add(job.EnqueueMode.Window)
+		}
+
+		err = jobs.EncodePayload(job, m.config.Codec(job.Queue))
+		if err != nil {
+			return
+		}
+
+		m.applyHandlerUnique(job)
+		err = jobs.FingerprintJob(job)
+		if err != nil {
+			return
+		}
+
+		err = m.checkDependencies(job)
+		if err != nil {
+			return
+		}
+	}
+
+	m.logger.Debug("adding many new jobs", "count", len(js))
+
+	jobIDs = make([]string, len(js))
+	ready := make([]bool, len(js))
+	m.mu.Lock()
+	for i, job := range js {
+		// if the job is coalesced with a pending job, or duplicates a known job, including earlier jobs in js, don't queue
+		// the job
+		if job.EnqueueMode != nil {
+			var coalesced bool
+			if jobIDs[i], coalesced = m.coalesce(job, now); coalesced {
+				continue
+			}
+		} else if m.duplicated(job.Fingerprint, job.UniqueScope(), job.UniqueWindow(), now) {
+			jobIDs[i] = jobs.DuplicateJobID
+			continue
+		}
+
+		jobIDs[i] = m.registerJob(job, now)
+		ready[i] = m.waitForDependencies(job)
+	}
+	m.mu.Unlock()
+
+	for i, job := range js {
+		if !ready[i] {
+			continue
+		}
+
+		if job.RunAfter.Equal(now) {
+			queues[i].push(job)
+		} else {
+			m.queueFutureJob(job)
+		}
+	}
+
+	return jobIDs, nil
+}
+
+// EnqueueBatch queues a batch of jobs, returning the batch's ID
+func (m *MemBackend) EnqueueBatch(ctx context.Context, batch *jobs.Batch) (batchID string, err error) {
+	if batch.Callback != nil && batch.Callback.Queue == "" {
+		err = jobs.ErrNoQueueSpecified
+		return
+	}
+
+	for _, job := range batch.Jobs {
+		if job.EnqueueMode != nil {
+			err = jobs.ErrBatchedEnqueueMode
+			return
+		}
+	}
+
+	m.mu.Lock()
+	m.batchCount++
+	id := m.batchCount
+	b := &memBatch{
+		status:   jobs.BatchStatus{ID: fmt.Sprint(id), CreatedAt: time.Now().UTC()},
+		callback: batch.Callback,
+	}
+	m.batches[id] = b
+	m.mu.Unlock()
+
+	jobIDs, err := m.config.InterceptEnqueueMany(ctx, batch.Jobs, func(ctx context.Context, js []*jobs.Job) (
+		[]string, error,
+	) {
+		for _, job := range js {
+			job.BatchID = b.status.ID
+		}
+
+		return m.enqueueMany(ctx, js)
+	})
+	if err != nil {
+		m.mu.Lock()
+		delete(m.batches, id)
+		m.mu.Unlock()
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, jobID := range jobIDs {
+		if jobID != jobs.DuplicateJobID && jobID != "" {
+			b.status.Total++
+		}
+	}
+	b.sealed = true
+	m.completeBatch(b)
+
+	return b.status.ID, nil
+}
+
+// GetBatch retrieves a copy of the status of the batch with the given ID
+func (m *MemBackend) GetBatch(_ context.Context, batchID string) (status *jobs.BatchStatus, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.batch(batchID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", jobs.ErrBatchNotFound, batchID)
+	}
+
+	statusCopy := b.status
+	return &statusCopy, nil
+}
+
+// batch gets the batch with the given ID
+//
+// batch must be called while holding m.mu
+func (m *MemBackend) batch(batchID string) (b *memBatch, ok bool) {
+	id, err := strconv.ParseInt(batchID, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	b, ok = m.batches[id]
+	return
+}
+
+// countBatchJob counts a job that has been processed or died toward its batch, completing the batch once all of its
+// jobs have been counted
+//
+// countBatchJob must be called while holding m.mu
+func (m *MemBackend) countBatchJob(job *jobs.Job, succeeded bool) {
+	b, ok := m.batch(job.BatchID)
+	if !ok || b.status.Done() {
+		return
+	}
+
+	if succeeded {
+		b.status.Succeeded++
+	} else {
+		b.status.Dead++
+	}
+
+	m.completeBatch(b)
+}
+
+// completeBatch completes batches whose jobs have all been processed or died, enqueueing their callback jobs
+//
+// completeBatch must be called while holding m.mu
+func (m *MemBackend) completeBatch(b *memBatch) {
+	if !b.sealed || b.status.Done() || b.status.Pending() > 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	b.status.CompletedAt = &now
+	m.logger.Debug("batch completed", "batch_id", b.status.ID, "succeeded", b.status.Succeeded, "dead", b.status.Dead)
+	if b.callback == nil {
+		return
+	}
+
+	// enqueueing takes m.mu, so callbacks are enqueued once it's released
+	callback := jobs.NewBatchCallback(b.callback, b.status)
+	go func() {
+		jobID, err := m.enqueue(context.Background(), callback)
+		if err != nil {
+			m.logger.Error("unable to enqueue batch callback", "batch_id", b.status.ID, "error", err)
+			return
+		}
+
+		m.mu.Lock()
+		b.status.CallbackJobID = jobID
+		m.mu.Unlock()
+	}()
+}
+
+// registerJob assigns a newly enqueued job its ID and initial state, returning the job's ID
+//
+// registerJob must be called while holding m.mu
+func (m *MemBackend) registerJob(job *jobs.Job, now time.Time) (jobID string) {
+	m.jobCount++
+	job.ID = m.jobCount
+	job.Status = internal.JobStatusNew
+	job.CreatedAt = now
+
+	m.allJobs.Store(job.ID, job)
+	m.fingerprints[job.Fingerprint] = append(m.fingerprints[job.Fingerprint], job)
+
+	return fmt.Sprint(job.ID)
+}
+
+// applyHandlerUnique applies the uniqueness of the handler of a job's queue to jobs that don't configure their own
+func (m *MemBackend) applyHandlerUnique(job *jobs.Job) {
+	if ht, ok := m.handlers.Load(job.Queue); ok {
+		job.Unique = ht.(handler.Handler).JobUnique(job)
+	}
+}
+
+// duplicated reports whether jobs with the given fingerprint and uniqueness scope duplicate known jobs: jobs that are
+// queued, jobs that are being processed unless scope is [jobs.UniqueWhileQueued], and jobs that were processed within
+// window
+//
+// duplicated must be called while holding m.mu
+func (m *MemBackend) duplicated(fingerprint string, scope jobs.UniqueScope, window time.Duration, now time.Time) (
+	duplicated bool,
+) {
+	for _, job := range m.knownJobs(fingerprint) {
+		switch {
+		case job.Status == internal.JobStatusProcessed:
+			duplicated = duplicated || now.Sub(job.RanAt.Time) < window
+		case m.running[job.ID]:
+			duplicated = duplicated || scope != jobs.UniqueWhileQueued
+		default:
+			duplicated = true
+		}
+	}
+
+	return duplicated && scope != jobs.NotUnique
+}
+
+// knownJobs are the jobs with the given fingerprint that may be duplicated or coalesced with: jobs that are queued or
+// being processed, and the most recently processed job, which may be duplicated by jobs with any window
+//
+// Jobs that can no longer be duplicated are forgotten.
+//
+// knownJobs must be called while holding m.mu
+func (m *MemBackend) knownJobs(fingerprint string) []*jobs.Job {
+	var processed *jobs.Job
+	known := m.fingerprints[fingerprint][:0]
+	for _, job := range m.fingerprints[fingerprint] {
+		switch {
+		case job.Status == internal.JobStatusProcessed:
+			if processed == nil || job.RanAt.Time.After(processed.RanAt.Time) {
+				processed = job
+			}
+			continue
+		case job.Status == internal.JobStatusCancelled || m.dead(job):
+			continue
+		}
+
+		known = append(known, job)
+	}
+
+	if processed != nil {
+		known = append(known, processed)
+	}
+
+	if len(known) == 0 {
+		delete(m.fingerprints, fingerprint)
+	} else {
+		m.fingerprints[fingerprint] = known
+	}
+
+	return known
+}
+
+// coalesce applies a job's enqueue mode when a job with the same key is pending, i.e. new and not yet due, returning
+// the ID that the job is enqueued as: the pending job's ID when the job is debounced, or [jobs.DuplicateJobID] when the
+// job is throttled
+//
+// coalesce must be called while holding m.mu
+func (m *MemBackend) coalesce(job *jobs.Job, now time.Time) (jobID string, coalesced bool) {
+	known := m.knownJobs(job.Fingerprint)
+	for i := len(known) - 1; i >= 0; i-- {
+		pending := known[i]
+		if pending.Status != internal.JobStatusNew || m.running[pending.ID] || !pending.RunAfter.After(now) {
+			continue
+		}
+
+		if job.EnqueueMode.Strategy == jobs.Throttled {
+			return jobs.DuplicateJobID, true
+		}
+
+		pending.Payload = job.Payload
+		pending.RawPayload = job.RawPayload
+		pending.Codec = job.Codec
+		pending.Metadata = job.Metadata
+		pending.TraceContext = job.TraceContext
+		pending.RunAfter = job.RunAfter
+
+		return fmt.Sprint(pending.ID), true
+	}
+
+	return "", false
+}
+
+// dead reports whether a job is on the dead queue
+func (m *MemBackend) dead(job *jobs.Job) bool {
+	_, ok := m.deadJobs.Load(job.ID)
+	return ok
+}
+
+// checkDependencies verifies that the jobs that a job depends on exist
+func (m *MemBackend) checkDependencies(job *jobs.Job) (err error) {
+	for _, parentID := range job.DependsOn {
+		id, err := strconv.ParseInt(parentID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", jobs.ErrJobNotFound, parentID)
+		}
+
+		if _, ok := m.allJobs.Load(id); !ok {
+			return fmt.Errorf("%w: %s", jobs.ErrJobNotFound, parentID)
+		}
+	}
+
+	return nil
+}
+
+// waitForDependencies makes jobs wait for the jobs that they depend on, returning whether the job is ready to be
+// queued
+//
+// Jobs that depend on jobs that have already died are cancelled or failed according to the dependency policy.
+// waitForDependencies must be called while holding m.mu
+func (m *MemBackend) waitForDependencies(job *jobs.Job) (ready bool) {
+	var waitingFor []int64
+	for _, parentID := range job.DependsOn {
+		id, _ := strconv.ParseInt(parentID, 10, 64)
+		p, ok := m.allJobs.Load(id)
+		if !ok {
+			continue
+		}
+
+		parent := p.(*jobs.Job)
+		_, dead := m.deadJobs.Load(id)
+		if dead || parent.Status == internal.JobStatusCancelled {
+			m.failDependent(job)
+			return false
+		}
+
+		if parent.Status != internal.JobStatusProcessed {
+			waitingFor = append(waitingFor, id)
+		}
+	}
+
+	if len(waitingFor) == 0 {
+		return true
+	}
+
+	job.Status = internal.JobStatusWaiting
+	for _, id := range waitingFor {
+		m.dependents[id] = append(m.dependents[id], job)
+	}
+
+	return false
+}
+
+// releaseDependents queues the jobs that were waiting for a job which has been processed, once all the jobs that they
+// depend on have been processed
+func (m *MemBackend) releaseDependents(job *jobs.Job) {
+	var released []*jobs.Job
+	m.mu.Lock()
+	for _, dependent := range m.dependents[job.ID] {
+		if dependent.Status == internal.JobStatusWaiting && m.dependenciesProcessed(dependent) {
+			dependent.Status = internal.JobStatusNew
+			released = append(released, dependent)
+		}
+	}
+	delete(m.dependents, job.ID)
+	m.mu.Unlock()
+
+	for _, dependent := range released {
+		m.logger.Debug("releasing job whose dependencies have been processed", "job_id", dependent.ID)
+		if time.Until(dependent.RunAfter) > 0 {
+			m.queueFutureJob(dependent)
+			continue
+		}
+
+		if qc, ok := m.queues.Load(dependent.Queue); ok {
+			go qc.(*priorityQueue).push(dependent)
+		}
+	}
+}
+
+// dependenciesProcessed reports whether all the jobs that a job depends on have been processed
+//
+// dependenciesProcessed must be called while holding m.mu
+func (m *MemBackend) dependenciesProcessed(job *jobs.Job) bool {
+	for _, parentID := range job.DependsOn {
+		id, _ := strconv.ParseInt(parentID, 10, 64)
+		// jobs that have been forgotten were processed, since their dependents fail as soon as they die or are cancelled
+		p, ok := m.allJobs.Load(id)
+		if ok && p.(*jobs.Job).Status != internal.JobStatusProcessed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// failDependents cancels or fails the jobs that were waiting for a job which died, according to the dependency policy
+//
+// failDependents must be called while holding m.mu
+func (m *MemBackend) failDependents(job *jobs.Job) {
+	dependents := m.dependents[job.ID]
+	delete(m.dependents, job.ID)
+
+	for _, dependent := range dependents {
+		if dependent.Status == internal.JobStatusWaiting {
+			m.failDependent(dependent)
+		}
+	}
+}
+
+// failDependent cancels or fails a job that depends on a job which died, along with the jobs that depend on it
+//
+// failDependent must be called while holding m.mu
+func (m *MemBackend) failDependent(job *jobs.Job) {
+	m.logger.Debug("a job that this job depends on died", "job_id", job.ID, "policy", m.config.DependencyPolicy)
+	job.Error = null.StringFrom(jobs.ErrDependencyFailed.Error())
+
+	switch m.config.DependencyPolicy {
+	case jobs.FailDependents:
+		job.Status = internal.JobStatusFailed
+		m.deadJobs.Store(job.ID, job)
+	default:
+		job.Status = internal.JobStatusCancelled
+		m.completeJob(job, time.Now().UTC())
+	}
+
+	m.countBatchJob(job, false)
+	m.failDependents(job)
+}
+
 // Start starts processing jobs with the specified queue and handler
 func (m *MemBackend) Start(ctx context.Context, h handler.Handler) (err error) {
+	h.Middleware = m.config.HandlerMiddleware(h)
+
 	queueCapacity := h.QueueCapacity
 	if queueCapacity == emptyCapacity {
 		queueCapacity = defaultMemQueueCapacity
 	}
 
 	m.handlers.Store(h.Queue, h)
-	m.queues.Store(h.Queue, make(chan *jobs.Job, queueCapacity))
+	m.queues.Store(h.Queue, newPriorityQueue(queueCapacity, m.config.PriorityAging))
 
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -184,6 +708,152 @@ func (m *MemBackend) StartCron(ctx context.Context, cronSpec string, h handler.H
 	return err
 }
 
+// QueueStats reports the number of jobs waiting on every queue, along with the state of its handler
+//
+// The time that the oldest pending job became due is not known to the memory backend
+func (m *MemBackend) QueueStats(_ context.Context) (stats []neoq.QueueStats, err error) {
+	m.queues.Range(func(k, v any) bool {
+		qs := neoq.QueueStats{Queue: k.(string), Pending: v.(*priorityQueue).len()}
+		if h, ok := m.handlers.Load(qs.Queue); ok {
+			qs.Concurrency = h.(handler.Handler).Concurrency
+		}
+		stats = append(stats, qs)
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Queue < stats[j].Queue })
+
+	return
+}
+
+// GetJob retrieves a copy of the job with the given ID
+func (m *MemBackend) GetJob(_ context.Context, jobID string) (job *jobs.Job, err error) {
+	id, err := strconv.ParseInt(jobID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", jobs.ErrJobNotFound, jobID)
+	}
+
+	j, ok := m.allJobs.Load(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", jobs.ErrJobNotFound, jobID)
+	}
+
+	// jobs are mutated by the goroutines processing them, so return a snapshot of the job's current state
+	m.mu.Lock()
+	jobCopy := *(j.(*jobs.Job))
+	m.mu.Unlock()
+
+	return &jobCopy, nil
+}
+
+// ListDeadJobs lists jobs that have exhausted their retries, ordered by job ID
+func (m *MemBackend) ListDeadJobs(_ context.Context, opts ...neoq.ListOption) (deadJobs []*jobs.Job, err error) {
+	o := neoq.NewListOptions(opts...)
+
+	m.mu.Lock()
+	m.deadJobs.Range(func(_, v any) bool {
+		job := v.(*jobs.Job)
+		if o.Queue == "" || job.Queue == o.Queue {
+			jobCopy := *job
+			deadJobs = append(deadJobs, &jobCopy)
+		}
+		return true
+	})
+	m.mu.Unlock()
+
+	sort.Slice(deadJobs, func(i, j int) bool { return deadJobs[i].ID < deadJobs[j].ID })
+
+	if o.Offset() >= len(deadJobs) {
+		return []*jobs.Job{}, nil
+	}
+
+	end := o.Offset() + o.PageSize
+	if end > len(deadJobs) {
+		end = len(deadJobs)
+	}
+
+	return deadJobs[o.Offset():end], nil
+}
+
+// RequeueDeadJobs moves dead jobs back onto their queues with their retries reset
+func (m *MemBackend) RequeueDeadJobs(_ context.Context, jobIDs ...string) (count int, err error) {
+	for _, jobID := range jobIDs {
+		id, err := strconv.ParseInt(jobID, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		j, ok := m.deadJobs.Load(id)
+		if !ok {
+			continue
+		}
+		job := j.(*jobs.Job)
+
+		qc, ok := m.queues.Load(job.Queue)
+		if !ok {
+			return count, fmt.Errorf("%w: %s", handler.ErrNoProcessorForQueue, job.Queue)
+		}
+
+		m.mu.Lock()
+		if m.duplicated(job.Fingerprint, jobs.UniqueWhileActive, 0, time.Now().UTC()) {
+			m.mu.Unlock()
+			m.logger.Debug("dead job duplicates a queued job, not requeueing", "job_id", job.ID)
+			continue
+		}
+
+		m.deadJobs.Delete(id)
+		m.fingerprints[job.Fingerprint] = append(m.fingerprints[job.Fingerprint], job)
+		job.Status = internal.JobStatusNew
+		job.Retries = 0
+		job.Error = null.String{}
+		job.Result = nil
+		job.Progress = nil
+		job.RunAfter = time.Now().UTC()
+		if b, ok := m.batch(job.BatchID); ok && !b.status.Done() {
+			b.status.Dead--
+		}
+		ready := m.waitForDependencies(job)
+		m.mu.Unlock()
+
+		count++
+		if ready {
+			qc.(*priorityQueue).push(job)
+		}
+	}
+
+	return count, nil
+}
+
+// DeleteDeadJobs permanently deletes dead jobs
+func (m *MemBackend) DeleteDeadJobs(_ context.Context, jobIDs ...string) (count int, err error) {
+	for _, jobID := range jobIDs {
+		id, err := strconv.ParseInt(jobID, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := m.deadJobs.LoadAndDelete(id); ok {
+			m.allJobs.Delete(id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// PurgeDeadJobs permanently deletes all dead jobs on a queue, or all dead jobs when queue is empty
+func (m *MemBackend) PurgeDeadJobs(_ context.Context, queue string) (count int, err error) {
+	m.deadJobs.Range(func(k, v any) bool {
+		if queue == "" || v.(*jobs.Job).Queue == queue {
+			m.deadJobs.Delete(k)
+			m.allJobs.Delete(k)
+			count++
+		}
+		return true
+	})
+
+	return count, nil
+}
+
 // SetLogger sets this backend's logger
 func (m *MemBackend) SetLogger(logger logging.Logger) {
 	m.logger = logger
@@ -200,7 +870,7 @@ func (m *MemBackend) Shutdown(ctx context.Context) {
 
 // start starts a processor that handles new incoming jobs and future jobs
 func (m *MemBackend) start(ctx context.Context, queue string) (err error) {
-	var queueChan chan *jobs.Job
+	var pq *priorityQueue
 	var qc any
 	var ht any
 	var h handler.Handler
@@ -213,39 +883,39 @@ func (m *MemBackend) start(ctx context.Context, queue string) (err error) {
 	}
 
 	if qc, ok = m.queues.Load(queue); !ok {
-		m.logger.Error("error loading channel for queue", "queue", queue, "error", handler.ErrNoHandlerForQueue)
+		m.logger.Error("error loading queue", "queue", queue, "error", handler.ErrNoHandlerForQueue)
 		return err
 	}
 
 	go func() { m.scheduleFutureJobs(ctx) }()
 
 	h = ht.(handler.Handler)
-	queueChan = qc.(chan *jobs.Job)
+	pq = qc.(*priorityQueue)
+
+	// this process is the only one handling the queue, so its global concurrency caps its concurrency
+	workers := h.Concurrency
+	if h.GlobalConcurrency > 0 && h.GlobalConcurrency < workers {
+		workers = h.GlobalConcurrency
+	}
 
-	for i := 0; i < h.Concurrency; i++ {
+	for i := 0; i < workers; i++ {
 		go func() {
 			var err error
 			var job *jobs.Job
 			for {
-				select {
-				case job = <-queueChan:
-					err = m.handleJob(ctx, job, h)
-				case <-ctx.Done():
-					err = ctx.Err()
+				job, err = pq.pop(ctx)
+				if err != nil {
+					return
 				}
 
+				err = m.handleJob(ctx, job, h)
 				if err != nil {
 					if errors.Is(err, context.Canceled) {
 						return
 					}
 
 					m.logger.Error("job failed", "error", err, "job_id", job.ID)
-					runAfter := internal.CalculateBackoff(job.Retries)
-					job.RunAfter = runAfter
-					m.queueFutureJob(job)
 				}
-
-				m.fingerprints.Delete(job.Fingerprint)
 			}
 		}()
 	}
@@ -271,19 +941,29 @@ func (m *MemBackend) scheduleFutureJobs(ctx context.Context) {
 		// loop over list of future jobs, scheduling goroutines to wait for jobs that are due within the next 30 seconds
 		m.futureJobs.Range(func(_, v any) bool {
 			job := v.(*jobs.Job)
-			var queueChan chan *jobs.Job
 
+			m.mu.Lock()
 			timeUntilRunAfter := time.Until(job.RunAfter)
+			m.mu.Unlock()
 			if timeUntilRunAfter <= m.config.FutureJobWindow {
 				m.removeFutureJob(job.ID)
 				m.logger.Debug("dequeued future job", "id", job.ID, "queue", job.Queue)
 				go func(j *jobs.Job) {
 					scheduleCh := time.After(timeUntilRunAfter)
 					<-scheduleCh
+
+					// debounced jobs may have been pushed back while waiting
+					m.mu.Lock()
+					runAfter := j.RunAfter
+					m.mu.Unlock()
+					if time.Until(runAfter) > 0 {
+						m.queueFutureJob(j)
+						return
+					}
+
 					m.logger.Debug("loading job for queue", "queue", j.Queue)
 					if qc, ok := m.queues.Load(j.Queue); ok {
-						queueChan = qc.(chan *jobs.Job)
-						queueChan <- j
+						qc.(*priorityQueue).push(j)
 					} else {
 						m.logger.Error(fmt.Sprintf("no queue processor for queue '%s'", j.Queue), handler.ErrNoHandlerForQueue)
 					}
@@ -292,6 +972,8 @@ func (m *MemBackend) scheduleFutureJobs(ctx context.Context) {
 
 			return true
 		})
+		m.forgetCompletedJobs(time.Now().UTC())
+
 		select {
 		case <-ticker.C:
 			continue
@@ -302,43 +984,204 @@ func (m *MemBackend) scheduleFutureJobs(ctx context.Context) {
 }
 
 func (m *MemBackend) handleJob(ctx context.Context, job *jobs.Job, h handler.Handler) (err error) {
+	if limited, err := m.rateLimit(ctx, job, h); limited || err != nil {
+		return err
+	}
+
 	ctx = withJobContext(ctx, job)
+	ctx = jobs.WithResultWriter(ctx, resultWriter{mu: m.mu, job: job})
+	ctx = m.config.ExtractMetadata(ctx, job)
+	ctx, span := m.config.StartJobSpan(ctx, job, time.Now())
+	defer func() { neoq.EndSpan(span, err) }()
 
 	// check if the job is being retried and increment retry count accordingly
+	m.mu.Lock()
 	if job.Status != internal.JobStatusNew {
 		job.Retries++
 	}
+	attempt := job.Retries + 1
+	m.running[job.ID] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.running, job.ID)
+		m.mu.Unlock()
+	}()
 
 	if job.Deadline != nil && job.Deadline.UTC().Before(time.Now().UTC()) {
 		m.logger.Debug("job deadline is in the past, skipping", "job_id", job.ID)
 		err = jobs.ErrJobExceededDeadline
+		m.updateJob(job, err)
+		m.config.FireJobEvent(ctx, neoq.JobEvent{Type: neoq.JobSkipped, Job: job, Attempt: attempt, Err: err})
+		m.retryOrMoveToDeadQueue(ctx, h, neoq.JobEvent{Job: job, Attempt: attempt, Err: err})
 		return
 	}
 
-	err = handler.Exec(ctx, h)
-	if err != nil {
-		job.Error = null.StringFrom(err.Error())
+	m.config.FireJobEvent(ctx, neoq.JobEvent{Type: neoq.JobStarted, Job: job, Attempt: attempt})
+	start := time.Now()
+	hctx, hspan := m.config.StartHandlerSpan(ctx, job)
+	err = handler.Exec(hctx, h)
+	neoq.EndSpan(hspan, err)
+	duration := time.Since(start)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	event := neoq.JobEvent{Job: job, Attempt: attempt, Duration: duration, Err: err}
+	if d, snoozed := jobs.Snoozed(err); snoozed {
+		m.snoozeJob(job, d)
+		event.Type = neoq.JobSnoozed
+		m.config.FireJobEvent(ctx, event)
+		return nil
+	}
+
+	m.updateJob(job, err)
+	if err == nil {
+		m.releaseDependents(job)
+		event.Type = neoq.JobSucceeded
+		m.config.FireJobEvent(ctx, event)
+		return
 	}
 
+	event.Type = neoq.JobFailed
+	m.config.FireJobEvent(ctx, event)
+	m.retryOrMoveToDeadQueue(ctx, h, event)
+
 	return
 }
 
+// rateLimit waits for the handler's rate limit to allow job to be processed, or reschedules job when its key has no
+// tokens, so that it doesn't hold up jobs with other keys
+func (m *MemBackend) rateLimit(ctx context.Context, job *jobs.Job, h handler.Handler) (limited bool, err error) {
+	if !h.RateLimited() {
+		return
+	}
+
+	bucket, keyed := h.RateLimit.Bucket(job.Queue, job)
+	wait := m.rateLimiter.take(bucket, h.RateLimit, !keyed, time.Now())
+	if wait <= 0 {
+		return
+	}
+
+	if keyed {
+		m.logger.Debug("job's rate limit exceeded, rescheduling", "job_id", job.ID, "bucket", bucket)
+		m.mu.Lock()
+		job.RunAfter = time.Now().UTC().Add(h.RateLimitDelay(wait))
+		m.mu.Unlock()
+		m.queueFutureJob(job)
+		return true, nil
+	}
+
+	select {
+	case <-time.After(wait):
+		return
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// retryOrMoveToDeadQueue schedules failed jobs to be retried, or moves them to the dead queue when they can't be
+// retried
+//
+// Jobs that have exhausted their retries, or that failed permanently, are not retried. See [jobs.IsPermanent].
+func (m *MemBackend) retryOrMoveToDeadQueue(ctx context.Context, h handler.Handler, event neoq.JobEvent) {
+	job := event.Job
+	if job.Retries >= h.JobMaxRetries(job) || jobs.IsPermanent(event.Err) {
+		m.moveToDeadQueue(job)
+		event.Type = neoq.JobDead
+		m.config.FireJobEvent(ctx, event)
+		return
+	}
+
+	runAfter := time.Now().UTC().Add(h.RetryDelay(job, event.Err))
+	m.mu.Lock()
+	job.RunAfter = runAfter
+	m.mu.Unlock()
+	event.Type = neoq.JobRetried
+	m.config.FireJobEvent(ctx, event)
+	m.queueFutureJob(job)
+}
+
+// snoozeJob reschedules jobs to run again after d
+//
+// Snoozed jobs become new again, so that their next run is not counted as a retry
+func (m *MemBackend) snoozeJob(job *jobs.Job, d time.Duration) {
+	m.mu.Lock()
+	if job.Status != internal.JobStatusNew {
+		job.Retries--
+	}
+	job.Status = internal.JobStatusNew
+	job.Snoozes++
+	job.RanAt = null.TimeFrom(time.Now().UTC())
+	job.RunAfter = time.Now().UTC().Add(d)
+	m.mu.Unlock()
+
+	m.queueFutureJob(job)
+}
+
+// updateJob records the outcome of a job's most recent run
+func (m *MemBackend) updateJob(job *jobs.Job, jobErr error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job.RanAt = null.TimeFrom(time.Now().UTC())
+	if jobErr != nil {
+		job.Status = internal.JobStatusFailed
+		job.Error = null.StringFrom(jobErr.Error())
+		return
+	}
+
+	job.Status = internal.JobStatusProcessed
+	m.completeJob(job, job.RanAt.Time)
+	m.countBatchJob(job, true)
+}
+
+// moveToDeadQueue moves jobs that have exhausted their retries to the dead queue, cancelling or failing the jobs that
+// depend on them
+func (m *MemBackend) moveToDeadQueue(job *jobs.Job) {
+	m.logger.Debug("job exhausted its retries, moving it to the dead queue", "job_id", job.ID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deadJobs.Store(job.ID, job)
+	m.countBatchJob(job, false)
+	m.failDependents(job)
+}
+
 // queueFutureJob queues a future job for eventual execution
 func (m *MemBackend) queueFutureJob(job *jobs.Job) {
-	m.fingerprints.Store(job.Fingerprint, job)
 	m.futureJobs.Store(job.ID, job)
 }
 
 // removeFutureJob removes a future job from the in-memory list of jobs that will execute in the future
 func (m *MemBackend) removeFutureJob(jobID int64) {
-	if j, ok := m.futureJobs.Load(jobID); ok {
-		job := j.(*jobs.Job)
-		m.fingerprints.Delete(job.Fingerprint)
-		m.futureJobs.Delete(job.ID)
-	}
+	m.futureJobs.Delete(jobID)
 }
 
 // withJobContext creates a new context with the Job set
 func withJobContext(ctx context.Context, j *jobs.Job) context.Context {
 	return context.WithValue(ctx, internal.JobCtxVarKey, j)
 }
+
+// resultWriter is the [jobs.ResultWriter] of the memory backend, which writes results and progress to the jobs
+// themselves
+type resultWriter struct {
+	mu  *sync.Mutex
+	job *jobs.Job
+}
+
+func (w resultWriter) WriteResult(_ context.Context, result []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.job.Result = append([]byte(nil), result...)
+	return nil
+}
+
+func (w resultWriter) WriteProgress(_ context.Context, progress jobs.Progress) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.job.Progress = &progress
+	return nil
+}
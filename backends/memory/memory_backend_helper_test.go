@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/acaloiaro/neoq"
+	"github.com/acaloiaro/neoq/jobs"
 	"github.com/acaloiaro/neoq/logging"
 	"github.com/robfig/cron"
 )
@@ -14,7 +15,7 @@ import (
 // TestingBackend initializes a backend for testing purposes
 func TestingBackend(conf *neoq.Config,
 	c *cron.Cron,
-	queues, h, futureJobs, fingerprints *sync.Map,
+	queues, h, futureJobs *sync.Map,
 	logger logging.Logger,
 ) neoq.BackendInitializer {
 	return func(ctx context.Context, opts ...neoq.ConfigOption) (backend neoq.Neoq, err error) {
@@ -25,7 +26,13 @@ func TestingBackend(conf *neoq.Config,
 			queues:       queues,
 			handlers:     h,
 			futureJobs:   futureJobs,
-			fingerprints: fingerprints,
+			fingerprints: map[string][]*jobs.Job{},
+			allJobs:      &sync.Map{},
+			deadJobs:     &sync.Map{},
+			dependents:   map[int64][]*jobs.Job{},
+			batches:      map[int64]*memBatch{},
+			running:      map[int64]bool{},
+			rateLimiter:  newRateLimiter(),
 			logger:       logger,
 			jobCount:     0,
 			cancelFuncs:  []context.CancelFunc{},
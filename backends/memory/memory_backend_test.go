@@ -5,18 +5,25 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/acaloiaro/neoq"
 	"github.com/acaloiaro/neoq/backends/memory"
+	"github.com/acaloiaro/neoq/codec"
 	"github.com/acaloiaro/neoq/handler"
+	"github.com/acaloiaro/neoq/internal"
 	"github.com/acaloiaro/neoq/jobs"
 	"github.com/acaloiaro/neoq/logging"
 	"github.com/pkg/errors"
 	"github.com/robfig/cron"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slog"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 const (
@@ -175,7 +182,6 @@ func TestFutureJobScheduling(t *testing.T) {
 		&sync.Map{},
 		&sync.Map{},
 		testFutureJobs,
-		&sync.Map{},
 		slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logging.LogLevelDebug})))
 
 	nq, err := neoq.New(ctx, neoq.WithBackend(testBackend))
@@ -367,3 +373,2088 @@ func TestCron(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// TestGetJob tests that jobs can be looked up by ID once they've been enqueued, and that their status reflects the
+// outcome of their handlers
+func TestGetJob(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan bool)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	})
+
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jid, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": "hello world"},
+	})
+	if err != nil || jid == jobs.DuplicateJobID {
+		t.Fatal("job was not enqueued. either it was duplicate or this error caused it:", err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-done:
+	}
+
+	var j *jobs.Job
+	timeoutTimer := time.After(5 * time.Second)
+	for j == nil || j.Status != internal.JobStatusProcessed {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("job status was never updated to '%s': %+v", internal.JobStatusProcessed, j)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		j, err = nq.GetJob(ctx, jid)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if j.Queue != queue || j.Payload["message"] != "hello world" || !j.RanAt.Valid {
+		t.Errorf("job was not retrieved as it was enqueued: %+v", j)
+	}
+
+	_, err = nq.GetJob(ctx, "1000000")
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected '%v', got: %v", jobs.ErrJobNotFound, err)
+	}
+}
+
+// TestDeadJobs tests that jobs which exhaust their retries can be listed, requeued, and deleted
+// nolint: gocognit, gocyclo
+func TestDeadJobs(t *testing.T) {
+	const numJobs = 3
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	ran := make(chan bool, numJobs*2)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		ran <- true
+		return errors.New("something bad happened")
+	})
+
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < numJobs; i++ {
+		// jobs that have already been retried as many times as they're allowed die on their first failure
+		_, err = nq.Enqueue(ctx, &jobs.Job{
+			Queue:      queue,
+			Payload:    map[string]interface{}{"message": fmt.Sprintf("hello world: %d", i)},
+			Retries:    1,
+			MaxRetries: 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deadJobs []*jobs.Job
+	timeoutTimer := time.After(5 * time.Second)
+	for len(deadJobs) < numJobs {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("expected %d dead jobs, found: %d", numJobs, len(deadJobs))
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		deadJobs, err = nq.ListDeadJobs(ctx, neoq.ListQueue(queue))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := nq.ListDeadJobs(ctx, neoq.ListPage(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].ID != deadJobs[2].ID {
+		t.Errorf("expected the second page to contain only job %d, got: %v", deadJobs[2].ID, page)
+	}
+
+	<-ran
+	<-ran
+	<-ran
+	count, err := nq.RequeueDeadJobs(ctx, fmt.Sprint(deadJobs[0].ID))
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 job to be requeued, got %d: %v", count, err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("requeued job was never processed")
+	case <-ran:
+	}
+
+	requeued, err := nq.GetJob(ctx, fmt.Sprint(deadJobs[0].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued.Retries != 0 {
+		t.Errorf("requeued jobs should have their retries reset, but it has %d", requeued.Retries)
+	}
+
+	count, err = nq.DeleteDeadJobs(ctx, fmt.Sprint(deadJobs[1].ID))
+	if err != nil || count != 1 {
+		t.Errorf("expected 1 job to be deleted, got %d: %v", count, err)
+	}
+
+	count, err = nq.PurgeDeadJobs(ctx, queue)
+	if err != nil || count != 1 {
+		t.Errorf("expected 1 job to be purged, got %d: %v", count, err)
+	}
+
+	_, err = nq.GetJob(ctx, fmt.Sprint(deadJobs[1].ID))
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected deleted dead job to not be found, got: %v", err)
+	}
+}
+
+// TestEnqueueMany tests that many jobs can be enqueued at once, and that duplicates are reported per job
+func TestEnqueueMany(t *testing.T) {
+	const numJobs = 100
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan bool)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	})
+
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	js := []*jobs.Job{}
+	for i := 0; i < numJobs; i++ {
+		js = append(js, &jobs.Job{
+			Queue:   queue,
+			Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", i)},
+		})
+	}
+	// the last job duplicates the first
+	js = append(js, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "hello world: 0"}})
+
+	jobIDs, err := nq.EnqueueMany(ctx, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobIDs) != numJobs+1 {
+		t.Fatalf("expected %d job IDs, got: %d", numJobs+1, len(jobIDs))
+	}
+
+	if jobIDs[numJobs] != jobs.DuplicateJobID {
+		t.Errorf("expected the last job to be a duplicate, but its ID is: %s", jobIDs[numJobs])
+	}
+
+	timeoutTimer := time.After(5 * time.Second)
+	for i := 0; i < numJobs; i++ {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("expected %d jobs to be processed, but only %d were", numJobs, i)
+		case <-done:
+		}
+	}
+}
+
+type greeting struct {
+	Message string `json:"message"`
+	Count   int64  `json:"count"`
+}
+
+// TestTypedHandler tests that typed handlers receive decoded payloads, and that jobs with payloads that can't be
+// decoded are not retried
+func TestTypedHandler(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan greeting)
+	h := handler.NewTyped(queue, func(_ context.Context, g greeting) (err error) {
+		done <- g
+		return
+	})
+
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := greeting{Message: "hello world", Count: 1<<53 + 1}
+	job, err := jobs.NewTyped(queue, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case g := <-done:
+		if g != expected {
+			t.Errorf("expected payload %+v, got: %+v", expected, g)
+		}
+	}
+
+	invalidJobID, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": "hello world", "count": "not a number"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeoutTimer := time.After(5 * time.Second)
+	for {
+		select {
+		case <-timeoutTimer:
+			t.Fatal("job with an invalid payload was not moved to the dead queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		var deadJobs []*jobs.Job
+		deadJobs, err = nq.ListDeadJobs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(deadJobs) == 1 && fmt.Sprint(deadJobs[0].ID) == invalidJobID {
+			if !strings.Contains(deadJobs[0].Error.String, jobs.ErrInvalidPayload.Error()) {
+				t.Errorf("expected the job's error to be '%v', got: %s", jobs.ErrInvalidPayload, deadJobs[0].Error.String)
+			}
+			return
+		}
+	}
+}
+
+// TestCodecs tests that jobs with payloads encoded by codecs other than JSON are decoded by their handlers
+func TestCodecs(t *testing.T) {
+	const (
+		rawQueue      = "raw"
+		protobufQueue = "protobuf"
+		msgpackQueue  = "msgpack"
+	)
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), neoq.WithCodec(msgpackQueue, codec.Msgpack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan any)
+	handlers := []handler.Handler{
+		handler.NewTyped(rawQueue, func(_ context.Context, b []byte) (err error) {
+			done <- string(b)
+			return
+		}),
+		handler.NewTyped(protobufQueue, func(_ context.Context, m *wrapperspb.StringValue) (err error) {
+			done <- m.GetValue()
+			return
+		}),
+		handler.New(msgpackQueue, func(ctx context.Context) (err error) {
+			var j *jobs.Job
+			j, err = jobs.FromContext(ctx)
+			if err != nil {
+				return
+			}
+
+			if j.Codec != codec.Msgpack.Name() {
+				return fmt.Errorf("expected codec %s, got: %s", codec.Msgpack.Name(), j.Codec) // nolint: goerr113
+			}
+
+			var p map[string]any
+			err = j.DecodePayload(&p)
+			if err != nil {
+				return
+			}
+
+			done <- p["message"]
+			return
+		}),
+	}
+	for _, h := range handlers {
+		if err = nq.Start(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rawJob, err := jobs.NewEncoded(rawQueue, []byte("hello raw"), codec.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	protobufJob, err := jobs.NewEncoded(protobufQueue, wrapperspb.String("hello protobuf"), codec.Protobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgpackJob := &jobs.Job{Queue: msgpackQueue, Payload: map[string]any{"message": "hello msgpack"}}
+
+	for _, job := range []*jobs.Job{rawJob, protobufJob, msgpackJob} {
+		_, err = nq.Enqueue(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := map[any]bool{}
+	for len(received) < 3 {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for jobs, received: %v", received)
+		case v := <-done:
+			received[v] = true
+		}
+	}
+
+	for _, expected := range []string{"hello raw", "hello protobuf", "hello msgpack"} {
+		if !received[expected] {
+			t.Errorf("expected payload '%s' to be received, got: %v", expected, received)
+		}
+	}
+
+	// jobs are fingerprinted by their encoded payloads, so they duplicate queued jobs with the same encoded payload
+	var jobID string
+	for i := 0; i < 2; i++ {
+		futureJob, err := jobs.NewEncoded(rawQueue, []byte("hello future"), codec.Raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		futureJob.RunAfter = time.Now().Add(time.Hour)
+
+		jobID, err = nq.Enqueue(ctx, futureJob)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if jobID != jobs.DuplicateJobID {
+		t.Errorf("expected job with the same encoded payload to be a duplicate, got job ID: %s", jobID)
+	}
+}
+
+// TestMiddleware tests that backend-wide middleware wraps handler middleware, which wraps handlers, for both regular
+// and periodic jobs
+func TestMiddleware(t *testing.T) {
+	calls := make(chan string, 100) // nolint: gomnd
+	record := func(name string) handler.Middleware {
+		return func(next handler.Func) handler.Func {
+			return func(ctx context.Context) error {
+				select {
+				case calls <- name:
+				default:
+				}
+				return next(ctx)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithMiddleware(record("backend1"), record("backend2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, record("handler")(func(_ context.Context) (err error) { return }),
+		handler.WithMiddleware(record("handler_mw1"), record("handler_mw2")))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"message": "hello world"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectCalls := func(expected ...string) {
+		t.Helper()
+		for _, e := range expected {
+			select {
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timed out waiting for middleware '%s'", e)
+			case c := <-calls:
+				if c != e {
+					t.Fatalf("expected middleware '%s' to be called, got: '%s'", e, c)
+				}
+			}
+		}
+	}
+
+	expectCalls("backend1", "backend2", "handler_mw1", "handler_mw2", "handler")
+
+	ph := handler.NewPeriodic(record("periodic")(func(_ context.Context) (err error) { return }),
+		handler.WithMiddleware(record("periodic_mw")), handler.Concurrency(1))
+	if err = nq.StartCron(ctx, "* * * * * *", ph); err != nil {
+		t.Fatal(err)
+	}
+
+	expectCalls("backend1", "backend2", "periodic_mw", "periodic")
+}
+
+// TestEnqueueInterceptors tests that enqueue interceptors can modify jobs, reject them, and observe their job IDs
+func TestEnqueueInterceptors(t *testing.T) {
+	errInvalidJob := errors.New("invalid job")
+	var mu sync.Mutex
+	observedIDs := []string{}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithEnqueueInterceptor(
+			func(ctx context.Context, job *jobs.Job, next neoq.EnqueueFunc) (jobID string, err error) {
+				jobID, err = next(ctx, job)
+				mu.Lock()
+				observedIDs = append(observedIDs, jobID)
+				mu.Unlock()
+				return
+			},
+			func(ctx context.Context, job *jobs.Job, next neoq.EnqueueFunc) (jobID string, err error) {
+				if _, ok := job.Payload["invalid"]; ok {
+					return "", errInvalidJob
+				}
+
+				job.Payload["tenant"] = "acme"
+				return next(ctx, job)
+			}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 10) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprint(j.Payload["tenant"])
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jobID, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"message": "hello world"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case tenant := <-done:
+		if tenant != "acme" {
+			t.Errorf("expected the interceptor to stamp the job's tenant, got: %s", tenant)
+		}
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"invalid": true}})
+	if !errors.Is(err, errInvalidJob) {
+		t.Errorf("expected invalid job to be rejected with '%v', got: %v", errInvalidJob, err)
+	}
+
+	// a rejected job aborts the entire batch
+	_, err = nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]any{"message": "hello world 1"}},
+		{Queue: queue, Payload: map[string]any{"invalid": true}},
+	})
+	if !errors.Is(err, errInvalidJob) {
+		t.Errorf("expected batch with an invalid job to be rejected with '%v', got: %v", errInvalidJob, err)
+	}
+
+	jobIDs, err := nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]any{"message": "hello world 2"}},
+		{Queue: queue, Payload: map[string]any{"message": "hello world 3"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range jobIDs {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case tenant := <-done:
+			if tenant != "acme" {
+				t.Errorf("expected the interceptor to stamp the job's tenant, got: %s", tenant)
+			}
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expectedIDs := []string{jobID, "", "", "", jobIDs[0], jobIDs[1]}
+	if len(observedIDs) != len(expectedIDs) {
+		t.Fatalf("expected interceptor to observe job IDs %v, got: %v", expectedIDs, observedIDs)
+	}
+
+	// batch job IDs are observed concurrently, so only the non-batch job's position is deterministic
+	if observedIDs[0] != jobID || observedIDs[1] != "" {
+		t.Errorf("expected interceptor to observe job IDs %v, got: %v", expectedIDs, observedIDs)
+	}
+
+	for _, id := range jobIDs {
+		if !strings.Contains(strings.Join(observedIDs[4:], ","), id) {
+			t.Errorf("expected interceptor to observe job ID %s, got: %v", id, observedIDs)
+		}
+	}
+}
+
+// TestJobHooks tests that lifecycle hooks are called as jobs succeed, fail, retry, die, and are skipped
+func TestJobHooks(t *testing.T) {
+	type firedEvent struct {
+		message   string
+		eventType neoq.JobEventType
+		attempt   int
+	}
+
+	events := make(chan firedEvent, 100) // nolint: gomnd
+	hook := func(_ context.Context, e neoq.JobEvent) {
+		events <- firedEvent{message: fmt.Sprint(e.Job.Payload["message"]), eventType: e.Type, attempt: e.Attempt}
+		if e.Type == neoq.JobSucceeded && e.Duration <= 0 {
+			t.Error("expected succeeded jobs to have a duration")
+		}
+		if e.Type == neoq.JobFailed && e.Err == nil {
+			t.Error("expected failed jobs to have an error")
+		}
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithOnJobStarted(hook),
+		neoq.WithOnJobSucceeded(hook),
+		neoq.WithOnJobFailed(hook),
+		neoq.WithOnJobRetried(hook),
+		neoq.WithOnJobDead(hook),
+		neoq.WithOnJobSkipped(hook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Payload["message"] != "succeed" {
+			err = errors.New("job failed")
+		}
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(-time.Hour)
+	js := []*jobs.Job{
+		{Queue: queue, Payload: map[string]any{"message": "succeed"}},
+		{Queue: queue, Payload: map[string]any{"message": "retry"}, MaxRetries: 5},
+		{Queue: queue, Payload: map[string]any{"message": "die"}, Retries: 1, MaxRetries: 1},
+		{Queue: queue, Payload: map[string]any{"message": "skip"}, Deadline: &deadline},
+	}
+	for _, job := range js {
+		if _, err = nq.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []firedEvent{
+		{"succeed", neoq.JobStarted, 1},
+		{"succeed", neoq.JobSucceeded, 1},
+		{"retry", neoq.JobStarted, 1},
+		{"retry", neoq.JobFailed, 1},
+		{"retry", neoq.JobRetried, 1},
+		{"die", neoq.JobStarted, 2},
+		{"die", neoq.JobFailed, 2},
+		{"die", neoq.JobDead, 2},
+		{"skip", neoq.JobSkipped, 1},
+		{"skip", neoq.JobDead, 1},
+	}
+	for _, e := range expected {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event: %+v", e)
+		case fired := <-events:
+			if fired != e {
+				t.Fatalf("expected event %+v, got: %+v", e, fired)
+			}
+		}
+	}
+}
+
+// TestTracing tests that handlers' spans are children of the spans that enqueued their jobs
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), neoq.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan trace.SpanContext, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		done <- trace.SpanContextFromContext(ctx)
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	enqueueCtx, parent := tp.Tracer("test").Start(ctx, "request")
+	_, err = nq.Enqueue(enqueueCtx, &jobs.Job{Queue: queue, Payload: map[string]any{"message": "hello world"}})
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case sc := <-done:
+		if sc.TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("expected handler to run in trace %s, got: %s", parent.SpanContext().TraceID(), sc.TraceID())
+		}
+	}
+
+	// the processing span ends just after the job's handler returns
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	timeout := time.After(5 * time.Second)
+	for spans["neoq.process"] == nil {
+		select {
+		case <-timeout:
+			t.Fatalf("expected job processing to be traced, got spans: %v", spans)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+	}
+
+	if spans["neoq.enqueue"].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("expected the enqueue span to be a child of the enqueueing span")
+	}
+
+	if spans["neoq.process"].Parent().SpanID() != spans["neoq.enqueue"].SpanContext().SpanID() {
+		t.Error("expected the process span to be a child of the enqueue span")
+	}
+
+	if spans["neoq.handle"].Parent().SpanID() != spans["neoq.process"].SpanContext().SpanID() {
+		t.Error("expected the handle span to be a child of the process span")
+	}
+}
+
+// TestEnqueueManyTracing tests that enqueueing many jobs at once is traced with a single span, which the jobs'
+// processing spans are children of
+func TestEnqueueManyTracing(t *testing.T) {
+	const numJobs = 3
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), neoq.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan bool, numJobs)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	js := make([]*jobs.Job, numJobs)
+	for i := range js {
+		js[i] = &jobs.Job{Queue: queue, Payload: map[string]any{"message": fmt.Sprintf("hello world %d", i)}}
+	}
+	if _, err = nq.EnqueueMany(ctx, js); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < numJobs; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-done:
+		}
+	}
+
+	// the processing spans end just after the jobs' handlers return
+	var enqueueSpans, processSpans []sdktrace.ReadOnlySpan
+	timeout := time.After(5 * time.Second)
+	for len(processSpans) < numJobs {
+		select {
+		case <-timeout:
+			t.Fatalf("expected %d jobs' processing to be traced, got: %d", numJobs, len(processSpans))
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		enqueueSpans, processSpans = nil, nil
+		for _, span := range recorder.Ended() {
+			switch span.Name() {
+			case "neoq.enqueue", "neoq.enqueue_many":
+				enqueueSpans = append(enqueueSpans, span)
+			case "neoq.process":
+				processSpans = append(processSpans, span)
+			}
+		}
+	}
+
+	if len(enqueueSpans) != 1 || enqueueSpans[0].Name() != "neoq.enqueue_many" {
+		t.Fatalf("expected jobs to be enqueued in a single neoq.enqueue_many span, got: %d spans", len(enqueueSpans))
+	}
+
+	for _, span := range processSpans {
+		if span.Parent().SpanID() != enqueueSpans[0].SpanContext().SpanID() {
+			t.Error("expected the process spans to be children of the enqueue_many span")
+		}
+	}
+}
+
+type requestIDKey struct{}
+
+// TestMetadata tests that job metadata carries propagated context values to handlers, and doesn't affect job
+// deduplication
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithContextPropagator(neoq.ContextValuePropagator("request_id", requestIDKey{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprintf("%v %s %s", ctx.Value(requestIDKey{}), j.Metadata["request_id"], j.Metadata["tenant"])
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]any{"message": "hello world"}
+	enqueueCtx := context.WithValue(ctx, requestIDKey{}, "req-1")
+	jobID, err := nq.Enqueue(enqueueCtx, &jobs.Job{
+		Queue:    queue,
+		Payload:  payload,
+		Metadata: map[string]string{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case got := <-done:
+		if got != "req-1 req-1 acme" {
+			t.Errorf("expected handler to observe request ID and metadata 'req-1 req-1 acme', got: '%s'", got)
+		}
+	}
+
+	// jobs are only deduplicated while unprocessed, so enqueue a job that won't be processed yet
+	runAfter := time.Now().Add(time.Hour)
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, RunAfter: runAfter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duplicateID, err := nq.Enqueue(context.WithValue(ctx, requestIDKey{}, "req-2"), &jobs.Job{
+		Queue:    queue,
+		Payload:  payload,
+		RunAfter: runAfter,
+		Metadata: map[string]string{"tenant": "globex"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if duplicateID != jobs.DuplicateJobID {
+		t.Errorf("expected jobs that differ only by metadata to be duplicates, got job ID: %s (first job: %s)",
+			duplicateID, jobID)
+	}
+}
+
+// TestMaxRetries tests that jobs' maximum retries, and their handlers' defaults, determine whether failed jobs retry
+func TestMaxRetries(t *testing.T) {
+	const noRetriesQueue = "no_retries"
+	outcomes := make(chan string, 10) // nolint: gomnd
+	recordOutcome := func(outcome string) neoq.JobHook {
+		return func(_ context.Context, event neoq.JobEvent) {
+			outcomes <- fmt.Sprintf("%s %s", event.Job.Payload["name"], outcome)
+		}
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithOnJobRetried(recordOutcome("retried")),
+		neoq.WithOnJobDead(recordOutcome("dead")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	fail := func(_ context.Context) error { return errors.New("something bad happened") }
+	if err = nq.Start(ctx, handler.New(queue, fail)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = nq.Start(ctx, handler.New(noRetriesQueue, fail, handler.MaxRetries(jobs.NoRetries))); err != nil {
+		t.Fatal(err)
+	}
+
+	testJobs := map[string]*jobs.Job{
+		"job_no_retries":     {Queue: queue, MaxRetries: jobs.NoRetries},
+		"job_default":        {Queue: queue},
+		"handler_no_retries": {Queue: noRetriesQueue},
+		"job_overrides":      {Queue: noRetriesQueue, MaxRetries: 1},
+	}
+	expected := map[string]bool{
+		"job_no_retries dead":     true,
+		"job_default retried":     true,
+		"handler_no_retries dead": true,
+		"job_overrides retried":   true,
+	}
+	for name, job := range testJobs {
+		job.Payload = map[string]any{"name": name}
+		if _, err = nq.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range testJobs {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case outcome := <-outcomes:
+			if !expected[outcome] {
+				t.Errorf("unexpected job outcome: %s", outcome)
+			}
+		}
+	}
+
+	deadJobs, err := nq.ListDeadJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deadJobs) != 2 { // nolint: gomnd
+		t.Errorf("expected the jobs that may not retry to be dead, got %d dead jobs", len(deadJobs))
+	}
+}
+
+// TestRetryPolicy tests that failed jobs are retried per their own retry policies, or their handlers'
+func TestRetryPolicy(t *testing.T) {
+	const slowQueue = "slow_retries"
+	errTransient := errors.New("transient error")
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), neoq.WithJobCheckInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	var mu sync.Mutex
+	policyAttempts := []int{}
+	policy := jobs.RetryPolicyFunc(func(attempt int, err error) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		policyAttempts = append(policyAttempts, attempt)
+		if errors.Is(err, errTransient) {
+			return time.Millisecond
+		}
+
+		return time.Hour
+	})
+
+	// jobs fail on every attempt before their third
+	done := make(chan string, 2) // nolint: gomnd
+	failTwice := func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Retries < 2 { // nolint: gomnd
+			return errTransient
+		}
+
+		done <- j.Queue
+		return
+	}
+
+	if err = nq.Start(ctx, handler.New(queue, failTwice, handler.RetryPolicy(policy))); err != nil {
+		t.Fatal(err)
+	}
+
+	h := handler.New(slowQueue, failTwice, handler.RetryPolicy(jobs.ConstantBackoff(time.Hour)))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"message": "hello world"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   slowQueue,
+		Payload: map[string]any{"message": "hello world"},
+		Backoff: jobs.ExponentialBackoff(time.Millisecond, 10*time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected failed jobs to be retried per their retry policies")
+		case <-done:
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(policyAttempts) != "[1 2]" {
+		t.Errorf("expected the handler's retry policy to be consulted after attempts [1 2], got: %v", policyAttempts)
+	}
+}
+
+// TestPermanentErrors tests that jobs which fail permanently are not retried, and have their errors recorded
+func TestPermanentErrors(t *testing.T) {
+	errUserDeleted := errors.New("user deleted")
+	dead := make(chan neoq.JobEvent, 1)
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithOnJobDead(func(_ context.Context, event neoq.JobEvent) { dead <- event }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) error {
+		return jobs.Permanent(errUserDeleted)
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the permanently failed job to be dead")
+	case event := <-dead:
+		if !errors.Is(event.Err, errUserDeleted) || !jobs.IsPermanent(event.Err) {
+			t.Errorf("expected the job's error to be permanent and wrap '%v', got: %v", errUserDeleted, event.Err)
+		}
+
+		if event.Attempt != 1 {
+			t.Errorf("expected the job to be attempted once, got: %d", event.Attempt)
+		}
+	}
+}
+
+// TestSnooze tests that handlers can snooze their jobs without failing them or counting retries
+func TestSnooze(t *testing.T) {
+	snoozes := make(chan neoq.JobEvent, 2) // nolint: gomnd
+	failures := make(chan neoq.JobEvent, 1)
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithJobCheckInterval(10*time.Millisecond),
+		neoq.WithOnJobSnoozed(func(_ context.Context, event neoq.JobEvent) { snoozes <- event }),
+		neoq.WithOnJobFailed(func(_ context.Context, event neoq.JobEvent) { failures <- event }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan *jobs.Job, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Snoozes < 2 { // nolint: gomnd
+			return jobs.Snooze(time.Millisecond)
+		}
+
+		done <- j
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the snoozed job to run again")
+	case j := <-done:
+		if j.Retries != 0 || j.Snoozes != 2 {
+			t.Errorf("expected the job to be snoozed twice without retrying, got %d snoozes and %d retries", j.Snoozes,
+				j.Retries)
+		}
+	}
+
+	select {
+	case event := <-failures:
+		t.Errorf("expected snoozed jobs not to fail, got: %v", event.Err)
+	default:
+	}
+}
+
+// TestPriority tests that jobs with higher priorities are processed first
+func TestPriority(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	blocking := make(chan bool)
+	release := make(chan bool)
+	processed := make(chan int, 3) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		// block the queue's only worker until all jobs are queued
+		if j.Payload["block"] == true {
+			blocking <- true
+			<-release
+			return
+		}
+
+		processed <- j.Priority
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"block": true}}); err != nil {
+		t.Fatal(err)
+	}
+	<-blocking
+
+	for _, priority := range []int{-1, 0, 1} {
+		_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Priority: priority, Payload: map[string]any{"priority": priority}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+
+	for _, expected := range []int{1, 0, -1} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case priority := <-processed:
+			if priority != expected {
+				t.Errorf("expected the job with priority %d to be processed next, got priority %d", expected, priority)
+			}
+		}
+	}
+}
+
+// TestPriorityAging tests that jobs with low priorities are processed first once they've waited long enough
+func TestPriorityAging(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithJobCheckInterval(10*time.Millisecond),
+		neoq.WithPriorityAging(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	blocking := make(chan bool)
+	release := make(chan bool)
+	processed := make(chan int, 2) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Payload["block"] == true {
+			blocking <- true
+			<-release
+			return
+		}
+
+		processed <- j.Priority
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"block": true}}); err != nil {
+		t.Fatal(err)
+	}
+	<-blocking
+
+	// the low-priority job has been due for two minutes, so it has aged past the high-priority job
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Priority: 1, Payload: map[string]any{"priority": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:    queue,
+		Payload:  map[string]any{"priority": 0},
+		RunAfter: time.Now().UTC().Add(-2 * time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// jobs that were due in the past are queued by the future job scheduler
+	timeout := time.After(5 * time.Second)
+	for pending := 0; pending < 2; {
+		select {
+		case <-timeout:
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		stats, err := nq.QueueStats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = stats[0].Pending
+	}
+	close(release)
+
+	for _, expected := range []int{0, 1} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case priority := <-processed:
+			if priority != expected {
+				t.Errorf("expected the job with priority %d to be processed next, got priority %d", expected, priority)
+			}
+		}
+	}
+}
+
+// TestResults tests that the results and progress written by handlers can be looked up with their jobs
+func TestResults(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	progressed := make(chan bool)
+	release := make(chan bool)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		if err = jobs.WriteProgress(ctx, 42, "8,400 of 20,000 rows imported"); err != nil {
+			return
+		}
+		progressed <- true
+		<-release
+
+		if err = jobs.WriteProgress(ctx, 100, "20,000 of 20,000 rows imported"); err != nil {
+			return
+		}
+
+		return jobs.WriteResult(ctx, []byte("imported"))
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jobID, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"file": "rows.csv"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-progressed:
+	}
+
+	job, err := nq.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Progress == nil || *job.Progress != (jobs.Progress{Percent: 42, Message: "8,400 of 20,000 rows imported"}) {
+		t.Errorf("expected the job's progress to be reported while it runs, got: %+v", job.Progress)
+	}
+	close(release)
+
+	timeout := time.After(5 * time.Second)
+	for job.Status != internal.JobStatusProcessed {
+		select {
+		case <-timeout:
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		if job, err = nq.GetJob(ctx, jobID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if string(job.Result) != "imported" || job.Progress == nil || job.Progress.Percent != 100 {
+		t.Errorf("expected the job's result and progress to be persisted, got result %q and progress %+v", job.Result,
+			job.Progress)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	release := make(chan bool)
+	processed := make(chan string, 2)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		name := j.Payload["name"].(string)
+		if name == "parent" {
+			<-release
+		}
+		processed <- name
+		return
+	})
+	h.WithOptions(handler.Concurrency(2))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"name": "orphan"}, DependsOn: []string{"42"}})
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected jobs that depend on unknown jobs to be rejected, got: %v", err)
+	}
+
+	parentID, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"name": "parent"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	childID, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:     queue,
+		Payload:   map[string]any{"name": "child"},
+		DependsOn: []string{parentID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := nq.GetJob(ctx, childID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.Status != internal.JobStatusWaiting {
+		t.Errorf("expected the child job to wait for its parent, got status: %s", child.Status)
+	}
+	close(release)
+
+	for _, expected := range []string{"parent", "child"} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case name := <-processed:
+			if name != expected {
+				t.Errorf("expected %s to be processed, got: %s", expected, name)
+			}
+		}
+	}
+}
+
+func TestDependencyPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy jobs.DependencyPolicy
+		status string
+	}{
+		{name: "cancel dependents", policy: jobs.CancelDependents, status: internal.JobStatusCancelled},
+		{name: "fail dependents", policy: jobs.FailDependents, status: internal.JobStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), neoq.WithDependencyPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nq.Shutdown(ctx)
+
+			release := make(chan bool)
+			h := handler.New(queue, func(ctx context.Context) (err error) {
+				<-release
+				return jobs.Permanent(errors.New("the parent job failed"))
+			})
+			if err = nq.Start(ctx, h); err != nil {
+				t.Fatal(err)
+			}
+
+			parentID, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"name": "parent"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			childID, err := nq.Enqueue(ctx, &jobs.Job{
+				Queue:     queue,
+				Payload:   map[string]any{"name": "child"},
+				DependsOn: []string{parentID},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			grandchildID, err := nq.Enqueue(ctx, &jobs.Job{
+				Queue:     queue,
+				Payload:   map[string]any{"name": "grandchild"},
+				DependsOn: []string{childID},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			close(release)
+
+			timeout := time.After(5 * time.Second)
+			for _, jobID := range []string{childID, grandchildID} {
+				for {
+					job, err := nq.GetJob(ctx, jobID)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if job.Status == tt.status {
+						if job.Error.String != jobs.ErrDependencyFailed.Error() {
+							t.Errorf("expected job %s to record why it did not run, got: %s", jobID, job.Error.String)
+						}
+						break
+					}
+
+					select {
+					case <-timeout:
+						t.Fatalf("expected job %s to be %s, got status: %s", jobID, tt.status, job.Status)
+					case <-time.After(10 * time.Millisecond):
+					}
+				}
+			}
+
+			deadJobs, err := nq.ListDeadJobs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedDead := 1
+			if tt.policy == jobs.FailDependents {
+				expectedDead = 3
+			}
+			if len(deadJobs) != expectedDead {
+				t.Errorf("expected %d dead jobs, got: %d", expectedDead, len(deadJobs))
+			}
+		})
+	}
+}
+
+func TestBatches(t *testing.T) {
+	const callbackQueue = "batch_callbacks"
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Payload["fail"] == true {
+			return jobs.Permanent(errors.New("customer not found"))
+		}
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries := make(chan *jobs.BatchStatus, 1)
+	callbackHandler := handler.New(callbackQueue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		summary, err := jobs.BatchFromCallback(j)
+		if err != nil {
+			return
+		}
+		summaries <- summary
+		return
+	})
+	if err = nq.Start(ctx, callbackHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	batchID, err := nq.EnqueueBatch(ctx, &jobs.Batch{
+		Jobs: []*jobs.Job{
+			{Queue: queue, Payload: map[string]any{"customer": 1}},
+			{Queue: queue, Payload: map[string]any{"customer": 2}},
+			{Queue: queue, Payload: map[string]any{"customer": 2}},
+			{Queue: queue, Payload: map[string]any{"customer": 3, "fail": true}},
+		},
+		Callback: &jobs.Job{Queue: callbackQueue, Payload: map[string]any{"report": "customers"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary *jobs.BatchStatus
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case summary = <-summaries:
+	}
+
+	if summary.ID != batchID || summary.Total != 3 || summary.Succeeded != 2 || summary.Dead != 1 || !summary.Done() {
+		t.Errorf("expected the callback to summarize the batch's three jobs, got: %+v", summary)
+	}
+
+	// the callback's job ID is recorded once it's enqueued, which may be after the callback has run
+	timeout := time.After(5 * time.Second)
+	for {
+		status, err := nq.GetBatch(ctx, batchID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status.CallbackJobID != "" {
+			if status.Pending() != 0 || !status.Done() {
+				t.Errorf("expected the batch to be complete, got: %+v", status)
+			}
+			break
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("expected the batch's callback job ID to be recorded, got: %+v", status)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	_, err = nq.GetBatch(ctx, "42")
+	if !errors.Is(err, jobs.ErrBatchNotFound) {
+		t.Errorf("expected unknown batches not to be found, got: %v", err)
+	}
+}
+
+// TestRateLimit tests that rate-limited queues process jobs no faster than their limit, without consuming retries
+func TestRateLimit(t *testing.T) {
+	const numJobs = 4
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan *jobs.Job, numJobs)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		done <- j
+		return
+	}, handler.Concurrency(numJobs), handler.RateLimit(2, 400*time.Millisecond)) // nolint: gomnd
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < numJobs; i++ {
+		_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"i": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < numJobs; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected every rate-limited job to be processed")
+		case j := <-done:
+			if j.Retries != 0 {
+				t.Errorf("expected rate-limited jobs not to consume retries, got %d retries", j.Retries)
+			}
+		}
+	}
+
+	// the first two jobs use the bucket's two tokens, and the next two wait 200ms each for theirs
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("expected %d jobs to take at least 400ms at 2 jobs per 400ms, took %s", numJobs, elapsed)
+	}
+}
+
+// TestRateLimitKey tests that jobs limited by key are limited separately, and that jobs whose key has no tokens don't
+// hold up jobs with other keys
+func TestRateLimitKey(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), neoq.WithJobCheckInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 3) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		done <- j.Payload["customer"].(string)
+		return
+	}, handler.RateLimit(1, time.Hour), handler.RateLimitKey(func(j *jobs.Job) string {
+		customer, _ := j.Payload["customer"].(string)
+		return customer
+	}))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, customer := range []string{"a", "a", "b"} {
+		_, err = nq.Enqueue(ctx, &jobs.Job{
+			Queue:   queue,
+			Payload: map[string]interface{}{"customer": customer, "nonce": internal.RandInt(10000000000)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	processed := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected one job per customer to be processed, got: %v", processed)
+		case customer := <-done:
+			processed[customer]++
+		}
+	}
+
+	select {
+	case customer := <-done:
+		t.Errorf("expected customer %s's second job to wait for its rate limit", customer)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if processed["a"] != 1 || processed["b"] != 1 {
+		t.Errorf("expected one job per customer to be processed, got: %v", processed)
+	}
+}
+
+// TestGlobalConcurrency tests that queues process no more jobs at once than their global concurrency
+func TestGlobalConcurrency(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	started := make(chan bool, 3) // nolint: gomnd
+	release := make(chan bool)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		started <- true
+		<-release
+		return
+	}, handler.Concurrency(4), handler.GlobalConcurrency(2)) // nolint: gomnd
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"i": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-started:
+		}
+	}
+
+	select {
+	case <-started:
+		t.Error("expected the third job to wait for one of the first two to finish")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the third job to run once the first two finished")
+	case <-started:
+	}
+}
+
+// TestUniqueScopes tests that jobs duplicate jobs that are being processed unless they're unique while queued, and that
+// jobs that are not unique are never duplicates
+func TestUniqueScopes(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	started := make(chan bool, 3) // nolint: gomnd
+	release := make(chan bool)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		started <- true
+		<-release
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+
+	payload := map[string]interface{}{"message": "hello world"}
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-started:
+	}
+
+	tests := []struct {
+		name      string
+		unique    *jobs.Unique
+		duplicate bool
+	}{
+		{name: "unique while active", unique: nil, duplicate: true},
+		{name: "unique while queued", unique: jobs.UniqueWhile(jobs.UniqueWhileQueued), duplicate: false},
+		{name: "unique while queued, with a queued job", unique: jobs.UniqueWhile(jobs.UniqueWhileQueued), duplicate: true},
+		{name: "not unique", unique: jobs.UniqueWhile(jobs.NotUnique), duplicate: false},
+	}
+	for _, tt := range tests {
+		jid, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: tt.unique})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if duplicate := jid == jobs.DuplicateJobID; duplicate != tt.duplicate {
+			t.Errorf("%s: expected duplicate to be %t, got %t", tt.name, tt.duplicate, duplicate)
+		}
+	}
+}
+
+// TestUniqueWindow tests that jobs which are unique within a window duplicate jobs that were processed within it
+func TestUniqueWindow(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan bool)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]interface{}{"message": "hello world"}
+	jid, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: jobs.UniqueWithin(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-done:
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		job, err := nq.GetJob(ctx, jid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job.Status == internal.JobStatusProcessed {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the job to be processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	jid, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: jobs.UniqueWithin(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jid != jobs.DuplicateJobID {
+		t.Error("expected a job that's unique within an hour to duplicate a job processed within the hour")
+	}
+
+	jid, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jid == jobs.DuplicateJobID {
+		t.Error("expected a job that's unique while active not to duplicate a processed job")
+	}
+}
+
+// TestUniqueKeys tests that jobs with unique keys, or whose handlers have unique keys, are fingerprinted by the payload
+// fields named by their keys
+func TestUniqueKeys(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		return
+	}, handler.Unique(jobs.UniqueWhile(jobs.UniqueWhileActive, "customer_id")))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	runAfter := time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		payload   map[string]interface{}
+		unique    *jobs.Unique
+		duplicate bool
+	}{
+		{name: "first job", payload: map[string]interface{}{"customer_id": 1, "document_id": 1}, duplicate: false},
+		{name: "same key", payload: map[string]interface{}{"customer_id": 1, "document_id": 2}, duplicate: true},
+		{name: "other key", payload: map[string]interface{}{"customer_id": 2, "document_id": 1}, duplicate: false},
+		{
+			name:      "job's own keys",
+			payload:   map[string]interface{}{"customer_id": 3, "document_id": 3},
+			unique:    jobs.UniqueWhile(jobs.UniqueWhileActive, "document_id"),
+			duplicate: false,
+		},
+		{
+			name:      "job's own keys, same key",
+			payload:   map[string]interface{}{"customer_id": 4, "document_id": 3},
+			unique:    jobs.UniqueWhile(jobs.UniqueWhileActive, "document_id"),
+			duplicate: true,
+		},
+	}
+	for _, tt := range tests {
+		jid, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: tt.payload, Unique: tt.unique, RunAfter: runAfter})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if duplicate := jid == jobs.DuplicateJobID; duplicate != tt.duplicate {
+			t.Errorf("%s: expected duplicate to be %t, got %t", tt.name, tt.duplicate, duplicate)
+		}
+	}
+}
+
+// TestDebounce tests that debounced jobs replace the payloads of pending jobs with the same key, and push back their
+// RunAfter, so that only the last job's payload is processed
+func TestDebounce(t *testing.T) {
+	const window = 500 * time.Millisecond
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), neoq.WithJobCheckInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 10)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprint(j.Payload["message"])
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jid, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:       queue,
+		Payload:     map[string]interface{}{"message": "first"},
+		EnqueueMode: jobs.Debounce("doc:1", window),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jids, err := nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]interface{}{"message": "second"}, EnqueueMode: jobs.Debounce("doc:1", window)},
+		{Queue: queue, Payload: map[string]interface{}{"message": "last"}, EnqueueMode: jobs.Debounce("doc:1", window)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueuedAt := time.Now()
+
+	for _, id := range jids {
+		if id != jid {
+			t.Errorf("expected debounced jobs to be enqueued as the pending job %s, got: %s", jid, id)
+		}
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case message := <-done:
+		if message != "last" {
+			t.Errorf("expected the last debounced payload to be processed, got: %s", message)
+		}
+		if time.Since(enqueuedAt) < window {
+			t.Error("expected the debounced job to be pushed back by the last enqueue")
+		}
+	}
+
+	select {
+	case message := <-done:
+		t.Errorf("expected debounced jobs to be processed once, got another job: %s", message)
+	case <-time.After(2 * window):
+	}
+
+	id, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:       queue,
+		Payload:     map[string]interface{}{"message": "next"},
+		EnqueueMode: jobs.Debounce("doc:1", window),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == jid || id == jobs.DuplicateJobID {
+		t.Error("expected a debounced job to be enqueued once the job with its key is processed")
+	}
+}
+
+// TestThrottle tests that throttled jobs are dropped while pending jobs have the same key
+func TestThrottle(t *testing.T) {
+	const window = 500 * time.Millisecond
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), neoq.WithJobCheckInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 10)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprint(j.Payload["message"])
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jid, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:       queue,
+		Payload:     map[string]interface{}{"message": "first"},
+		EnqueueMode: jobs.Throttle("doc:1", window),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jid == jobs.DuplicateJobID {
+		t.Fatal("expected the first throttled job to be enqueued")
+	}
+
+	jids, err := nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]interface{}{"message": "second"}, EnqueueMode: jobs.Throttle("doc:1", window)},
+		{Queue: queue, Payload: map[string]interface{}{"message": "other"}, EnqueueMode: jobs.Throttle("doc:2", window)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jids[0] != jobs.DuplicateJobID {
+		t.Error("expected a throttled job to be dropped while a job with its key is pending")
+	}
+	if jids[1] == jobs.DuplicateJobID {
+		t.Error("expected a throttled job with a different key to be enqueued")
+	}
+
+	processed := map[string]bool{}
+	for range jids {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case message := <-done:
+			processed[message] = true
+		}
+	}
+	if !processed["first"] || !processed["other"] {
+		t.Errorf("expected the first job with each key to be processed, got: %v", processed)
+	}
+
+	id, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:       queue,
+		Payload:     map[string]interface{}{"message": "next"},
+		EnqueueMode: jobs.Throttle("doc:1", window),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == jobs.DuplicateJobID {
+		t.Error("expected a throttled job to be enqueued once the job with its key is no longer pending")
+	}
+}
+
+// TestRetention tests that completed jobs are forgotten once the completed job retention has passed
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(memory.Backend),
+		neoq.WithJobCheckInterval(10*time.Millisecond),
+		neoq.WithCompletedJobRetention(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jid, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "hello world"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		_, err = nq.GetJob(ctx, jid)
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the completed job to be forgotten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "child"}, DependsOn: []string{jid}})
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected jobs that depend on forgotten jobs to be rejected, got: %v", err)
+	}
+}
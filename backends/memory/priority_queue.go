@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/acaloiaro/neoq/jobs"
+)
+
+// priorityQueue holds the jobs that are due to be processed on a queue, ordered by priority
+//
+// Jobs are aged so that low-priority jobs are not starved: every job gains one level of priority for every aging
+// interval that it has been due. Since all jobs age at the same rate, this is the same as ordering jobs by the time
+// they became due, less their priority multiplied by the aging interval. When aging is zero or less, jobs are ordered
+// strictly by priority. Jobs that are otherwise equal are processed in the order that they became due.
+type priorityQueue struct {
+	mu    *sync.Mutex
+	jobs  jobHeap
+	slots chan struct{} // holds a token for every queued job, so that pushing to a queue at capacity blocks
+	ready chan struct{} // signals that jobs may be waiting to be popped
+}
+
+// newPriorityQueue creates a new priority queue that holds up to capacity jobs
+func newPriorityQueue(capacity int64, aging time.Duration) *priorityQueue {
+	return &priorityQueue{
+		mu:    &sync.Mutex{},
+		jobs:  jobHeap{aging: aging},
+		slots: make(chan struct{}, capacity),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push adds a job to the queue, blocking until the queue has capacity for it
+func (q *priorityQueue) push(job *jobs.Job) {
+	q.slots <- struct{}{}
+
+	q.mu.Lock()
+	heap.Push(&q.jobs, job)
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// pop removes the queue's highest-priority job, blocking until a job is queued or ctx is done
+func (q *priorityQueue) pop(ctx context.Context) (job *jobs.Job, err error) {
+	for {
+		q.mu.Lock()
+		if q.jobs.Len() > 0 {
+			job = heap.Pop(&q.jobs).(*jobs.Job)
+			remaining := q.jobs.Len()
+			q.mu.Unlock()
+			<-q.slots
+
+			// wake the next waiting worker while jobs remain, since pushes only signal one of them
+			if remaining > 0 {
+				q.signal()
+			}
+
+			return job, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// len is the number of jobs on the queue
+func (q *priorityQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.jobs.Len()
+}
+
+func (q *priorityQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// jobHeap is a [heap.Interface] of jobs, with the next job to be processed first
+type jobHeap struct {
+	aging time.Duration
+	jobs  []*jobs.Job
+}
+
+func (h jobHeap) Len() int { return len(h.jobs) }
+
+func (h jobHeap) Less(i, j int) bool {
+	a, b := h.jobs[i], h.jobs[j]
+	if h.aging <= 0 && a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+
+	dueA := a.RunAfter.Add(-time.Duration(a.Priority) * h.aging)
+	dueB := b.RunAfter.Add(-time.Duration(b.Priority) * h.aging)
+	if !dueA.Equal(dueB) {
+		return dueA.Before(dueB)
+	}
+
+	return a.ID < b.ID
+}
+
+func (h jobHeap) Swap(i, j int) { h.jobs[i], h.jobs[j] = h.jobs[j], h.jobs[i] }
+
+func (h *jobHeap) Push(x any) { h.jobs = append(h.jobs, x.(*jobs.Job)) }
+
+func (h *jobHeap) Pop() any {
+	n := len(h.jobs)
+	job := h.jobs[n-1]
+	h.jobs[n-1] = nil
+	h.jobs = h.jobs[:n-1]
+
+	return job
+}
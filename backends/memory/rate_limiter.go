@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/acaloiaro/neoq/handler"
+)
+
+// maxIdleBuckets is the number of buckets that rate limiters hold before they forget buckets that are full
+const maxIdleBuckets = 1024
+
+// rateLimiter is a token bucket rate limiter with a bucket for every queue, or queue and key, that it limits
+//
+// Buckets hold up to the rate's limit of tokens, and gain one token every interval of the rate. Processing a job
+// takes one token. Buckets start full, so buckets that are full are the same as buckets that don't exist.
+type rateLimiter struct {
+	mu      *sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds the tokens of one bucket as of the time that they were last counted
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time // when the bucket will be full again, after which it may be forgotten
+}
+
+// newRateLimiter creates a new rate limiter with no buckets
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		mu:      &sync.Mutex{},
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// take takes a token from a bucket, returning how long to wait for it when the bucket is empty
+//
+// When reserve is true, the token is taken even when the bucket is empty, and callers must wait before using it.
+// Otherwise, no token is taken from empty buckets, and callers must try again after waiting.
+func (l *rateLimiter) take(bucket string, rate *handler.Rate, reserve bool, now time.Time) (wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(rate.Limit)
+	interval := rate.Interval()
+
+	b, ok := l.buckets[bucket]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.forgetFullBuckets(now)
+		}
+
+		b = &tokenBucket{tokens: capacity, updatedAt: now}
+		l.buckets[bucket] = b
+	}
+
+	if interval > 0 {
+		b.tokens += float64(now.Sub(b.updatedAt)) / float64(interval)
+	}
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) * float64(interval))
+		if !reserve {
+			return
+		}
+	}
+
+	b.tokens--
+	b.fullAt = now.Add(time.Duration((capacity - b.tokens) * float64(interval)))
+
+	return
+}
+
+// forgetFullBuckets removes the buckets that have had time to fill up since they were last used
+func (l *rateLimiter) forgetFullBuckets(now time.Time) {
+	for name, b := range l.buckets {
+		if !now.Before(b.fullAt) {
+			delete(l.buckets, name)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/acaloiaro/neoq/jobs"
+)
+
+// defaultCompletedJobRetention is the time that completed jobs are kept for when no retention is configured
+const defaultCompletedJobRetention = time.Hour
+
+// completedJob is a job that was processed or cancelled, and the time after which it's forgotten
+type completedJob struct {
+	job       *jobs.Job
+	expiresAt time.Time
+}
+
+// completedJobHeap orders completed jobs by the time that they expire, earliest first
+type completedJobHeap []completedJob
+
+func (h completedJobHeap) Len() int           { return len(h) }
+func (h completedJobHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h completedJobHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *completedJobHeap) Push(x any) {
+	*h = append(*h, x.(completedJob))
+}
+
+func (h *completedJobHeap) Pop() any {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	old[n-1] = completedJob{}
+	*h = old[:n-1]
+	return c
+}
+
+// completeJob records that a job was processed or cancelled, so that it's forgotten once the completed job retention,
+// or the job's unique window, whichever is longer, has passed
+//
+// completeJob must be called while holding m.mu
+func (m *MemBackend) completeJob(job *jobs.Job, now time.Time) {
+	retention := m.config.CompletedJobRetention
+	if window := job.UniqueWindow(); window > retention {
+		retention = window
+	}
+
+	heap.Push(&m.completed, completedJob{job: job, expiresAt: now.Add(retention)})
+}
+
+// forgetCompletedJobs forgets the completed jobs that have expired, so that they can no longer be retrieved with GetJob
+// or duplicated
+func (m *MemBackend) forgetCompletedJobs(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for m.completed.Len() > 0 && !now.Before(m.completed[0].expiresAt) {
+		job := heap.Pop(&m.completed).(completedJob).job
+		m.allJobs.Delete(job.ID)
+
+		known := m.fingerprints[job.Fingerprint][:0]
+		for _, j := range m.fingerprints[job.Fingerprint] {
+			if j != job {
+				known = append(known, j)
+			}
+		}
+
+		if len(known) == 0 {
+			delete(m.fingerprints, job.Fingerprint)
+		} else {
+			m.fingerprints[job.Fingerprint] = known
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const handlerSavepoint = "neoq_handler"
+
+// handlerTx is the job transaction made available to handlers by TxFromContext
+//
+// Handlers' writes are made within a savepoint that is created the first time a handler gets its transaction, so that
+// failed handlers' writes can be rolled back without losing the job's row lock or the ability to update its status.
+type handlerTx struct {
+	guardedTx
+	started bool // whether the handler's savepoint has been created
+}
+
+// Commit has no effect. Handler transactions are committed by neoq when handlers succeed.
+func (h *handlerTx) Commit(_ context.Context) error {
+	return ErrHandlerTxManaged
+}
+
+// Rollback has no effect. Handler transactions are rolled back by neoq when handlers fail.
+func (h *handlerTx) Rollback(_ context.Context) error {
+	return ErrHandlerTxManaged
+}
+
+// begin creates the handler's savepoint if it has not yet been created
+func (h *handlerTx) begin(ctx context.Context) (err error) {
+	h.guard.mu.Lock()
+	defer h.guard.mu.Unlock()
+
+	if h.guard.done {
+		return ErrHandlerTxFinished
+	}
+
+	if h.started {
+		return
+	}
+
+	_, err = h.Tx.Exec(ctx, "SAVEPOINT "+handlerSavepoint)
+	if err != nil {
+		return fmt.Errorf("unable to begin handler transaction: %w", err)
+	}
+	h.started = true
+
+	return
+}
+
+// finish releases the handler's savepoint if the handler succeeded, or rolls it back if it failed, returning the
+// handler's error, or the error that caused its writes to fail
+//
+// Handlers that time out may still be running, so finish waits for the statements that they're running, and rejects
+// any that they run afterwards.
+func (h *handlerTx) finish(ctx context.Context, jobErr error) error {
+	h.guard.mu.Lock()
+	defer h.guard.mu.Unlock()
+
+	h.guard.done = true
+	if !h.started {
+		return jobErr
+	}
+
+	if jobErr == nil {
+		_, jobErr = h.Tx.Exec(ctx, "RELEASE SAVEPOINT "+handlerSavepoint)
+		if jobErr == nil {
+			return nil
+		}
+
+		// the savepoint could not be released because one of the handler's statements failed
+		jobErr = fmt.Errorf("unable to commit handler transaction: %w", jobErr)
+	}
+
+	_, err := h.Tx.Exec(ctx, "ROLLBACK TO SAVEPOINT "+handlerSavepoint)
+	if err != nil {
+		return fmt.Errorf("unable to roll back handler transaction: %w", err)
+	}
+
+	return jobErr
+}
+
+// txGuard guards the connection of a job's transaction from handlers, since connections may not be used concurrently
+type txGuard struct {
+	mu   *sync.Mutex // held while handlers' statements run, and while their rows and batches are open
+	done bool        // whether neoq has finished with the handler's writes
+}
+
+// acquire acquires the guard for a handler's statement, failing if the transaction is in use or finished
+func (g *txGuard) acquire() error {
+	if !g.mu.TryLock() {
+		return ErrHandlerTxBusy
+	}
+
+	if g.done {
+		g.mu.Unlock()
+		return ErrHandlerTxFinished
+	}
+
+	return nil
+}
+
+// guardedTx is a transaction, or a savepoint within it, whose statements acquire its guard
+//
+// Rows and batch results hold the guard until they're closed. Large objects and the transaction's connection are not
+// guarded.
+type guardedTx struct {
+	pgx.Tx
+	guard *txGuard
+}
+
+func (t *guardedTx) Begin(ctx context.Context) (tx pgx.Tx, err error) {
+	if err = t.guard.acquire(); err != nil {
+		return
+	}
+	defer t.guard.mu.Unlock()
+
+	tx, err = t.Tx.Begin(ctx)
+	if err != nil {
+		return
+	}
+
+	return &guardedTx{Tx: tx, guard: t.guard}, nil
+}
+
+func (t *guardedTx) Commit(ctx context.Context) (err error) {
+	if err = t.guard.acquire(); err != nil {
+		return
+	}
+	defer t.guard.mu.Unlock()
+
+	return t.Tx.Commit(ctx)
+}
+
+func (t *guardedTx) Rollback(ctx context.Context) (err error) {
+	if err = t.guard.acquire(); err != nil {
+		return
+	}
+	defer t.guard.mu.Unlock()
+
+	return t.Tx.Rollback(ctx)
+}
+
+func (t *guardedTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string,
+	rowSrc pgx.CopyFromSource,
+) (n int64, err error) {
+	if err = t.guard.acquire(); err != nil {
+		return
+	}
+	defer t.guard.mu.Unlock()
+
+	return t.Tx.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+func (t *guardedTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	if err := t.guard.acquire(); err != nil {
+		return &guardedBatchResults{err: err}
+	}
+
+	return &guardedBatchResults{BatchResults: t.Tx.SendBatch(ctx, b), guard: t.guard}
+}
+
+func (t *guardedTx) Prepare(ctx context.Context, name, sql string) (sd *pgconn.StatementDescription, err error) {
+	if err = t.guard.acquire(); err != nil {
+		return
+	}
+	defer t.guard.mu.Unlock()
+
+	return t.Tx.Prepare(ctx, name, sql)
+}
+
+func (t *guardedTx) Exec(ctx context.Context, sql string, args ...any) (tag pgconn.CommandTag, err error) {
+	if err = t.guard.acquire(); err != nil {
+		return
+	}
+	defer t.guard.mu.Unlock()
+
+	return t.Tx.Exec(ctx, sql, args...)
+}
+
+func (t *guardedTx) Query(ctx context.Context, sql string, args ...any) (rows pgx.Rows, err error) {
+	if err = t.guard.acquire(); err != nil {
+		return
+	}
+
+	rows, err = t.Tx.Query(ctx, sql, args...)
+	if err != nil {
+		t.guard.mu.Unlock()
+		return
+	}
+
+	return &guardedRows{Rows: rows, guard: t.guard}, nil
+}
+
+func (t *guardedTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	rows, err := t.Query(ctx, sql, args...)
+	return &guardedRow{rows: rows, err: err}
+}
+
+// guardedRows are rows that hold their transaction's guard until they're closed or read to the end
+type guardedRows struct {
+	pgx.Rows
+	guard    *txGuard
+	released bool
+}
+
+func (r *guardedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+
+	r.release()
+	return false
+}
+
+func (r *guardedRows) Close() {
+	r.Rows.Close()
+	r.release()
+}
+
+func (r *guardedRows) release() {
+	if !r.released {
+		r.released = true
+		r.guard.mu.Unlock()
+	}
+}
+
+// guardedRow is the row of QueryRow, which reads the first of its rows
+type guardedRow struct {
+	rows pgx.Rows
+	err  error
+}
+
+func (r *guardedRow) Scan(dest ...any) (err error) {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+
+	if !r.rows.Next() {
+		if err = r.rows.Err(); err != nil {
+			return
+		}
+
+		return pgx.ErrNoRows
+	}
+
+	if err = r.rows.Scan(dest...); err != nil {
+		return
+	}
+	r.rows.Close()
+
+	return r.rows.Err()
+}
+
+// guardedBatchResults are batch results that hold their transaction's guard until they're closed
+type guardedBatchResults struct {
+	pgx.BatchResults
+	guard *txGuard
+	err   error // the error that the batch was rejected with, if any
+}
+
+func (b *guardedBatchResults) Exec() (pgconn.CommandTag, error) {
+	if b.err != nil {
+		return pgconn.CommandTag{}, b.err
+	}
+
+	return b.BatchResults.Exec()
+}
+
+func (b *guardedBatchResults) Query() (pgx.Rows, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return b.BatchResults.Query()
+}
+
+func (b *guardedBatchResults) QueryRow() pgx.Row {
+	if b.err != nil {
+		return &guardedRow{err: b.err}
+	}
+
+	return b.BatchResults.QueryRow()
+}
+
+func (b *guardedBatchResults) Close() (err error) {
+	if b.err != nil {
+		return b.err
+	}
+
+	if b.guard == nil {
+		return b.BatchResults.Close()
+	}
+
+	err = b.BatchResults.Close()
+	b.guard.mu.Unlock()
+	b.guard = nil
+
+	return
+}
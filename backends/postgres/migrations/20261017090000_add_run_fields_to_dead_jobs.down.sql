@@ -0,0 +1,2 @@
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS run_after;
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS ran_at;
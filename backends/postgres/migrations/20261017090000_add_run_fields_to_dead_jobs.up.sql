@@ -0,0 +1,3 @@
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS run_after timestamp with time zone DEFAULT now();
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS ran_at timestamp with time zone;
+UPDATE neoq_dead_jobs SET run_after = created_at WHERE run_after IS NULL;
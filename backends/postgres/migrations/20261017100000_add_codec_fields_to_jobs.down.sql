@@ -0,0 +1,4 @@
+ALTER TABLE neoq_jobs DROP COLUMN IF EXISTS raw_payload;
+ALTER TABLE neoq_jobs DROP COLUMN IF EXISTS codec;
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS raw_payload;
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS codec;
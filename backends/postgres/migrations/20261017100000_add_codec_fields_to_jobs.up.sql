@@ -0,0 +1,4 @@
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS raw_payload bytea;
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS codec text NOT NULL DEFAULT 'json';
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS raw_payload bytea;
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS codec text NOT NULL DEFAULT 'json';
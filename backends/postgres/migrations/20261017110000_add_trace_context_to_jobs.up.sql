@@ -0,0 +1,2 @@
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS trace_context jsonb;
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS trace_context jsonb;
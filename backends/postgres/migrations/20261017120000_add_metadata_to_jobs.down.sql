@@ -0,0 +1,2 @@
+ALTER TABLE neoq_jobs DROP COLUMN IF EXISTS metadata;
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS metadata;
@@ -0,0 +1,2 @@
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS snoozes integer NOT NULL DEFAULT 0;
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS snoozes integer NOT NULL DEFAULT 0;
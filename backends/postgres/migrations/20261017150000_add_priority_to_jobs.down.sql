@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS neoq_jobs_priority_idx;
+ALTER TABLE neoq_jobs DROP COLUMN IF EXISTS priority;
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS priority;
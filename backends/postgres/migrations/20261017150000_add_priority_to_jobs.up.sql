@@ -0,0 +1,4 @@
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS priority integer NOT NULL DEFAULT 0;
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS priority integer NOT NULL DEFAULT 0;
+--- Pending jobs are fetched by priority, then in the order that they became due
+CREATE INDEX IF NOT EXISTS neoq_jobs_priority_idx ON neoq_jobs (queue, priority DESC, run_after, id) WHERE NOT (status = 'processed');
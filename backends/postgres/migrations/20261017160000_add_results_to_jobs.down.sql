@@ -0,0 +1,4 @@
+ALTER TABLE neoq_jobs DROP COLUMN IF EXISTS result;
+ALTER TABLE neoq_jobs DROP COLUMN IF EXISTS progress;
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS result;
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS progress;
@@ -0,0 +1,4 @@
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS result bytea;
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS progress jsonb;
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS result bytea;
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS progress jsonb;
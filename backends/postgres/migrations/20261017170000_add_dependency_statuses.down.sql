@@ -0,0 +1,2 @@
+--- Postgres does not support removing values from enum types. The 'waiting' and 'cancelled' statuses are harmless to
+--- keep once the dependencies migration is reverted.
@@ -0,0 +1,4 @@
+--- Jobs wait for the jobs that they depend on, and are cancelled when the jobs that they depend on die. New enum
+--- values can't be used in the transaction that adds them, so they're added before any migration that uses them.
+ALTER TYPE job_status ADD VALUE IF NOT EXISTS 'waiting';
+ALTER TYPE job_status ADD VALUE IF NOT EXISTS 'cancelled';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS neoq_jobs_fingerprint_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS neoq_jobs_fingerprint_unique_idx ON neoq_jobs (fingerprint, status) WHERE NOT (status = 'processed');
+DROP INDEX IF EXISTS neoq_jobs_waiting_idx;
+DROP TABLE IF EXISTS neoq_job_dependencies;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS neoq_job_dependencies (
+		job_id bigint NOT NULL,
+		depends_on bigint NOT NULL,
+		PRIMARY KEY (job_id, depends_on)
+);
+
+--- Dependents are found by the jobs that they depend on when those jobs are processed or die
+CREATE INDEX IF NOT EXISTS neoq_job_dependencies_depends_on_idx ON neoq_job_dependencies (depends_on);
+CREATE INDEX IF NOT EXISTS neoq_jobs_waiting_idx ON neoq_jobs (queue) WHERE status = 'waiting';
+
+--- Cancelled jobs are never processed, so they no longer prevent jobs with the same payload from being queued
+DROP INDEX IF EXISTS neoq_jobs_fingerprint_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS neoq_jobs_fingerprint_unique_idx ON neoq_jobs (fingerprint, status) WHERE status NOT IN ('processed', 'cancelled');
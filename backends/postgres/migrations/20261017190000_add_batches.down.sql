@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS neoq_dead_jobs_batch_idx;
+DROP INDEX IF EXISTS neoq_jobs_batch_idx;
+ALTER TABLE neoq_jobs DROP COLUMN IF EXISTS batch_id;
+ALTER TABLE neoq_dead_jobs DROP COLUMN IF EXISTS batch_id;
+DROP TABLE IF EXISTS neoq_batches;
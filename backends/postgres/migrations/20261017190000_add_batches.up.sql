@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS neoq_batches (
+		id BIGSERIAL PRIMARY KEY,
+		total integer NOT NULL DEFAULT 0,
+		succeeded integer NOT NULL DEFAULT 0,
+		callback jsonb,
+		callback_job_id bigint,
+		created_at timestamp with time zone DEFAULT now(),
+		completed_at timestamp with time zone
+);
+
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS batch_id bigint;
+ALTER TABLE neoq_dead_jobs ADD COLUMN IF NOT EXISTS batch_id bigint;
+
+--- Batches are completed once none of their jobs are pending
+CREATE INDEX IF NOT EXISTS neoq_jobs_batch_idx ON neoq_jobs (batch_id, status) WHERE batch_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS neoq_dead_jobs_batch_idx ON neoq_dead_jobs (batch_id) WHERE batch_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS neoq_batches_pending_idx ON neoq_batches (id) WHERE completed_at IS NULL;
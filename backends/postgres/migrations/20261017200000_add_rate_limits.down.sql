@@ -0,0 +1 @@
+DROP TABLE IF EXISTS neoq_rate_limits;
@@ -0,0 +1,7 @@
+--- Token buckets of rate-limited queues and keys. Losing them on crashes only resets limits, so they're not logged.
+CREATE UNLOGGED TABLE IF NOT EXISTS neoq_rate_limits (
+		bucket text PRIMARY KEY,
+		tokens double precision NOT NULL,
+		wait double precision NOT NULL DEFAULT 0,
+		updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,14 @@
+--- Jobs may duplicate each other's fingerprints and statuses once duplicates depend on uniqueness scopes. All but the
+--- earliest of each set of duplicates are cancelled, so that the unique index can be created again.
+UPDATE neoq_jobs
+SET status = 'cancelled', error = 'cancelled by migration: duplicates an earlier job''s fingerprint'
+WHERE id IN (
+  SELECT id FROM (
+    SELECT id, row_number() OVER (PARTITION BY fingerprint, status ORDER BY id) AS n
+    FROM neoq_jobs
+    WHERE status NOT IN ('processed', 'cancelled')
+  ) duplicates
+  WHERE n > 1
+);
+DROP INDEX IF EXISTS neoq_jobs_fingerprint_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS neoq_jobs_fingerprint_unique_idx ON neoq_jobs (fingerprint, status) WHERE status NOT IN ('processed', 'cancelled');
@@ -0,0 +1,3 @@
+--- Duplicates depend on jobs' uniqueness scopes, so they're found when jobs are enqueued rather than by a unique index
+DROP INDEX IF EXISTS neoq_jobs_fingerprint_unique_idx;
+CREATE INDEX IF NOT EXISTS neoq_jobs_fingerprint_idx ON neoq_jobs (fingerprint, status);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS neoq_jobs_aged_run_after_idx;
+ALTER TABLE neoq_jobs DROP COLUMN IF EXISTS aged_run_after;
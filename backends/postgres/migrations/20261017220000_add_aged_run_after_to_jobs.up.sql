@@ -0,0 +1,5 @@
+ALTER TABLE neoq_jobs ADD COLUMN IF NOT EXISTS aged_run_after timestamp with time zone NOT NULL DEFAULT now();
+--- Existing jobs are aged by the default priority aging of one minute for every level of priority
+UPDATE neoq_jobs SET aged_run_after = run_after - make_interval(mins => priority);
+--- Pending jobs are fetched in the order that they became due, brought forward by their priorities multiplied by the priority aging
+CREATE INDEX IF NOT EXISTS neoq_jobs_aged_run_after_idx ON neoq_jobs (queue, aged_run_after, id) WHERE status NOT IN ('processed', 'waiting', 'cancelled');
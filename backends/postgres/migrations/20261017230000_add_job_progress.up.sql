@@ -0,0 +1,9 @@
+--- Results and progress written by handlers while their jobs are processed. Jobs' rows are locked while they're processed,
+--- so results and progress are written here until they're saved with jobs' statuses. Job IDs are not foreign keys, since
+--- referencing jobs' rows would wait for their locks.
+CREATE UNLOGGED TABLE IF NOT EXISTS neoq_job_progress (
+		job_id bigint PRIMARY KEY,
+		result bytea,
+		progress jsonb,
+		updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
@@ -5,8 +5,12 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/acaloiaro/neoq"
@@ -18,12 +22,12 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/database/postgres" // nolint: revive
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/iancoleman/strcase"
-	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jsuar/go-cron-descriptor/pkg/crondescriptor"
 	"github.com/robfig/cron"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slog"
 )
 
@@ -31,40 +35,359 @@ import (
 var migrationsFS embed.FS
 
 const (
+	// PendingJobIDQuery selects the ID of a queue's next pending job, in the order of their aged run_after, i.e. their
+	// run_after brought forward by the priority aging for every level of their priority. Jobs' aged run_after is written
+	// whenever their run_after is, so that pending jobs are fetched by neoq_jobs_aged_run_after_idx rather than sorted.
+	// See neoq.WithPriorityAging.
 	PendingJobIDQuery = `SELECT id
 					FROM neoq_jobs
 					WHERE queue = $1
-					AND status NOT IN ('processed')
+					AND status NOT IN ('processed', 'waiting', 'cancelled')
 					AND run_after <= NOW()
+					ORDER BY aged_run_after ASC, id ASC
 					FOR UPDATE SKIP LOCKED
 					LIMIT 1`
-	PendingJobQuery = `SELECT id,fingerprint,queue,status,deadline,payload,retries,max_retries,run_after,ran_at,created_at,error
+	// PendingJobQuery selects a queue's next pending job, in the order of their aged run_after. See PendingJobIDQuery.
+	PendingJobQuery = `SELECT ` + jobFields + `
 					FROM neoq_jobs
-					WHERE id = $1
-					AND status NOT IN ('processed')
+					WHERE queue = $1
+					AND status NOT IN ('processed', 'waiting', 'cancelled')
+					AND run_after <= NOW()
+					ORDER BY aged_run_after ASC, id ASC
+					FOR UPDATE SKIP LOCKED
+					LIMIT 1`
+	// StrictPendingJobIDQuery selects the ID of a queue's next pending job, strictly by priority, when priority aging is
+	// disabled
+	StrictPendingJobIDQuery = `SELECT id
+					FROM neoq_jobs
+					WHERE queue = $1
+					AND status NOT IN ('processed', 'waiting', 'cancelled')
+					AND run_after <= NOW()
+					ORDER BY priority DESC, run_after ASC, id ASC
+					FOR UPDATE SKIP LOCKED
+					LIMIT 1`
+	// StrictPendingJobQuery selects a queue's next pending job, strictly by priority, when priority aging is disabled
+	StrictPendingJobQuery = `SELECT ` + jobFields + `
+					FROM neoq_jobs
+					WHERE queue = $1
+					AND status NOT IN ('processed', 'waiting', 'cancelled')
 					AND run_after <= NOW()
+					ORDER BY priority DESC, run_after ASC, id ASC
 					FOR UPDATE SKIP LOCKED
 					LIMIT 1`
 	FutureJobQuery = `SELECT id,run_after
 					FROM neoq_jobs
 					WHERE queue = $1
-					AND status NOT IN ('processed')
+					AND status NOT IN ('processed', 'waiting', 'cancelled')
 					AND run_after > NOW()
 					ORDER BY run_after ASC
 					LIMIT 100
 					FOR UPDATE SKIP LOCKED`
+	JobQuery = `SELECT ` + jobFields + `
+					FROM neoq_jobs
+					WHERE id = $1`
+	DeadJobQuery = `SELECT ` + jobFields + `
+					FROM neoq_dead_jobs
+					WHERE id = $1`
+	DeadJobsQuery = `SELECT ` + jobFields + `
+					FROM neoq_dead_jobs
+					WHERE ($1::text = '' OR queue = $1)
+					ORDER BY id ASC
+					LIMIT $2
+					OFFSET $3`
+	// EnqueueManyQuery adds many jobs at once, in order, so that their IDs ascend in the order that they're given. Jobs
+	// are aged by $14 seconds for every level of priority. See PendingJobIDQuery.
+	EnqueueManyQuery = `INSERT INTO neoq_jobs(queue, fingerprint, payload, raw_payload, codec, run_after, deadline,
+						trace_context, metadata, max_retries, backoff, priority, batch_id, aged_run_after)
+					SELECT queue, fingerprint, payload, raw_payload, codec, run_after, deadline, trace_context, metadata,
+						max_retries, backoff, priority, $13::bigint, run_after - make_interval(secs => priority * $14::float8)
+					FROM unnest($1::text[], $2::text[], $3::jsonb[], $4::bytea[], $5::text[], $6::timestamptz[],
+						$7::timestamptz[], $8::jsonb[], $9::jsonb[], $10::integer[], $11::jsonb[], $12::integer[])
+						WITH ORDINALITY AS j(queue, fingerprint, payload, raw_payload, codec, run_after, deadline,
+							trace_context, metadata, max_retries, backoff, priority, i)
+					ORDER BY i
+					RETURNING id`
+	// LockFingerprintsQuery serializes the enqueueing of jobs with the fingerprints $1 until the end of the transaction,
+	// so that concurrently enqueued duplicates are not both added. Fingerprints are hashed onto $2 stripes, so that
+	// transactions hold at most $2 locks however many jobs they enqueue, and stripes are locked in order to avoid
+	// deadlocks.
+	LockFingerprintsQuery = `SELECT pg_advisory_xact_lock(hashtext('neoq_fingerprint'), s.stripe)
+					FROM (SELECT DISTINCT abs(hashtext(f) % $2::int) AS stripe FROM unnest($1::text[]) AS f ORDER BY 1) s`
+	// DuplicateJobsQuery selects the ordinals, starting at 1, of the fingerprints $1 that belong to existing jobs which
+	// are queued, which are being processed unless $2 is true, or which were processed within $3 seconds
+	DuplicateJobsQuery = `SELECT u.i FROM unnest($1::text[], $2::boolean[], $3::float8[])
+						WITH ORDINALITY AS u(fingerprint, queued_only, window_secs, i)
+					WHERE EXISTS (
+						SELECT 1 FROM neoq_jobs o
+						WHERE o.fingerprint = u.fingerprint
+						AND ((o.status IN ('new', 'failed', 'waiting')
+								AND NOT (u.queued_only AND EXISTS (` + runningJobQuery + `)))
+							OR (o.status = 'processed' AND o.ran_at > NOW() - make_interval(secs => u.window_secs)))
+					)`
+	// RunningJobLockQuery marks job $1 as being processed until the end of the worker's transaction, with an advisory
+	// lock in neoq's own class of two-key advisory locks, so that it doesn't collide with applications' advisory locks.
+	// The lock is keyed by the low 32 bits of the job's ID. See runningJobQuery.
+	RunningJobLockQuery = `SELECT pg_advisory_xact_lock(hashtext('neoq_running_job'), (($1::bigint << 32) >> 32)::int4)`
+	// ThrottledJobQuery selects the pending job with fingerprint $1, in favor of which throttled jobs are dropped. See
+	// pendingKeyJobQuery.
+	ThrottledJobQuery = pendingKeyJobQuery
+	// DebouncedJobQuery replaces the payload and metadata of the pending job with fingerprint $1, and pushes back its
+	// run_after to $5, aged by $8 seconds for every level of priority. See pendingKeyJobQuery.
+	DebouncedJobQuery = `UPDATE neoq_jobs SET payload = $2, raw_payload = $3, codec = $4, run_after = $5,
+						trace_context = $6, metadata = $7,
+						aged_run_after = $5::timestamptz - make_interval(secs => priority * $8::float8)
+					WHERE id = (` + pendingKeyJobQuery + `)
+					RETURNING id`
+	// RequeueDeadJobsQuery moves dead jobs back to neoq_jobs, skipping any that duplicate jobs which are queued or being
+	// processed, or earlier dead jobs. Jobs that depend on other jobs wait for them again. Requeued jobs are due
+	// immediately, aged by $2 seconds for every level of priority.
+	RequeueDeadJobsQuery = `WITH requeued AS (
+						INSERT INTO neoq_jobs(id, queue, fingerprint, payload, raw_payload, codec, max_retries, deadline,
+							trace_context, metadata, backoff, priority, batch_id, created_at, aged_run_after, status)
+						SELECT DISTINCT ON (fingerprint) id, queue, fingerprint, payload, raw_payload, codec, max_retries,
+							deadline, trace_context, metadata, backoff, priority, batch_id, created_at,
+							NOW() - make_interval(secs => priority * $2::float8),
+							CASE WHEN EXISTS (SELECT 1 FROM neoq_job_dependencies d WHERE d.job_id = neoq_dead_jobs.id)
+								THEN 'waiting'::job_status ELSE 'new'::job_status END
+						FROM neoq_dead_jobs
+						WHERE id = ANY($1)
+						AND NOT EXISTS (
+							SELECT 1 FROM neoq_jobs o
+							WHERE o.fingerprint = neoq_dead_jobs.fingerprint
+							AND o.status IN ('new', 'failed', 'waiting')
+						)
+						ORDER BY fingerprint, id
+						ON CONFLICT DO NOTHING
+						RETURNING id, queue
+					), deleted AS (
+						DELETE FROM neoq_dead_jobs WHERE id IN (SELECT id FROM requeued)
+					)
+					SELECT id, queue FROM requeued`
+	// CancelDependentJobsQuery cancels a queue's waiting jobs when any of the jobs that they depend on have died,
+	// returning the batch IDs of the jobs that it cancels
+	CancelDependentJobsQuery = `UPDATE neoq_jobs j
+					SET status = 'cancelled', error = $2
+					WHERE j.queue = $1
+					AND j.status = 'waiting'
+					AND EXISTS (` + diedDependencyQuery + `)
+					RETURNING COALESCE(j.batch_id::text, '')`
+	// FailDependentJobsQuery moves a queue's waiting jobs to the dead queue when any of the jobs that they depend on
+	// have died, returning the batch IDs of the jobs that it moves
+	FailDependentJobsQuery = `WITH failed AS (
+						DELETE FROM neoq_jobs j
+						WHERE j.queue = $1
+						AND j.status = 'waiting'
+						AND EXISTS (` + diedDependencyQuery + `)
+						RETURNING j.*
+					)
+					INSERT INTO neoq_dead_jobs(id, queue, fingerprint, payload, raw_payload, codec, retries, max_retries,
+						error, deadline, run_after, ran_at, trace_context, metadata, backoff, snoozes, priority, batch_id,
+						created_at)
+					SELECT id, queue, fingerprint, payload, raw_payload, codec, retries, max_retries, $2, deadline,
+						run_after, NOW(), trace_context, metadata, backoff, snoozes, priority, batch_id, created_at
+					FROM failed
+					RETURNING COALESCE(batch_id::text, '')`
+	// ReleaseDependentJobsQuery makes a queue's waiting jobs pending once all the jobs that they depend on have been
+	// processed
+	ReleaseDependentJobsQuery = `UPDATE neoq_jobs j
+					SET status = 'new'
+					WHERE j.queue = $1
+					AND j.status = 'waiting'
+					AND NOT EXISTS (
+						SELECT 1 FROM neoq_job_dependencies d
+						LEFT JOIN neoq_jobs parent ON parent.id = d.depends_on
+						WHERE d.job_id = j.id
+						AND (parent.status IS NULL OR parent.status <> 'processed')
+					)
+					RETURNING j.id, j.run_after`
+	// LockDependentJobsQuery locks the waiting jobs that depend on a job, in order, so that when jobs that they depend
+	// on are processed concurrently, the last of them to commit sees the others' outcomes when it releases them
+	LockDependentJobsQuery = `SELECT j.id FROM neoq_jobs j
+					JOIN neoq_job_dependencies d ON d.job_id = j.id
+					WHERE d.depends_on = $1
+					AND j.status = 'waiting'
+					ORDER BY j.id
+					FOR UPDATE OF j`
+	// ReleaseJobDependentsQuery makes the waiting jobs that depend on a job pending once all the jobs that they depend
+	// on have been processed
+	ReleaseJobDependentsQuery = `UPDATE neoq_jobs j
+					SET status = 'new'
+					WHERE j.id IN (SELECT d.job_id FROM neoq_job_dependencies d WHERE d.depends_on = $1)
+					AND j.status = 'waiting'
+					AND NOT EXISTS (
+						SELECT 1 FROM neoq_job_dependencies d
+						LEFT JOIN neoq_jobs parent ON parent.id = d.depends_on
+						WHERE d.job_id = j.id
+						AND (parent.status IS NULL OR parent.status <> 'processed')
+					)
+					RETURNING j.id, j.queue, j.run_after`
+	// LockBatchQuery locks a batch that has not been completed, so that when a batch's last jobs finish concurrently,
+	// the last of them to commit sees the others' outcomes when it checks whether their batch is complete
+	LockBatchQuery = `SELECT id FROM neoq_batches WHERE id = $1::text::bigint AND completed_at IS NULL FOR UPDATE`
+	// CompleteBatchQuery completes a batch if its jobs have all been processed or died, i.e. if it has no jobs that
+	// are still in neoq_jobs, other than those that were processed or cancelled
+	CompleteBatchQuery = `UPDATE neoq_batches b
+					SET completed_at = NOW(),
+						succeeded = (SELECT count(*) FROM neoq_jobs j WHERE j.batch_id = b.id AND j.status = 'processed')
+					WHERE b.id = $1
+					AND b.completed_at IS NULL
+					AND NOT EXISTS (
+						SELECT 1 FROM neoq_jobs j
+						WHERE j.batch_id = b.id
+						AND j.status NOT IN ('processed', 'cancelled')
+					)
+					RETURNING b.id::text, b.total, b.succeeded, b.callback, b.created_at, b.completed_at`
+	// BatchQuery selects a batch's status. The jobs of batches that have not been completed are counted, since jobs that
+	// died are moved to neoq_dead_jobs.
+	BatchQuery = `SELECT b.id::text, b.total,
+						CASE WHEN b.completed_at IS NULL
+							THEN (SELECT count(*) FROM neoq_jobs j WHERE j.batch_id = b.id AND j.status = 'processed')
+							ELSE b.succeeded END,
+						CASE WHEN b.completed_at IS NULL
+							THEN (SELECT count(*) FROM neoq_jobs j WHERE j.batch_id = b.id AND j.status = 'cancelled') +
+								(SELECT count(*) FROM neoq_dead_jobs d WHERE d.batch_id = b.id)
+							ELSE b.total - b.succeeded END,
+						COALESCE(b.callback_job_id::text, ''), b.created_at, b.completed_at
+					FROM neoq_batches b
+					WHERE b.id = $1`
+	// QueueStatsQuery counts the jobs on every queue that are due to be processed
+	QueueStatsQuery = `SELECT queue, count(*), min(run_after)
+					FROM neoq_jobs
+					WHERE status NOT IN ('processed', 'waiting', 'cancelled')
+					AND run_after <= NOW()
+					GROUP BY queue`
+	// LockJobQuery locks a pending job by ID, so that it may be processed once its rate limit allows
+	LockJobQuery = `SELECT ` + jobFields + `
+					FROM neoq_jobs
+					WHERE id = $1
+					AND status NOT IN ('processed', 'waiting', 'cancelled')
+					AND run_after <= NOW()
+					FOR UPDATE SKIP LOCKED`
+	// RateLimitJobQuery reschedules a pending job whose rate limit key has no tokens, unless it's being processed. The job
+	// is aged by $3 seconds for every level of priority.
+	RateLimitJobQuery = `UPDATE neoq_jobs
+					SET run_after = $2, aged_run_after = $2::timestamptz - make_interval(secs => priority * $3::float8)
+					WHERE id = (
+						SELECT id FROM neoq_jobs
+						WHERE id = $1
+						AND status NOT IN ('processed', 'waiting', 'cancelled')
+						AND run_after <= NOW()
+						FOR UPDATE SKIP LOCKED
+					)`
+	// TakeRateLimitTokenQuery takes a token from bucket $1, which holds up to $2 tokens and gains one every $3 seconds,
+	// returning the number of seconds to wait for a token when the bucket is empty. When $4 is true, tokens are reserved
+	// from empty buckets, leaving them in deficit until they refill. Otherwise, no token is taken from empty buckets.
+	TakeRateLimitTokenQuery = `INSERT INTO neoq_rate_limits AS l (bucket, tokens, wait, updated_at)
+					VALUES ($1, $2::float8 - 1, 0, NOW())
+					ON CONFLICT (bucket) DO UPDATE SET
+						tokens = CASE WHEN ` + refilledTokens + ` >= 1 OR $4::boolean
+							THEN ` + refilledTokens + ` - 1
+							ELSE ` + refilledTokens + ` END,
+						wait = GREATEST(1 - ` + refilledTokens + `, 0) * $3::float8,
+						updated_at = NOW()
+					RETURNING wait`
+	// RefundRateLimitTokenQuery returns a token to bucket $1, which holds up to $2 tokens
+	RefundRateLimitTokenQuery = `UPDATE neoq_rate_limits SET tokens = LEAST(tokens + 1, $2::float8) WHERE bucket = $1`
+	// GlobalConcurrencySlotQuery locks the first free slot of the global concurrency lock $1, which has $2 slots,
+	// selecting no rows when every slot is locked. Slots are session-level advisory locks, so that they're held outside
+	// of jobs' transactions, and released by the database when the connections of processes that crash are closed.
+	GlobalConcurrencySlotQuery = `SELECT slot FROM generate_series(0, $2::int - 1) AS slot
+					WHERE pg_try_advisory_lock(hashtext($1), slot)
+					LIMIT 1`
+	// ReleaseGlobalConcurrencySlotQuery releases slot $2 of the global concurrency lock $1
+	ReleaseGlobalConcurrencySlotQuery = `SELECT pg_advisory_unlock(hashtext($1), $2)`
+	// GlobalRunningQuery counts the locked slots of the global concurrency lock $1, i.e. the number of the queue's jobs
+	// being processed across every process
+	GlobalRunningQuery = `SELECT count(*) FROM pg_locks
+					WHERE locktype = 'advisory'
+					AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+					AND classid = hashtext($1)::oid
+					AND objsubid = 2
+					AND granted`
+	// WriteJobProgressQuery writes the result and progress of job $1 while it's being processed. See resultWriter.
+	WriteJobProgressQuery = `INSERT INTO neoq_job_progress(job_id, result, progress)
+					VALUES ($1, $2, $3)
+					ON CONFLICT (job_id) DO UPDATE SET result = $2, progress = $3, updated_at = NOW()`
+	// JobProgressQuery selects the result and progress written by job $1's handler while it's being processed
+	JobProgressQuery = `SELECT result, progress FROM neoq_job_progress WHERE job_id = $1`
+	// DeleteJobProgressQuery deletes the result and progress of job $1 once they're persisted with its status
+	DeleteJobProgressQuery    = `DELETE FROM neoq_job_progress WHERE job_id = $1`
 	setIdleInTxSessionTimeout = `SET idle_in_transaction_session_timeout = 0`
+	// jobFields are the neoq_jobs columns that map to jobs.Job fields
+	jobFields = `id,fingerprint,queue,status,deadline,payload,raw_payload,codec,retries,max_retries,run_after,ran_at,` +
+		`created_at,error,trace_context,metadata,backoff,snoozes,priority,result,progress,` +
+		`ARRAY(SELECT d.depends_on::text FROM neoq_job_dependencies d WHERE d.job_id = id ORDER BY d.depends_on) ` +
+		`AS depends_on,COALESCE(batch_id::text, '') AS batch_id`
+	// diedDependencyQuery selects the dependencies of waiting job j on jobs that have died, i.e. jobs that have been
+	// cancelled or are no longer in neoq_jobs
+	diedDependencyQuery = `SELECT 1 FROM neoq_job_dependencies d
+						WHERE d.job_id = j.id
+						AND NOT EXISTS (
+							SELECT 1 FROM neoq_jobs parent WHERE parent.id = d.depends_on AND parent.status <> 'cancelled'
+						)`
+	// runningJobQuery selects the advisory lock that workers hold on job o while processing it. Jobs whose IDs differ
+	// by a multiple of 2^32 share their locks, which only matters if they also share their fingerprints. See
+	// RunningJobLockQuery.
+	runningJobQuery = `SELECT 1 FROM pg_locks l
+								WHERE l.locktype = 'advisory'
+								AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+								AND l.classid = hashtext('neoq_running_job')::oid
+								AND l.objid = (o.id & 4294967295)::oid
+								AND l.objsubid = 2
+								AND l.granted`
+	// pendingKeyJobQuery selects the most recently enqueued job with fingerprint $1 that is pending, i.e. new and not yet
+	// due, which jobs with enqueue modes are coalesced with. See jobs.EnqueueMode.
+	pendingKeyJobQuery = `SELECT id FROM neoq_jobs
+								WHERE fingerprint = $1
+								AND status = 'new'
+								AND run_after > NOW()
+								ORDER BY id DESC
+								LIMIT 1
+								FOR UPDATE SKIP LOCKED`
+	// refilledTokens are the tokens of rate limit bucket l, including those gained since it was last updated. See
+	// TakeRateLimitTokenQuery.
+	refilledTokens = `LEAST($2::float8, l.tokens + EXTRACT(EPOCH FROM NOW() - l.updated_at)::float8 / $3::float8)`
+)
+
+// fingerprintLockStripes is the number of locks that the fingerprints of enqueued jobs are hashed onto. See
+// LockFingerprintsQuery.
+const fingerprintLockStripes = 128
+
+// maxListenerReconnectBackoff is the longest that queue listeners wait between attempts to reconnect
+const maxListenerReconnectBackoff = 30 * time.Second
+
+// Handlers' results and progress are written at most once every progressWriteInterval, and writes that can't get a
+// connection within progressWriteTimeout are skipped. See resultWriter.
+const (
+	progressWriteInterval = 500 * time.Millisecond
+	progressWriteTimeout  = 5 * time.Second
+)
+
+// Workers of queues at their global concurrency wait between attempts to acquire a slot, starting with
+// minGlobalConcurrencyBackoff and doubling up to the job check interval. Slots are released within
+// releaseGlobalConcurrencySlotTimeout, or their connections are closed.
+const (
+	minGlobalConcurrencyBackoff         = 10 * time.Millisecond
+	releaseGlobalConcurrencySlotTimeout = 5 * time.Second
 )
 
 type contextKey struct{}
 
+type handlerTxContextKey struct{}
+
 var (
 	txCtxVarKey                   contextKey
+	handlerTxCtxVarKey            handlerTxContextKey
 	shutdownJobID                 = "-1" // job ID announced when triggering a shutdown
+	pendingJobsAnnouncementID     = "-3" // job ID announced when many jobs are added to a queue at once
 	shutdownAnnouncementAllowance = 100  // ms
 	ErrCnxString                  = errors.New("invalid connecton string: see documentation for valid connection strings")
 	ErrDuplicateJob               = errors.New("duplicate job")
 	ErrNoTransactionInContext     = errors.New("context does not have a Tx set")
+	ErrInvalidJobID               = errors.New("invalid job ID")
+	ErrHandlerTxManaged           = errors.New("handler transactions are committed and rolled back by neoq")
+	ErrHandlerTxFinished          = errors.New("handler transactions may not be used after their handlers return or time out")
+	ErrHandlerTxBusy              = errors.New("handler transaction is in use")
 )
 
 // PgBackend is a Postgres-based Neoq backend
@@ -78,6 +401,14 @@ type PgBackend struct {
 	futureJobs  map[string]time.Time       // map of future job IDs to their due time
 	handlers    map[string]handler.Handler // a map of queue names to queue handlers
 	cancelFuncs []context.CancelFunc       // A collection of cancel functions to be called upon Shutdown()
+	listeners   map[string]*listenerStats  // a map of queue names to the stats of their listeners
+	running     map[int64]*jobs.Job        // a map of job IDs to the jobs that are being processed
+}
+
+// listenerStats counts the activity of queue listeners
+type listenerStats struct {
+	notifications atomic.Int64
+	reconnects    atomic.Int64
 }
 
 // Backend initializes a new postgres-backed neoq backend
@@ -113,6 +444,8 @@ func Backend(ctx context.Context, opts ...neoq.ConfigOption) (pb neoq.Neoq, err
 		config:      cfg,
 		handlers:    make(map[string]handler.Handler),
 		futureJobs:  make(map[string]time.Time),
+		listeners:   make(map[string]*listenerStats),
+		running:     make(map[int64]*jobs.Job),
 		cron:        cron.New(),
 		cancelFuncs: []context.CancelFunc{},
 	}
@@ -196,6 +529,123 @@ func txFromContext(ctx context.Context) (t pgx.Tx, err error) {
 	return
 }
 
+// TxFromContext gets the transaction of the job being handled from a handler's context
+//
+// The transaction is the same transaction that locks the job while it's being handled, and that updates the job's
+// status when the handler returns. Database writes made with it are committed if and only if the job is marked
+// 'processed'. When the handler returns an error, or any of the handler's statements fail, the handler's writes are
+// rolled back and the job fails as usual.
+//
+// Handlers may not commit or roll back the transaction themselves. Calling Commit or Rollback on it has no effect and
+// returns [ErrHandlerTxManaged]. Handlers that need finer-grained control may begin nested transactions (savepoints)
+// with the transaction's Begin method.
+//
+// Handlers must not use the transaction after they return, or concurrently from multiple goroutines. Once handlers
+// return or time out, the transaction returns [ErrHandlerTxFinished], and while it's in use, e.g. while rows that it
+// queried are open, it returns [ErrHandlerTxBusy]. Handlers that time out while their rows are open hold up their jobs'
+// completion until the rows are closed.
+func TxFromContext(ctx context.Context) (tx pgx.Tx, err error) {
+	htx, ok := ctx.Value(handlerTxCtxVarKey).(*handlerTx)
+	if !ok {
+		err = ErrNoTransactionInContext
+		return
+	}
+
+	err = htx.begin(ctx)
+	if err != nil {
+		return
+	}
+
+	return htx, nil
+}
+
+// resultWriter is the [jobs.ResultWriter] of the Postgres backend
+//
+// Jobs' rows are locked while they're processed, so results and progress are written to neoq_job_progress outside of
+// jobs' transactions, at most once every progressWriteInterval, where they can be looked up by other processes. They're
+// persisted along with jobs' statuses once their handlers return.
+type resultWriter struct {
+	p       *PgBackend
+	job     *jobs.Job
+	mu      *sync.Mutex // serializes writes to neoq_job_progress, so that none are made once the job is finished
+	written time.Time   // the last time that the job's result and progress were written
+	next    *time.Timer // writes the job's latest result and progress once progressWriteInterval has passed
+	done    bool
+}
+
+func (w *resultWriter) WriteResult(_ context.Context, result []byte) error {
+	w.p.mu.Lock()
+	w.job.Result = append([]byte(nil), result...)
+	w.p.mu.Unlock()
+
+	w.write()
+	return nil
+}
+
+func (w *resultWriter) WriteProgress(_ context.Context, progress jobs.Progress) error {
+	w.p.mu.Lock()
+	w.job.Progress = &progress
+	w.p.mu.Unlock()
+
+	w.write()
+	return nil
+}
+
+// write schedules the job's latest result and progress to be written, unless a write is already scheduled
+//
+// Writes are asynchronous so that handlers are not held up waiting for connections, which may all be held by jobs'
+// transactions.
+func (w *resultWriter) write() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done || w.next != nil {
+		return
+	}
+
+	w.next = time.AfterFunc(progressWriteInterval-time.Since(w.written), func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		w.next = nil
+		if w.done {
+			return
+		}
+
+		w.p.mu.RLock()
+		result, progress := w.job.Result, w.job.Progress
+		w.p.mu.RUnlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), progressWriteTimeout)
+		defer cancel()
+		_, err := w.p.pool.Exec(ctx, WriteJobProgressQuery, w.job.ID, result, progress)
+		w.written = time.Now()
+		if err != nil {
+			w.p.logger.Error("unable to write job progress", "job_id", w.job.ID, "error", err)
+		}
+	})
+}
+
+// finish stops writing the job's result and progress, and deletes what was written within tx, since they're persisted
+// with the job's status
+func (w *resultWriter) finish(ctx context.Context, tx pgx.Tx) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.done = true
+	if w.next != nil {
+		w.next.Stop()
+		w.next = nil
+	}
+
+	if w.written.IsZero() {
+		return
+	}
+
+	_, err = tx.Exec(ctx, DeleteJobProgressQuery, w.job.ID)
+	return
+}
+
 // initializeDB initializes the tables, types, and indices necessary to operate Neoq
 //
 //nolint:funlen,gocyclo,cyclop
@@ -246,6 +696,11 @@ func (p *PgBackend) initializeDB() (err error) {
 
 // Enqueue adds jobs to the specified queue
 func (p *PgBackend) Enqueue(ctx context.Context, job *jobs.Job) (jobID string, err error) {
+	return p.config.InterceptEnqueue(ctx, job, p.enqueue)
+}
+
+// enqueue adds jobs that have passed through the configured enqueue interceptors to the specified queue
+func (p *PgBackend) enqueue(ctx context.Context, job *jobs.Job) (jobID string, err error) {
 	if job.Queue == "" {
 		err = jobs.ErrNoQueueSpecified
 		return
@@ -280,17 +735,18 @@ func (p *PgBackend) Enqueue(ctx context.Context, job *jobs.Job) (jobID string, e
 		job.RunAfter = now
 	}
 
+	if job.EnqueueMode != nil {
+		job.RunAfter = now.Add(job.EnqueueMode.Window)
+	}
+
 	jobID, err = p.enqueueJob(ctx, tx, job)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == pgerrcode.UniqueViolation {
-				err = ErrDuplicateJob
-				return
-			}
+		if errors.Is(err, ErrDuplicateJob) {
+			return
 		}
 		p.logger.Error("error enqueueing job", "error", err)
 		err = fmt.Errorf("error enqueuing job: %w", err)
+		return
 	}
 
 	err = tx.Commit(ctx)
@@ -313,189 +769,1311 @@ func (p *PgBackend) Enqueue(ctx context.Context, job *jobs.Job) (jobID string, e
 	return jobID, nil
 }
 
-// Start starts processing jobs with the specified queue and handler
-func (p *PgBackend) Start(ctx context.Context, h handler.Handler) (err error) {
-	ctx, cancel := context.WithCancel(ctx)
-
-	p.logger.Debug("starting job processing", "queue", h.Queue)
-	p.mu.Lock()
-	p.cancelFuncs = append(p.cancelFuncs, cancel)
-	p.handlers[h.Queue] = h
-	p.mu.Unlock()
+// EnqueueTx adds jobs to the specified queue within the caller's transaction
+//
+// Jobs enqueued with EnqueueTx are only added to the queue if, and when, tx is committed. Workers are not notified of
+// new jobs until tx is committed, so rolled back jobs are never seen by workers.
+//
+// Duplicate jobs are rejected with [ErrDuplicateJob] without aborting tx.
+//
+// EnqueueTx is not part of the [neoq.Neoq] interface. It's available on the Postgres backend returned by [neoq.New]:
+//
+//	nq.(*postgres.PgBackend).EnqueueTx(ctx, tx, job)
+func (p *PgBackend) EnqueueTx(ctx context.Context, tx pgx.Tx, job *jobs.Job) (jobID string, err error) {
+	return p.config.InterceptEnqueue(ctx, job, func(ctx context.Context, job *jobs.Job) (string, error) {
+		return p.enqueueTx(ctx, tx, job)
+	})
+}
 
-	err = p.start(ctx, h)
-	if err != nil {
-		p.logger.Error("unable to start processing queue", "queue", h.Queue, "error", err)
+// enqueueTx adds jobs that have passed through the configured enqueue interceptors to the specified queue within
+// the caller's transaction
+func (p *PgBackend) enqueueTx(ctx context.Context, tx pgx.Tx, job *jobs.Job) (jobID string, err error) {
+	if job.Queue == "" {
+		err = jobs.ErrNoQueueSpecified
 		return
 	}
-	return
-}
 
-// StartCron starts processing jobs with the specified cron schedule and handler
-//
-// See: https://pkg.go.dev/github.com/robfig/cron?#hdr-CRON_Expression_Format for details on the cron spec format
-func (p *PgBackend) StartCron(ctx context.Context, cronSpec string, h handler.Handler) (err error) {
-	cd, err := crondescriptor.NewCronDescriptor(cronSpec)
-	if err != nil {
-		p.logger.Error("error creating cron descriptor", "cronspec", cronSpec, "error", err)
-		return fmt.Errorf("error creating cron descriptor: %w", err)
+	p.logger.Debug("enqueueing job payload in the caller's transaction", slog.Any("job_payload", job.Payload))
+
+	now := time.Now().UTC()
+	if job.RunAfter.IsZero() {
+		p.logger.Debug("RunAfter not set, job will run immediately after the transaction commits")
+		job.RunAfter = now
 	}
 
-	cdStr, err := cd.GetDescription(crondescriptor.Full)
-	if err != nil {
-		p.logger.Error("error getting cron descriptor", "descriptor", crondescriptor.Full, "error", err)
-		return fmt.Errorf("error getting cron description: %w", err)
+	if job.EnqueueMode != nil {
+		job.RunAfter = now.Add(job.EnqueueMode.Window)
 	}
 
-	queue := internal.StripNonAlphanum(strcase.ToSnake(*cdStr))
-	h.Queue = queue
+	// a savepoint allows duplicate jobs to be rejected without aborting the caller's transaction
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("error creating savepoint: %w", err)
+		return
+	}
+	defer func(ctx context.Context) { _ = savepoint.Rollback(ctx) }(ctx) // rollback has no effect if the savepoint has been released
 
-	ctx, cancel := context.WithCancel(ctx)
-	p.mu.Lock()
-	p.cancelFuncs = append(p.cancelFuncs, cancel)
-	p.mu.Unlock()
+	jobID, err = p.enqueueJob(ctx, savepoint, job)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateJob) {
+			return
+		}
+		err = fmt.Errorf("error enqueuing job: %w", err)
+		return
+	}
 
-	if err = p.cron.AddFunc(cronSpec, func() {
-		_, err := p.Enqueue(ctx, &jobs.Job{Queue: queue})
+	// NOTIFY is transactional; listeners are only notified when the caller's transaction commits
+	if job.RunAfter.Equal(now) {
+		_, err = savepoint.Exec(ctx, fmt.Sprintf("NOTIFY %s, '%s'", job.Queue, jobID))
 		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				return
-			}
-
-			p.logger.Error("error queueing cron job", "error", err)
+			err = fmt.Errorf("error announcing job: %w", err)
+			return
 		}
-	}); err != nil {
-		return fmt.Errorf("error adding cron: %w", err)
 	}
 
-	return p.Start(ctx, h)
-}
-
-// SetLogger sets this backend's logger
-func (p *PgBackend) SetLogger(logger logging.Logger) {
-	p.logger = logger
-}
-
-// Shutdown shuts this backend down
-func (p *PgBackend) Shutdown(ctx context.Context) {
-	p.logger.Debug("starting shutdown.")
-	for queue := range p.handlers {
-		p.announceJob(ctx, queue, shutdownJobID)
+	err = savepoint.Commit(ctx)
+	if err != nil {
+		err = fmt.Errorf("error releasing savepoint: %w", err)
+		return
 	}
 
-	// wait for the announcement to process
-	time.Sleep(time.Duration(shutdownAnnouncementAllowance) * time.Millisecond)
-
-	for _, f := range p.cancelFuncs {
-		f()
+	// future jobs from transactions that are rolled back are harmless; announcing jobs that don't exist is a no-op
+	if !job.RunAfter.Equal(now) {
+		p.mu.Lock()
+		p.futureJobs[jobID] = job.RunAfter
+		p.mu.Unlock()
+		p.logger.Debug("added job to future jobs list", "job_id", jobID, "run_after", job.RunAfter)
 	}
 
-	p.pool.Close()
-	p.cron.Stop()
-
-	p.cancelFuncs = nil
-	p.logger.Debug("shutdown complete")
+	return jobID, nil
 }
 
-// enqueueJob adds jobs to the queue, returning the job ID
+// EnqueueMany adds many jobs to their queues with a single INSERT
 //
-// Jobs that are not already fingerprinted are fingerprinted before being added
-// Duplicate jobs are not added to the queue. Any two unprocessed jobs with the same fingerprint are duplicates
-func (p *PgBackend) enqueueJob(ctx context.Context, tx pgx.Tx, j *jobs.Job) (jobID string, err error) {
-	err = jobs.FingerprintJob(j)
+// Job IDs are returned in the same order as the jobs they belong to. Jobs that duplicate an existing job, or an earlier
+// job in the same batch, are not added and have the job ID [jobs.DuplicateJobID]. See [jobs.UniqueScope]. Debounced
+// jobs have the IDs of the pending jobs that they're debounced into, and throttled jobs that are dropped have the job
+// ID [jobs.DuplicateJobID]. See [jobs.EnqueueMode].
+//
+// Rather than announcing every new job, one announcement is made per queue, prompting workers to fetch all of the
+// queue's pending jobs.
+func (p *PgBackend) EnqueueMany(ctx context.Context, js []*jobs.Job) (jobIDs []string, err error) {
+	return p.config.InterceptEnqueueMany(ctx, js, p.enqueueMany)
+}
+
+// enqueueMany adds many jobs that have passed through the configured enqueue interceptors to their queues
+func (p *PgBackend) enqueueMany(ctx context.Context, js []*jobs.Job) (jobIDs []string, err error) {
+	now := time.Now().UTC()
+	tx, err := p.pool.Begin(ctx)
 	if err != nil {
+		err = fmt.Errorf("error creating transaction: %w", err)
 		return
 	}
+	defer func(ctx context.Context) { _ = tx.Rollback(ctx) }(ctx) // rollback has no effect if the transaction has been committed
 
-	p.logger.Debug("adding job to the queue")
-	err = tx.QueryRow(ctx, `INSERT INTO neoq_jobs(queue, fingerprint, payload, run_after, deadline)
-		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-		j.Queue, j.Fingerprint, j.Payload, j.RunAfter, j.Deadline).Scan(&jobID)
+	jobIDs, err = p.insertJobs(ctx, tx, js, nil, now)
 	if err != nil {
-		err = fmt.Errorf("unable add job to queue: %w", err)
-		return
+		return nil, err
 	}
 
-	return jobID, err
-}
-
-// moveToDeadQueue moves jobs from the pending queue to the dead queue
-func (p *PgBackend) moveToDeadQueue(ctx context.Context, tx pgx.Tx, j *jobs.Job, jobErr error) (err error) {
-	_, err = tx.Exec(ctx, "DELETE FROM neoq_jobs WHERE id = $1", j.ID)
+	err = tx.Commit(ctx)
 	if err != nil {
-		return
+		err = fmt.Errorf("error committing transaction: %w", err)
+		return nil, err
 	}
 
-	_, err = tx.Exec(ctx, `INSERT INTO neoq_dead_jobs(id, queue, fingerprint, payload, retries, max_retries, error, deadline)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		j.ID, j.Queue, j.Fingerprint, j.Payload, j.Retries, j.MaxRetries, jobErr.Error(), j.Deadline)
+	p.announceJobs(ctx, js, jobIDs, now)
 
-	return
+	return jobIDs, nil
 }
 
-// updateJob updates the status of jobs with: status, run time, error messages, and retries
-//
-// if the retry count exceeds the maximum number of retries for the job, move the job to the dead jobs queue
-//
-// if `tx`'s underlying connection dies while updating job status, the transaction will fail, and the job's original
-// status will be reflecting in the database.
+// EnqueueBatch adds a batch of jobs to their queues with a single INSERT, returning the batch's ID
 //
-// The implication of this is that:
-// - the job's 'error' field will not reflect any errors the occurred in the handler
-// - the job's retry count is not incremented
-// - the job's run time will remain its original value
-// - the job has its original 'status'
+// Batches are completed, and their callback jobs are enqueued, by the last of their jobs to be processed or die, in
+// the transaction that records its outcome.
 //
-// ultimately, this means that any time a database connection is lost while updating job status, then the job will be
-// processed at least one more time.
-// nolint: cyclop
-func (p *PgBackend) updateJob(ctx context.Context, jobErr error) (err error) {
-	status := internal.JobStatusProcessed
-	errMsg := ""
-
-	if jobErr != nil {
-		p.logger.Error("job failed", "job_error", jobErr)
-		status = internal.JobStatusFailed
-		errMsg = jobErr.Error()
+// Batches are committed once all of their jobs make it through the configured enqueue interceptors. Interceptors that
+// return errors after their jobs were enqueued can't undo the batch, so the ID of the committed batch is returned
+// along with the error. Batches whose jobs are rejected by interceptors before they're enqueued are not committed.
+func (p *PgBackend) EnqueueBatch(ctx context.Context, batch *jobs.Batch) (batchID string, err error) {
+	if batch.Callback != nil && batch.Callback.Queue == "" {
+		err = jobs.ErrNoQueueSpecified
+		return
 	}
 
-	var job *jobs.Job
-	if job, err = jobs.FromContext(ctx); err != nil {
-		return fmt.Errorf("error getting job from context: %w", err)
+	for _, job := range batch.Jobs {
+		if job.EnqueueMode != nil {
+			err = jobs.ErrBatchedEnqueueMode
+			return
+		}
 	}
 
-	var tx pgx.Tx
-	if tx, err = txFromContext(ctx); err != nil {
-		return fmt.Errorf("error getting tx from context: %w", err)
+	_, err = p.config.InterceptEnqueueMany(ctx, batch.Jobs, func(ctx context.Context, js []*jobs.Job) (jobIDs []string,
+		err error,
+	) {
+		batchID, jobIDs, err = p.enqueueBatch(ctx, js, batch.Callback)
+		return
+	})
+
+	// batches without any jobs that made it through the interceptors are completed empty, so that their callbacks run
+	if err == nil && batchID == "" {
+		batchID, _, err = p.enqueueBatch(ctx, nil, batch.Callback)
 	}
 
-	if job.Retries >= job.MaxRetries {
-		err = p.moveToDeadQueue(ctx, tx, job, jobErr)
+	return
+}
+
+// enqueueBatch adds a batch of jobs that have passed through the configured enqueue interceptors to their queues
+func (p *PgBackend) enqueueBatch(ctx context.Context, js []*jobs.Job, callback *jobs.Job) (batchID string,
+	jobIDs []string, err error,
+) {
+	now := time.Now().UTC()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("error creating transaction: %w", err)
 		return
 	}
+	defer func(ctx context.Context) { _ = tx.Rollback(ctx) }(ctx) // rollback has no effect if the transaction has been committed
 
-	var runAfter time.Time
-	if status == internal.JobStatusFailed {
-		runAfter = internal.CalculateBackoff(job.Retries)
-		qstr := "UPDATE neoq_jobs SET ran_at = $1, error = $2, status = $3, retries = $4, run_after = $5 WHERE id = $6"
-		_, err = tx.Exec(ctx, qstr, time.Now().UTC(), errMsg, status, job.Retries, runAfter, job.ID)
-	} else {
-		qstr := "UPDATE neoq_jobs SET ran_at = $1, error = $2, status = $3 WHERE id = $4"
-		_, err = tx.Exec(ctx, qstr, time.Now().UTC(), errMsg, status, job.ID)
+	var id int64
+	err = tx.QueryRow(ctx, "INSERT INTO neoq_batches(callback) VALUES ($1) RETURNING id", callback).Scan(&id)
+	if err != nil {
+		err = fmt.Errorf("error creating batch: %w", err)
+		return
 	}
 
+	jobIDs, err = p.insertJobs(ctx, tx, js, &id, now)
 	if err != nil {
 		return
 	}
 
-	if time.Until(runAfter) > 0 {
-		p.mu.Lock()
-		p.futureJobs[fmt.Sprint(job.ID)] = runAfter
-		p.mu.Unlock()
+	total := 0
+	for _, jobID := range jobIDs {
+		if jobID != jobs.DuplicateJobID {
+			total++
+		}
 	}
 
-	return nil
-}
+	_, err = tx.Exec(ctx, "UPDATE neoq_batches SET total = $2 WHERE id = $1", id, total)
+	if err != nil {
+		err = fmt.Errorf("error creating batch: %w", err)
+		return
+	}
+
+	// batches without jobs have no jobs to complete them
+	if total == 0 {
+		err = p.completeBatch(ctx, tx, fmt.Sprint(id))
+		if err != nil {
+			err = fmt.Errorf("error completing batch: %w", err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		err = fmt.Errorf("error committing transaction: %w", err)
+		return
+	}
+
+	p.announceJobs(ctx, js, jobIDs, now)
+
+	return fmt.Sprint(id), jobIDs, nil
+}
+
+// GetBatch retrieves the status of a batch by ID
+func (p *PgBackend) GetBatch(ctx context.Context, batchID string) (status *jobs.BatchStatus, err error) {
+	id, err := strconv.ParseInt(batchID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", jobs.ErrBatchNotFound, batchID)
+	}
+
+	status = &jobs.BatchStatus{}
+	err = p.pool.QueryRow(ctx, BatchQuery, id).Scan(&status.ID, &status.Total, &status.Succeeded, &status.Dead,
+		&status.CallbackJobID, &status.CreatedAt, &status.CompletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", jobs.ErrBatchNotFound, batchID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting batch: %w", err)
+	}
+
+	return
+}
+
+// insertJobs adds many jobs to their queues within tx, skipping any that duplicate existing jobs or earlier jobs in js,
+// or that are coalesced with them, and returning their job IDs in the same order as js. Jobs are added to the batch with
+// ID batchID, when it's not nil.
+func (p *PgBackend) insertJobs(ctx context.Context, tx pgx.Tx, js []*jobs.Job, batchID *int64, now time.Time) (
+	jobIDs []string, err error,
+) {
+	for _, job := range js {
+		if job.Queue == "" {
+			err = jobs.ErrNoQueueSpecified
+			return
+		}
+
+		if job.RunAfter.IsZero() {
+			job.RunAfter = now
+		}
+
+		if job.EnqueueMode != nil {
+			job.RunAfter = now.Add(job.EnqueueMode.Window)
+		}
+
+		err = jobs.EncodePayload(job, p.config.Codec(job.Queue))
+		if err != nil {
+			return
+		}
+
+		p.applyHandlerUnique(job)
+		err = jobs.FingerprintJob(job)
+		if err != nil {
+			return
+		}
+	}
+
+	err = p.lockFingerprints(ctx, tx, js)
+	if err != nil {
+		return
+	}
+
+	coalescedIDs, debouncedInto, latest, err := p.coalesceJobs(ctx, tx, js)
+	if err != nil {
+		return
+	}
+
+	duplicates, err := p.duplicateJobs(ctx, tx, js)
+	if err != nil {
+		return
+	}
+
+	added := make([]int, 0, len(js)) // the indexes in js of the jobs that are added
+	queues := make([]string, 0, len(js))
+	fingerprints := make([]string, 0, len(js))
+	payloads := make([]map[string]any, 0, len(js))
+	rawPayloads := make([][]byte, 0, len(js))
+	codecs := make([]string, 0, len(js))
+	runAfters := make([]time.Time, 0, len(js))
+	deadlines := make([]*time.Time, 0, len(js))
+	traceContexts := make([]map[string]string, 0, len(js))
+	metadata := make([]map[string]string, 0, len(js))
+	maxRetries := make([]int, 0, len(js))
+	backoffs := make([]*jobs.Backoff, 0, len(js))
+	priorities := make([]int, 0, len(js))
+	for i, job := range js {
+		if duplicates[i] {
+			continue
+		}
+		if _, ok := coalescedIDs[i]; ok {
+			continue
+		}
+		if _, ok := debouncedInto[i]; ok {
+			continue
+		}
+
+		// jobs that later jobs in js are debounced into are added with the last of their payloads
+		last := job
+		if l, ok := latest[i]; ok {
+			last = js[l]
+		}
+
+		added = append(added, i)
+		queues = append(queues, job.Queue)
+		fingerprints = append(fingerprints, job.Fingerprint)
+		payloads = append(payloads, last.Payload)
+		rawPayloads = append(rawPayloads, last.RawPayload)
+		codecs = append(codecs, last.Codec)
+		runAfters = append(runAfters, last.RunAfter)
+		deadlines = append(deadlines, job.Deadline)
+		traceContexts = append(traceContexts, last.TraceContext)
+		metadata = append(metadata, last.Metadata)
+		maxRetries = append(maxRetries, job.MaxRetries)
+		backoffs = append(backoffs, job.Backoff)
+		priorities = append(priorities, job.Priority)
+	}
+
+	p.logger.Debug("enqueueing many jobs", "count", len(added))
+	rows, err := tx.Query(ctx, EnqueueManyQuery, queues, fingerprints, payloads, rawPayloads, codecs, runAfters,
+		deadlines, traceContexts, metadata, maxRetries, backoffs, priorities, batchID, p.priorityAging())
+	if err != nil {
+		err = fmt.Errorf("error enqueuing jobs: %w", err)
+		return
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		err = fmt.Errorf("error enqueuing jobs: %w", err)
+		return
+	}
+	if len(ids) != len(added) {
+		err = fmt.Errorf("error enqueuing jobs: %d of %d jobs were added", len(ids), len(added))
+		return
+	}
+
+	// jobs are added in order, so their IDs ascend in the same order as js
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	jobIDs = make([]string, len(js))
+	for i := range js {
+		jobIDs[i] = jobs.DuplicateJobID
+	}
+
+	for n, i := range added {
+		job := js[i]
+		jobIDs[i] = fmt.Sprint(ids[n])
+		if batchID != nil {
+			job.BatchID = fmt.Sprint(*batchID)
+		}
+
+		err = p.addDependencies(ctx, tx, jobIDs[i], job.DependsOn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, jobID := range coalescedIDs {
+		jobIDs[i] = jobID
+	}
+
+	for i, earlier := range debouncedInto {
+		jobIDs[i] = jobIDs[earlier]
+	}
+
+	return jobIDs, nil
+}
+
+// coalesceJobs applies the enqueue modes of js when jobs with the same keys are pending, or earlier jobs in js have the
+// same keys. See [jobs.EnqueueMode].
+//
+// coalescedIDs maps the indexes of jobs that are coalesced with pending jobs, or throttled by earlier jobs in js, to
+// the IDs that they're enqueued as. debouncedInto maps the indexes of jobs that are debounced into earlier jobs in js to
+// the indexes of those jobs, and latest maps the indexes of those earlier jobs to the indexes of the last jobs that are
+// debounced into them.
+//
+// The fingerprints of jobs with enqueue modes must be locked by tx, so that jobs with the same keys can't be enqueued
+// concurrently. See lockFingerprints.
+func (p *PgBackend) coalesceJobs(ctx context.Context, tx pgx.Tx, js []*jobs.Job) (coalescedIDs map[int]string,
+	debouncedInto, latest map[int]int, err error,
+) {
+	coalescedIDs = map[int]string{}
+	debouncedInto = map[int]int{}
+	latest = map[int]int{}
+	added := map[string]int{} // the fingerprints of the jobs in js with enqueue modes that are added, to their indexes
+	for i, job := range js {
+		if job.EnqueueMode == nil {
+			continue
+		}
+
+		if earlier, ok := added[job.Fingerprint]; ok {
+			if job.EnqueueMode.Strategy == jobs.Throttled {
+				coalescedIDs[i] = jobs.DuplicateJobID
+				continue
+			}
+
+			debouncedInto[i] = earlier
+			latest[earlier] = i
+			continue
+		}
+
+		jobID, coalesced, err := p.coalesceJob(ctx, tx, job)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if coalesced {
+			coalescedIDs[i] = jobID
+		} else {
+			added[job.Fingerprint] = i
+		}
+	}
+
+	return
+}
+
+// coalesceJob applies a job's enqueue mode when a job with the same key is pending, returning the ID that the job is
+// enqueued as: the pending job's ID when the job is debounced, or [jobs.DuplicateJobID] when the job is throttled
+//
+// The job's fingerprint must be locked by tx. See lockFingerprints.
+func (p *PgBackend) coalesceJob(ctx context.Context, tx pgx.Tx, job *jobs.Job) (jobID string, coalesced bool, err error) {
+	var id int64
+	if job.EnqueueMode.Strategy == jobs.Throttled {
+		err = tx.QueryRow(ctx, ThrottledJobQuery, job.Fingerprint).Scan(&id)
+	} else {
+		err = tx.QueryRow(ctx, DebouncedJobQuery, job.Fingerprint, job.Payload, job.RawPayload, job.Codec, job.RunAfter,
+			job.TraceContext, job.Metadata, p.priorityAging()).Scan(&id)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error coalescing job with pending jobs: %w", err)
+	}
+
+	if job.EnqueueMode.Strategy == jobs.Throttled {
+		return jobs.DuplicateJobID, true, nil
+	}
+
+	return fmt.Sprint(id), true, nil
+}
+
+// lockFingerprints locks the fingerprints of the jobs in js that are unique or have enqueue modes until tx ends, so that
+// duplicates of them, or jobs with the same keys, can't be enqueued concurrently. See LockFingerprintsQuery.
+//
+// Fingerprints are locked all at once, since locking them in more than one statement could deadlock with other
+// transactions.
+func (p *PgBackend) lockFingerprints(ctx context.Context, tx pgx.Tx, js []*jobs.Job) (err error) {
+	fingerprints := make([]string, 0, len(js))
+	for _, job := range js {
+		if job.EnqueueMode != nil || job.UniqueScope() != jobs.NotUnique {
+			fingerprints = append(fingerprints, job.Fingerprint)
+		}
+	}
+
+	if len(fingerprints) == 0 {
+		return
+	}
+
+	_, err = tx.Exec(ctx, LockFingerprintsQuery, fingerprints, fingerprintLockStripes)
+	if err != nil {
+		return fmt.Errorf("error locking job fingerprints: %w", err)
+	}
+
+	return
+}
+
+// duplicateJobs reports which of js duplicate existing jobs, or earlier jobs in js, according to their uniqueness
+// scopes. See [jobs.UniqueScope]. Jobs with enqueue modes are never duplicates.
+//
+// The fingerprints of jobs that may be duplicates must be locked by tx, so that duplicates of them can't be enqueued
+// concurrently. See lockFingerprints.
+func (p *PgBackend) duplicateJobs(ctx context.Context, tx pgx.Tx, js []*jobs.Job) (duplicates []bool, err error) {
+	duplicates = make([]bool, len(js))
+	unique := make([]int, 0, len(js)) // the indexes in js of the jobs that may be duplicates
+	fingerprints := make([]string, 0, len(js))
+	queuedOnly := make([]bool, 0, len(js))
+	windows := make([]float64, 0, len(js))
+	for i, job := range js {
+		if job.UniqueScope() == jobs.NotUnique || job.EnqueueMode != nil {
+			continue
+		}
+
+		unique = append(unique, i)
+		fingerprints = append(fingerprints, job.Fingerprint)
+		queuedOnly = append(queuedOnly, job.UniqueScope() == jobs.UniqueWhileQueued)
+		windows = append(windows, job.UniqueWindow().Seconds())
+	}
+
+	if len(unique) > 0 {
+		var rows pgx.Rows
+		rows, err = tx.Query(ctx, DuplicateJobsQuery, fingerprints, queuedOnly, windows)
+		if err != nil {
+			return nil, fmt.Errorf("error finding duplicate jobs: %w", err)
+		}
+
+		var ordinal int64
+		_, err = pgx.ForEachRow(rows, []any{&ordinal}, func() error {
+			duplicates[unique[ordinal-1]] = true
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error finding duplicate jobs: %w", err)
+		}
+	}
+
+	added := map[string]bool{} // the fingerprints of the jobs in js that are added
+	for i, job := range js {
+		if job.EnqueueMode != nil {
+			continue
+		}
+
+		if !duplicates[i] && added[job.Fingerprint] && job.UniqueScope() != jobs.NotUnique {
+			duplicates[i] = true
+		}
+
+		if !duplicates[i] {
+			added[job.Fingerprint] = true
+		}
+	}
+
+	return
+}
+
+// applyHandlerUnique applies the uniqueness of the handler of a job's queue to jobs that don't configure their own
+func (p *PgBackend) applyHandlerUnique(job *jobs.Job) {
+	p.mu.RLock()
+	h, ok := p.handlers[job.Queue]
+	p.mu.RUnlock()
+
+	if ok {
+		job.Unique = h.JobUnique(job)
+	}
+}
+
+// announceJobs announces newly added jobs that are due, once per queue, and schedules future jobs
+func (p *PgBackend) announceJobs(ctx context.Context, js []*jobs.Job, jobIDs []string, now time.Time) {
+	announceQueues := map[string]bool{}
+	for i, job := range js {
+		if jobIDs[i] == jobs.DuplicateJobID {
+			continue
+		}
+
+		if job.RunAfter.Equal(now) {
+			announceQueues[job.Queue] = true
+		} else {
+			p.mu.Lock()
+			p.futureJobs[jobIDs[i]] = job.RunAfter
+			p.mu.Unlock()
+		}
+	}
+
+	for queue := range announceQueues {
+		p.announceJob(ctx, queue, pendingJobsAnnouncementID)
+	}
+}
+
+// Start starts processing jobs with the specified queue and handler
+func (p *PgBackend) Start(ctx context.Context, h handler.Handler) (err error) {
+	h.Middleware = p.config.HandlerMiddleware(h)
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	p.logger.Debug("starting job processing", "queue", h.Queue)
+	p.mu.Lock()
+	p.cancelFuncs = append(p.cancelFuncs, cancel)
+	p.handlers[h.Queue] = h
+	p.mu.Unlock()
+
+	err = p.start(ctx, h)
+	if err != nil {
+		p.logger.Error("unable to start processing queue", "queue", h.Queue, "error", err)
+		return
+	}
+	return
+}
+
+// StartCron starts processing jobs with the specified cron schedule and handler
+//
+// See: https://pkg.go.dev/github.com/robfig/cron?#hdr-CRON_Expression_Format for details on the cron spec format
+func (p *PgBackend) StartCron(ctx context.Context, cronSpec string, h handler.Handler) (err error) {
+	cd, err := crondescriptor.NewCronDescriptor(cronSpec)
+	if err != nil {
+		p.logger.Error("error creating cron descriptor", "cronspec", cronSpec, "error", err)
+		return fmt.Errorf("error creating cron descriptor: %w", err)
+	}
+
+	cdStr, err := cd.GetDescription(crondescriptor.Full)
+	if err != nil {
+		p.logger.Error("error getting cron descriptor", "descriptor", crondescriptor.Full, "error", err)
+		return fmt.Errorf("error getting cron description: %w", err)
+	}
+
+	queue := internal.StripNonAlphanum(strcase.ToSnake(*cdStr))
+	h.Queue = queue
+
+	ctx, cancel := context.WithCancel(ctx)
+	p.mu.Lock()
+	p.cancelFuncs = append(p.cancelFuncs, cancel)
+	p.mu.Unlock()
+
+	if err = p.cron.AddFunc(cronSpec, func() {
+		_, err := p.Enqueue(ctx, &jobs.Job{Queue: queue})
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			p.logger.Error("error queueing cron job", "error", err)
+		}
+	}); err != nil {
+		return fmt.Errorf("error adding cron: %w", err)
+	}
+
+	return p.Start(ctx, h)
+}
+
+// QueueStats reports the number of due jobs on every queue, along with the state of this process's handlers and
+// listeners, and the number of jobs running across every process on queues with global concurrency limits
+func (p *PgBackend) QueueStats(ctx context.Context) (stats []neoq.QueueStats, err error) {
+	rows, err := p.pool.Query(ctx, QueueStatsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("error querying queue stats: %w", err)
+	}
+
+	byQueue := map[string]*neoq.QueueStats{}
+	var queue string
+	var pending int
+	var oldestPending time.Time
+	_, err = pgx.ForEachRow(rows, []any{&queue, &pending, &oldestPending}, func() error {
+		byQueue[queue] = &neoq.QueueStats{Queue: queue, Pending: pending, OldestPending: oldestPending}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying queue stats: %w", err)
+	}
+
+	p.mu.RLock()
+	for queue, h := range p.handlers {
+		qs, ok := byQueue[queue]
+		if !ok {
+			qs = &neoq.QueueStats{Queue: queue}
+			byQueue[queue] = qs
+		}
+
+		qs.Concurrency = h.Concurrency
+		qs.GlobalConcurrency = h.GlobalConcurrency
+		if ls, ok := p.listeners[queue]; ok {
+			qs.Notifications = ls.notifications.Load()
+			qs.ListenerReconnects = ls.reconnects.Load()
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, qs := range byQueue {
+		if qs.GlobalConcurrency <= 0 {
+			continue
+		}
+
+		err = p.pool.QueryRow(ctx, GlobalRunningQuery, globalConcurrencyLock(qs.Queue)).Scan(&qs.GlobalRunning)
+		if err != nil {
+			return nil, fmt.Errorf("error querying queue stats: %w", err)
+		}
+	}
+
+	for _, qs := range byQueue {
+		stats = append(stats, *qs)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Queue < stats[j].Queue })
+
+	return
+}
+
+// GetJob retrieves a job by ID
+//
+// Jobs that have exhausted their retries are retrieved from the dead jobs table.
+//
+// The results and progress of jobs that are being processed by this backend are up to date. The results and progress
+// of jobs that are being processed by other processes are as of their last write, which lags by up to half a second.
+// See resultWriter.
+func (p *PgBackend) GetJob(ctx context.Context, jobID string) (job *jobs.Job, err error) {
+	if _, err = strconv.ParseInt(jobID, 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: %s", jobs.ErrJobNotFound, jobID)
+	}
+
+	job, err = p.getJob(ctx, JobQuery, jobID)
+	if errors.Is(err, jobs.ErrJobNotFound) {
+		p.logger.Debug("job not found in the jobs table, checking dead jobs", "job_id", jobID)
+		job, err = p.getJob(ctx, DeadJobQuery, jobID)
+	} else if err == nil && job.Status != internal.JobStatusProcessed && job.Status != internal.JobStatusCancelled {
+		err = p.pool.QueryRow(ctx, JobProgressQuery, job.ID).Scan(&job.Result, &job.Progress)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = nil
+		} else if err != nil {
+			err = fmt.Errorf("error fetching job progress: %w", err)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	p.mu.RLock()
+	if running, ok := p.running[job.ID]; ok {
+		job.Result = running.Result
+		job.Progress = running.Progress
+	}
+	p.mu.RUnlock()
+
+	return
+}
+
+// ListDeadJobs lists jobs from the dead jobs table, ordered by job ID
+func (p *PgBackend) ListDeadJobs(ctx context.Context, opts ...neoq.ListOption) (deadJobs []*jobs.Job, err error) {
+	o := neoq.NewListOptions(opts...)
+	rows, err := p.pool.Query(ctx, DeadJobsQuery, o.Queue, o.PageSize, o.Offset())
+	if err != nil {
+		err = fmt.Errorf("error listing dead jobs: %w", err)
+		return
+	}
+
+	deadJobs, err = pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[jobs.Job])
+	if err != nil {
+		err = fmt.Errorf("error listing dead jobs: %w", err)
+	}
+
+	return
+}
+
+// RequeueDeadJobs moves jobs from the dead jobs table back to the jobs table with their retries reset
+//
+// Requeued jobs keep their original job IDs
+func (p *PgBackend) RequeueDeadJobs(ctx context.Context, jobIDs ...string) (count int, err error) {
+	ids, err := parseJobIDs(jobIDs)
+	if err != nil {
+		return
+	}
+
+	rows, err := p.pool.Query(ctx, RequeueDeadJobsQuery, ids, p.priorityAging())
+	if err != nil {
+		err = fmt.Errorf("error requeueing dead jobs: %w", err)
+		return
+	}
+
+	var jobID, queue string
+	requeued := map[string]string{}
+	_, err = pgx.ForEachRow(rows, []any{&jobID, &queue}, func() error {
+		requeued[jobID] = queue
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("error requeueing dead jobs: %w", err)
+		return
+	}
+
+	for jobID, queue := range requeued {
+		p.logger.Debug("requeued dead job", "job_id", jobID, "queue", queue)
+		p.announceJob(ctx, queue, jobID)
+	}
+
+	return len(requeued), nil
+}
+
+// DeleteDeadJobs deletes jobs from the dead jobs table
+func (p *PgBackend) DeleteDeadJobs(ctx context.Context, jobIDs ...string) (count int, err error) {
+	ids, err := parseJobIDs(jobIDs)
+	if err != nil {
+		return
+	}
+
+	tag, err := p.pool.Exec(ctx, "DELETE FROM neoq_dead_jobs WHERE id = ANY($1)", ids)
+	if err != nil {
+		err = fmt.Errorf("error deleting dead jobs: %w", err)
+		return
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// PurgeDeadJobs deletes all jobs on a queue from the dead jobs table, or all dead jobs when queue is empty
+func (p *PgBackend) PurgeDeadJobs(ctx context.Context, queue string) (count int, err error) {
+	tag, err := p.pool.Exec(ctx, "DELETE FROM neoq_dead_jobs WHERE ($1::text = '' OR queue = $1)", queue)
+	if err != nil {
+		err = fmt.Errorf("error purging dead jobs: %w", err)
+		return
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// SetLogger sets this backend's logger
+func (p *PgBackend) SetLogger(logger logging.Logger) {
+	p.logger = logger
+}
+
+// Shutdown shuts this backend down
+func (p *PgBackend) Shutdown(ctx context.Context) {
+	p.logger.Debug("starting shutdown.")
+	for queue := range p.handlers {
+		p.announceJob(ctx, queue, shutdownJobID)
+	}
+
+	// wait for the announcement to process
+	time.Sleep(time.Duration(shutdownAnnouncementAllowance) * time.Millisecond)
+
+	for _, f := range p.cancelFuncs {
+		f()
+	}
+
+	p.pool.Close()
+	p.cron.Stop()
+
+	p.cancelFuncs = nil
+	p.logger.Debug("shutdown complete")
+}
+
+// enqueueJob adds jobs to the queue, returning the job ID
+//
+// Job payloads are encoded by their queue's codec, and jobs that are not already fingerprinted are fingerprinted before
+// being added
+// Duplicate jobs are not added to the queue, and result in [ErrDuplicateJob]. Whether jobs are duplicates of jobs with
+// the same fingerprint depends on their uniqueness scopes. See [jobs.UniqueScope]. Jobs with enqueue modes are
+// coalesced with pending jobs that have the same key, and throttled jobs also result in [ErrDuplicateJob]. See
+// [jobs.EnqueueMode].
+func (p *PgBackend) enqueueJob(ctx context.Context, tx pgx.Tx, j *jobs.Job) (jobID string, err error) {
+	err = jobs.EncodePayload(j, p.config.Codec(j.Queue))
+	if err != nil {
+		return
+	}
+
+	p.applyHandlerUnique(j)
+	err = jobs.FingerprintJob(j)
+	if err != nil {
+		return
+	}
+
+	err = p.lockFingerprints(ctx, tx, []*jobs.Job{j})
+	if err != nil {
+		return
+	}
+
+	coalescedIDs, _, _, err := p.coalesceJobs(ctx, tx, []*jobs.Job{j})
+	if err != nil {
+		return
+	}
+	if jobID, ok := coalescedIDs[0]; ok {
+		if jobID == jobs.DuplicateJobID {
+			return "", ErrDuplicateJob
+		}
+
+		return jobID, nil
+	}
+
+	duplicates, err := p.duplicateJobs(ctx, tx, []*jobs.Job{j})
+	if err != nil {
+		return
+	}
+	if duplicates[0] {
+		return "", ErrDuplicateJob
+	}
+
+	p.logger.Debug("adding job to the queue")
+	err = tx.QueryRow(ctx, `INSERT INTO neoq_jobs(queue, fingerprint, payload, raw_payload, codec, run_after, deadline,
+		trace_context, metadata, max_retries, backoff, priority, aged_run_after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $6::timestamptz - make_interval(secs => $12 * $13::float8))
+		RETURNING id`,
+		j.Queue, j.Fingerprint, j.Payload, j.RawPayload, j.Codec, j.RunAfter, j.Deadline, j.TraceContext,
+		j.Metadata, j.MaxRetries, j.Backoff, j.Priority, p.priorityAging()).Scan(&jobID)
+	if err != nil {
+		err = fmt.Errorf("unable add job to queue: %w", err)
+		return
+	}
+
+	err = p.addDependencies(ctx, tx, jobID, j.DependsOn)
+	if err != nil {
+		return
+	}
+
+	return jobID, err
+}
+
+// addDependencies records the jobs that a job depends on, making the job wait for them unless they've all been
+// processed
+//
+// Jobs that depend on jobs which have already died wait until they're cancelled or failed by resolveDependencies
+func (p *PgBackend) addDependencies(ctx context.Context, tx pgx.Tx, jobID string, dependsOn []string) (err error) {
+	if len(dependsOn) == 0 {
+		return
+	}
+
+	parentIDs, err := parseJobIDs(dependsOn)
+	if err != nil {
+		return fmt.Errorf("%w: %w", jobs.ErrJobNotFound, err)
+	}
+
+	var found, processed int
+	err = tx.QueryRow(ctx, `SELECT count(*), count(*) FILTER (WHERE status = 'processed')
+		FROM (
+			SELECT status FROM neoq_jobs WHERE id = ANY($1)
+			UNION ALL
+			SELECT status FROM neoq_dead_jobs WHERE id = ANY($1)
+		) parents`, parentIDs).Scan(&found, &processed)
+	if err != nil {
+		return fmt.Errorf("error finding the jobs that job %s depends on: %w", jobID, err)
+	}
+
+	distinct := map[int64]bool{}
+	for _, id := range parentIDs {
+		distinct[id] = true
+	}
+	if found < len(distinct) {
+		return fmt.Errorf("%w: job %s depends on jobs that don't exist: %v", jobs.ErrJobNotFound, jobID, dependsOn)
+	}
+
+	id, err := strconv.ParseInt(jobID, 10, 64)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO neoq_job_dependencies(job_id, depends_on)
+		SELECT $1, unnest($2::bigint[])
+		ON CONFLICT DO NOTHING`, id, parentIDs)
+	if err != nil {
+		return fmt.Errorf("error adding the dependencies of job %s: %w", jobID, err)
+	}
+
+	if processed == found {
+		return
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE neoq_jobs SET status = 'waiting' WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("unable to make job %s wait for its dependencies: %w", jobID, err)
+	}
+
+	return
+}
+
+// moveToDeadQueue moves jobs from the pending queue to the dead queue
+func (p *PgBackend) moveToDeadQueue(ctx context.Context, tx pgx.Tx, j *jobs.Job, jobErr error) (err error) {
+	_, err = tx.Exec(ctx, "DELETE FROM neoq_jobs WHERE id = $1", j.ID)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO neoq_dead_jobs(id, queue, fingerprint, payload, raw_payload, codec, retries,
+		max_retries, error, deadline, run_after, ran_at, trace_context, metadata, backoff, snoozes, priority, result,
+		progress, batch_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19,
+			NULLIF($20, '')::bigint, $21)`,
+		j.ID, j.Queue, j.Fingerprint, j.Payload, j.RawPayload, j.Codec, j.Retries, j.MaxRetries, jobErr.Error(), j.Deadline,
+		j.RunAfter, time.Now().UTC(), j.TraceContext, j.Metadata, j.Backoff, j.Snoozes, j.Priority, j.Result, j.Progress,
+		j.BatchID, j.CreatedAt)
+
+	return
+}
+
+// updateJob updates the status of jobs with: status, run time, error messages, and retries
+//
+// if the retry count exceeds the maximum number of retries for the job, move the job to the dead jobs queue
+//
+// if `tx`'s underlying connection dies while updating job status, the transaction will fail, and the job's original
+// status will be reflecting in the database.
+//
+// The implication of this is that:
+// - the job's 'error' field will not reflect any errors the occurred in the handler
+// - the job's retry count is not incremented
+// - the job's run time will remain its original value
+// - the job has its original 'status'
+//
+// ultimately, this means that any time a database connection is lost while updating job status, then the job will be
+// processed at least one more time.
+// nolint: cyclop
+func (p *PgBackend) updateJob(ctx context.Context, h handler.Handler, jobErr error) (err error) {
+	ctx, span := p.config.Tracer().Start(ctx, "neoq.update")
+	defer func() { neoq.EndSpan(span, err) }()
+
+	var job *jobs.Job
+	if job, err = jobs.FromContext(ctx); err != nil {
+		return fmt.Errorf("error getting job from context: %w", err)
+	}
+
+	var tx pgx.Tx
+	if tx, err = txFromContext(ctx); err != nil {
+		return fmt.Errorf("error getting tx from context: %w", err)
+	}
+
+	if d, snoozed := jobs.Snoozed(jobErr); snoozed {
+		return p.snoozeJob(ctx, tx, job, d)
+	}
+
+	status := internal.JobStatusProcessed
+	errMsg := ""
+
+	if jobErr != nil {
+		p.logger.Error("job failed", "job_error", jobErr)
+		status = internal.JobStatusFailed
+		errMsg = jobErr.Error()
+	}
+
+	if jobErr != nil && !retryable(h, job, jobErr) {
+		err = p.moveToDeadQueue(ctx, tx, job, jobErr)
+		if err != nil {
+			return
+		}
+
+		return p.finalizeJob(ctx, tx, job)
+	}
+
+	var runAfter time.Time
+	if status == internal.JobStatusFailed {
+		runAfter = time.Now().UTC().Add(h.RetryDelay(job, jobErr))
+		job.RunAfter = runAfter
+		qstr := `UPDATE neoq_jobs SET ran_at = $1, error = $2, status = $3, retries = $4, run_after = $5, result = $6,
+			progress = $7, aged_run_after = $5::timestamptz - make_interval(secs => priority * $9::float8) WHERE id = $8`
+		_, err = tx.Exec(ctx, qstr, time.Now().UTC(), errMsg, status, job.Retries, runAfter, job.Result, job.Progress,
+			job.ID, p.priorityAging())
+	} else {
+		qstr := "UPDATE neoq_jobs SET ran_at = $1, error = $2, status = $3, result = $4, progress = $5 WHERE id = $6"
+		_, err = tx.Exec(ctx, qstr, time.Now().UTC(), errMsg, status, job.Result, job.Progress, job.ID)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if status == internal.JobStatusProcessed {
+		job.Status = status
+		return p.finalizeJob(ctx, tx, job)
+	}
+
+	if time.Until(runAfter) > 0 {
+		p.mu.Lock()
+		p.futureJobs[fmt.Sprint(job.ID)] = runAfter
+		p.mu.Unlock()
+	}
+
+	return nil
+}
+
+// finalizeJob releases the dependents of a job that has been processed, and completes the batch of a job that has
+// been processed or died if it was the last of the batch's jobs, within the transaction that records the job's outcome
+func (p *PgBackend) finalizeJob(ctx context.Context, tx pgx.Tx, job *jobs.Job) (err error) {
+	// dependents are locked before batches, as they are when jobs die with their dependencies, see killDependents
+	if job.Status == internal.JobStatusProcessed {
+		err = p.releaseDependents(ctx, tx, job)
+		if err != nil {
+			return fmt.Errorf("unable to release the jobs that depend on job %d: %w", job.ID, err)
+		}
+	}
+
+	if job.BatchID == "" {
+		return
+	}
+
+	err = p.completeBatch(ctx, tx, job.BatchID)
+	if err != nil {
+		return fmt.Errorf("unable to complete batch %s: %w", job.BatchID, err)
+	}
+
+	return
+}
+
+// releaseDependents makes the waiting jobs that depend on job pending within tx once all the jobs that they depend on
+// have been processed, notifying their queues when tx commits
+func (p *PgBackend) releaseDependents(ctx context.Context, tx pgx.Tx, job *jobs.Job) (err error) {
+	rows, err := tx.Query(ctx, LockDependentJobsQuery, job.ID)
+	if err != nil {
+		return
+	}
+
+	var jobID string
+	waiting, err := pgx.ForEachRow(rows, []any{&jobID}, func() error { return nil })
+	if err != nil || waiting.RowsAffected() == 0 {
+		return
+	}
+
+	rows, err = tx.Query(ctx, ReleaseJobDependentsQuery, job.ID)
+	if err != nil {
+		return
+	}
+
+	var queue string
+	var runAfter time.Time
+	queues := map[string]bool{}
+	now := time.Now()
+	_, err = pgx.ForEachRow(rows, []any{&jobID, &queue, &runAfter}, func() error {
+		p.logger.Debug("releasing job whose dependencies have been processed", "job_id", jobID)
+		// future jobs from transactions that are rolled back are harmless; announcing jobs that don't exist is a no-op
+		if runAfter.After(now) {
+			p.mu.Lock()
+			p.futureJobs[jobID] = runAfter
+			p.mu.Unlock()
+		} else {
+			queues[queue] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// NOTIFY is transactional; listeners are only notified when the transaction commits
+	for queue := range queues {
+		_, err = tx.Exec(ctx, fmt.Sprintf("NOTIFY %s, '%s'", queue, pendingJobsAnnouncementID))
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// snoozeJob reschedules jobs to run again after d
+//
+// Snoozed jobs become new again, and their retries are not updated, so that their next run is not counted as a retry
+func (p *PgBackend) snoozeJob(ctx context.Context, tx pgx.Tx, job *jobs.Job, d time.Duration) (err error) {
+	runAfter := time.Now().UTC().Add(d)
+	job.Snoozes++
+	job.RunAfter = runAfter
+	qstr := `UPDATE neoq_jobs SET ran_at = $1, status = $2, snoozes = $3, run_after = $4, result = $5, progress = $6,
+		aged_run_after = $4::timestamptz - make_interval(secs => priority * $8::float8) WHERE id = $7`
+	_, err = tx.Exec(ctx, qstr, time.Now().UTC(), internal.JobStatusNew, job.Snoozes, runAfter, job.Result,
+		job.Progress, job.ID, p.priorityAging())
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.futureJobs[fmt.Sprint(job.ID)] = runAfter
+	p.mu.Unlock()
+
+	return
+}
+
+// acquireConn acquires the connection that a job is handled with, which holds one of the queue's global concurrency
+// slots when the queue has a global concurrency. Connections, and their slots, are released by release.
+func (p *PgBackend) acquireConn(ctx context.Context, h handler.Handler) (conn *pgxpool.Conn, release func(), err error) {
+	if h.GlobalConcurrency <= 0 {
+		conn, err = p.pool.Acquire(ctx)
+		if err != nil {
+			return
+		}
+
+		return conn, conn.Release, nil
+	}
+
+	lock := globalConcurrencyLock(h.Queue)
+	backoff := minGlobalConcurrencyBackoff
+	for {
+		conn, err = p.pool.Acquire(ctx)
+		if err != nil {
+			return
+		}
+
+		var slot int
+		err = conn.QueryRow(ctx, GlobalConcurrencySlotQuery, lock, h.GlobalConcurrency).Scan(&slot)
+		if err == nil {
+			return conn, func() { p.releaseGlobalConcurrencySlot(conn, lock, slot) }, nil
+		}
+
+		if !errors.Is(err, pgx.ErrNoRows) {
+			// the slot may have been locked before the error, so the connection must not be reused
+			_ = conn.Conn().Close(ctx)
+			conn.Release()
+			return nil, nil, fmt.Errorf("unable to acquire global concurrency slot: %w", err)
+		}
+		conn.Release()
+
+		select {
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff)))): // nolint: gosec
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+
+		backoff *= 2
+		if backoff > p.config.JobCheckInterval && p.config.JobCheckInterval > 0 {
+			backoff = p.config.JobCheckInterval
+		}
+	}
+}
+
+// releaseGlobalConcurrencySlot releases a global concurrency slot along with the connection that holds it
+//
+// Slots are released even after handlers' contexts are done, since they would otherwise remain locked by the pooled
+// connection. Connections whose slots can't be released are closed, which releases their slots.
+func (p *PgBackend) releaseGlobalConcurrencySlot(conn *pgxpool.Conn, lock string, slot int) {
+	defer conn.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseGlobalConcurrencySlotTimeout)
+	defer cancel()
+
+	_, err := conn.Exec(ctx, ReleaseGlobalConcurrencySlotQuery, lock, slot)
+	if err != nil {
+		p.logger.Error("unable to release global concurrency slot, closing its connection", "error", err, "slot", slot)
+		_ = conn.Conn().Close(ctx)
+	}
+}
+
+// globalConcurrencyLock is the name of the advisory lock whose slots limit the global concurrency of a queue
+func globalConcurrencyLock(queue string) string {
+	return "neoq_global_concurrency:" + queue
+}
+
+// rateLimitByKey takes rate limit tokens for the queue's pending jobs, returning the ID of the first job that may be
+// processed, and the bucket that its token was taken from. Jobs whose key has no tokens are rescheduled, so that they
+// don't hold up jobs with other keys.
+//
+// Jobs are locked only for as long as it takes to select them, so other workers may select them too. Tokens taken for
+// jobs that other workers lock first are refunded. See refundRateLimitToken.
+func (p *PgBackend) rateLimitByKey(ctx context.Context, conn *pgxpool.Conn, h handler.Handler) (jobID, bucket string,
+	err error,
+) {
+	for {
+		var rows pgx.Rows
+		rows, err = conn.Query(ctx, p.pendingJobQuery(), h.Queue)
+		if err != nil {
+			return
+		}
+
+		var job *jobs.Job
+		job, err = pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[jobs.Job])
+		if err != nil {
+			return
+		}
+		jobID = fmt.Sprint(job.ID)
+
+		var keyed bool
+		bucket, keyed = h.RateLimit.Bucket(h.Queue, job)
+		if !keyed {
+			err = p.waitForRateLimit(ctx, conn, h, bucket)
+			return
+		}
+
+		var wait time.Duration
+		wait, err = p.takeRateLimitToken(ctx, conn, h.RateLimit, bucket, false)
+		if err != nil || wait <= 0 {
+			return
+		}
+
+		p.logger.Debug("job's rate limit exceeded, rescheduling", "job_id", jobID, "bucket", bucket)
+		runAfter := time.Now().UTC().Add(h.RateLimitDelay(wait))
+		var tag pgconn.CommandTag
+		tag, err = conn.Exec(ctx, RateLimitJobQuery, job.ID, runAfter, p.priorityAging())
+		if err != nil {
+			return "", "", fmt.Errorf("unable to reschedule rate-limited job: %w", err)
+		}
+
+		if tag.RowsAffected() > 0 {
+			p.mu.Lock()
+			p.futureJobs[jobID] = runAfter
+			p.mu.Unlock()
+		}
+	}
+}
+
+// waitForRateLimit reserves a token from a rate limit bucket, and waits until the token is due
+func (p *PgBackend) waitForRateLimit(ctx context.Context, db rateLimitDB, h handler.Handler, bucket string) (err error) {
+	wait, err := p.takeRateLimitToken(ctx, db, h.RateLimit, bucket, true)
+	if err != nil || wait <= 0 {
+		return
+	}
+
+	select {
+	case <-time.After(wait):
+		return
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refundRateLimitToken returns a token to a rate limit bucket when no job could be locked with it, so that tokens are
+// only spent on jobs that are processed
+func (p *PgBackend) refundRateLimitToken(ctx context.Context, rate *handler.Rate, bucket string) (err error) {
+	_, err = p.pool.Exec(ctx, RefundRateLimitTokenQuery, bucket, float64(rate.Limit))
+	if err != nil {
+		return fmt.Errorf("unable to refund rate limit token: %w", err)
+	}
+
+	return
+}
+
+// rateLimitDB is the connection or pool that rate limit tokens are taken with, outside of any transaction
+type rateLimitDB interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// takeRateLimitToken takes a token from a rate limit bucket, returning how long to wait for it when the bucket is empty.
+// See TakeRateLimitTokenQuery.
+func (p *PgBackend) takeRateLimitToken(ctx context.Context, db rateLimitDB, rate *handler.Rate, bucket string,
+	reserve bool,
+) (wait time.Duration, err error) {
+	var seconds float64
+	err = db.QueryRow(ctx, TakeRateLimitTokenQuery, bucket, float64(rate.Limit), rate.Interval().Seconds(), reserve).
+		Scan(&seconds)
+	if err != nil {
+		return 0, fmt.Errorf("unable to take rate limit token: %w", err)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
 
 // start starts processing new, pending, and future jobs
 // nolint: cyclop
@@ -509,7 +2087,7 @@ func (p *PgBackend) start(ctx context.Context, h handler.Handler) (err error) {
 	listenJobChan, ready := p.listen(ctx, h.Queue) // listen for 'new' jobs
 	defer close(ready)
 
-	pendingJobsChan := p.pendingJobs(ctx, h.Queue) // process overdue jobs *at startup*
+	pendingJobsChan, wakePendingJobs := p.pendingJobs(ctx, h.Queue) // process overdue jobs *at startup*
 
 	// wait for the listener to connect and be ready to listen
 	<-ready
@@ -525,84 +2103,296 @@ func (p *PgBackend) start(ctx context.Context, h handler.Handler) (err error) {
 			for {
 				select {
 				case jobID = <-listenJobChan:
-					err = p.handleJob(ctx, jobID, h)
-				case jobID = <-pendingJobsChan:
-					err = p.handleJob(ctx, jobID, h)
+					if jobID == pendingJobsAnnouncementID {
+						p.processPendingJobs(wakePendingJobs)
+						continue
+					}
+					err = p.handleJob(ctx, h)
+				case <-pendingJobsChan:
+					err = p.handleJob(ctx, h)
 				case <-ctx.Done():
 					return
 				}
 
-				if err != nil {
-					if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, context.Canceled) {
-						err = nil
-						continue
-					}
+				if err != nil {
+					if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, context.Canceled) {
+						err = nil
+						continue
+					}
+
+					p.logger.Error("job failed", "error", err, "queue", h.Queue)
+
+					continue
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// initFutureJobs is intended to be run once to initialize the list of future jobs that must be monitored for
+// execution. it should be run only during system startup.
+func (p *PgBackend) initFutureJobs(ctx context.Context, queue string) (err error) {
+	rows, err := p.pool.Query(ctx, FutureJobQuery, queue)
+	if err != nil {
+		p.logger.Error("failed to fetch future jobs list", err)
+		return
+	}
+
+	var id string
+	var runAfter time.Time
+	_, err = pgx.ForEachRow(rows, []any{&id, &runAfter}, func() error {
+		p.mu.Lock()
+		p.futureJobs[id] = runAfter
+		p.mu.Unlock()
+		return nil
+	})
+
+	return
+}
+
+// scheduleFutureJobs announces future jobs using NOTIFY on an interval
+func (p *PgBackend) scheduleFutureJobs(ctx context.Context, queue string) {
+	err := p.initFutureJobs(ctx, queue)
+	if err != nil {
+		return
+	}
+
+	// check for new future jobs on an interval
+	ticker := time.NewTicker(p.config.JobCheckInterval)
+
+	for {
+		p.resolveDependencies(ctx, queue)
+
+		// loop over list of future jobs, scheduling goroutines to wait for jobs that are due within the next 30 seconds
+		p.mu.Lock()
+		for jobID, runAfter := range p.futureJobs {
+			timeUntillRunAfter := time.Until(runAfter)
+			if timeUntillRunAfter <= p.config.FutureJobWindow {
+				delete(p.futureJobs, jobID)
+				go func(jid string) {
+					scheduleCh := time.After(timeUntillRunAfter)
+					<-scheduleCh
+					p.announceJob(ctx, queue, jid)
+				}(jobID)
+			}
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+			continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resolveDependencies cancels or fails a queue's waiting jobs when any of the jobs that they depend on have died,
+// according to the dependency policy, and makes them pending once all the jobs that they depend on have been processed
+//
+// Waiting jobs are usually made pending by the last of the jobs that they depend on when it's processed, see
+// releaseDependents. Jobs that were enqueued while the jobs that they depend on were being processed are made pending
+// here.
+func (p *PgBackend) resolveDependencies(ctx context.Context, queue string) {
+	query := CancelDependentJobsQuery
+	if p.config.DependencyPolicy == jobs.FailDependents {
+		query = FailDependentJobsQuery
+	}
+
+	// jobs that die because the jobs that they depend on died may have dependents of their own
+	for {
+		died, err := p.killDependents(ctx, query, queue)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				p.logger.Error("unable to resolve the dependencies of failed jobs", "queue", queue, "error", err)
+			}
+			return
+		}
+
+		if died == 0 {
+			break
+		}
+		p.logger.Debug("a job that waiting jobs depend on died", "queue", queue, "jobs", died,
+			"policy", p.config.DependencyPolicy)
+	}
+
+	rows, err := p.pool.Query(ctx, ReleaseDependentJobsQuery, queue)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			p.logger.Error("unable to release waiting jobs", "queue", queue, "error", err)
+		}
+		return
+	}
+
+	var jobID string
+	var runAfter time.Time
+	var announce bool
+	now := time.Now()
+	_, err = pgx.ForEachRow(rows, []any{&jobID, &runAfter}, func() error {
+		p.logger.Debug("releasing job whose dependencies have been processed", "job_id", jobID)
+		if runAfter.After(now) {
+			p.mu.Lock()
+			p.futureJobs[jobID] = runAfter
+			p.mu.Unlock()
+		} else {
+			announce = true
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("unable to release waiting jobs", "queue", queue, "error", err)
+		return
+	}
+
+	if announce {
+		p.announceJob(ctx, queue, pendingJobsAnnouncementID)
+	}
+}
+
+// killDependents cancels or fails a queue's waiting jobs whose dependencies have died with query, completing the
+// batches of the jobs that died within the same transaction, since they may have been their batches' last jobs
+func (p *PgBackend) killDependents(ctx context.Context, query, queue string) (died int, err error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer func(ctx context.Context) { _ = tx.Rollback(ctx) }(ctx) // rollback has no effect if the transaction has been committed
+
+	rows, err := tx.Query(ctx, query, queue, jobs.ErrDependencyFailed.Error())
+	if err != nil {
+		return
+	}
 
-					p.logger.Error("job failed", "error", err, "job_id", jobID)
+	batchIDs := map[string]bool{}
+	var batchID string
+	_, err = pgx.ForEachRow(rows, []any{&batchID}, func() error {
+		died++
+		if batchID != "" {
+			batchIDs[batchID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
 
-					continue
-				}
-			}
-		}()
+	// batches are locked in a consistent order, so that concurrent transactions don't deadlock
+	ids := make([]string, 0, len(batchIDs))
+	for id := range batchIDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		err = p.completeBatch(ctx, tx, id)
+		if err != nil {
+			return 0, fmt.Errorf("unable to complete batch %s: %w", id, err)
+		}
 	}
 
-	return nil
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return
 }
 
-// initFutureJobs is intended to be run once to initialize the list of future jobs that must be monitored for
-// execution. it should be run only during system startup.
-func (p *PgBackend) initFutureJobs(ctx context.Context, queue string) (err error) {
-	rows, err := p.pool.Query(ctx, FutureJobQuery, queue)
+// completeBatch completes the batch with ID batchID within tx if its jobs have all been processed or died, enqueueing
+// its callback job
+//
+// The batch is locked before its jobs are checked, so that when its last jobs finish concurrently, the last of them to
+// commit completes it.
+func (p *PgBackend) completeBatch(ctx context.Context, tx pgx.Tx, batchID string) (err error) {
+	var id int64
+	err = tx.QueryRow(ctx, LockBatchQuery, batchID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
 	if err != nil {
-		p.logger.Error("failed to fetch future jobs list", err)
 		return
 	}
 
-	var id string
-	var runAfter time.Time
-	_, err = pgx.ForEachRow(rows, []any{&id, &runAfter}, func() error {
+	var status jobs.BatchStatus
+	var callback *jobs.Job
+	err = tx.QueryRow(ctx, CompleteBatchQuery, id).Scan(&status.ID, &status.Total, &status.Succeeded, &callback,
+		&status.CreatedAt, &status.CompletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	status.Dead = status.Total - status.Succeeded
+	p.logger.Debug("batch completed", "batch_id", status.ID, "succeeded", status.Succeeded, "dead", status.Dead)
+	if callback == nil {
+		return
+	}
+
+	callback = jobs.NewBatchCallback(callback, status)
+	now := time.Now().UTC()
+	if callback.RunAfter.IsZero() {
+		callback.RunAfter = now
+	}
+
+	// callbacks that fail to be enqueued don't prevent their batches from completing
+	jobID, err := p.enqueueCallback(ctx, tx, status.ID, callback, now)
+	if err != nil {
+		p.logger.Error("unable to enqueue batch callback", "batch_id", status.ID, "error", err)
+		return nil
+	}
+
+	// future jobs from transactions that are rolled back are harmless; announcing jobs that don't exist is a no-op
+	if callback.RunAfter.After(now) {
 		p.mu.Lock()
-		p.futureJobs[id] = runAfter
+		p.futureJobs[jobID] = callback.RunAfter
 		p.mu.Unlock()
-		return nil
-	})
+	}
 
 	return
 }
 
-// scheduleFutureJobs announces future jobs using NOTIFY on an interval
-func (p *PgBackend) scheduleFutureJobs(ctx context.Context, queue string) {
-	err := p.initFutureJobs(ctx, queue)
+// enqueueCallback enqueues a completed batch's callback job within tx, recording its job ID on the batch, and
+// announcing it when the transaction commits if it's due by now
+//
+// Callbacks are enqueued within savepoints, so that callbacks which fail to be enqueued don't prevent their batches
+// from completing
+func (p *PgBackend) enqueueCallback(ctx context.Context, tx pgx.Tx, batchID string, callback *jobs.Job,
+	now time.Time,
+) (jobID string, err error) {
+	id, err := strconv.ParseInt(batchID, 10, 64)
 	if err != nil {
 		return
 	}
 
-	// check for new future jobs on an interval
-	ticker := time.NewTicker(p.config.JobCheckInterval)
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer func(ctx context.Context) { _ = savepoint.Rollback(ctx) }(ctx) // rollback has no effect if the savepoint has been released
 
-	for {
-		// loop over list of future jobs, scheduling goroutines to wait for jobs that are due within the next 30 seconds
-		p.mu.Lock()
-		for jobID, runAfter := range p.futureJobs {
-			timeUntillRunAfter := time.Until(runAfter)
-			if timeUntillRunAfter <= p.config.FutureJobWindow {
-				delete(p.futureJobs, jobID)
-				go func(jid string) {
-					scheduleCh := time.After(timeUntillRunAfter)
-					<-scheduleCh
-					p.announceJob(ctx, queue, jid)
-				}(jobID)
-			}
-		}
-		p.mu.Unlock()
+	jobID, err = p.enqueueJob(ctx, savepoint, callback)
+	if err != nil {
+		return
+	}
 
-		select {
-		case <-ticker.C:
-			continue
-		case <-ctx.Done():
+	_, err = savepoint.Exec(ctx, "UPDATE neoq_batches SET callback_job_id = $2::text::bigint WHERE id = $1", id, jobID)
+	if err != nil {
+		return
+	}
+
+	// NOTIFY is transactional; listeners are only notified when the transaction commits
+	if !callback.RunAfter.After(now) {
+		_, err = savepoint.Exec(ctx, fmt.Sprintf("NOTIFY %s, '%s'", callback.Queue, jobID))
+		if err != nil {
 			return
 		}
 	}
+
+	err = savepoint.Commit(ctx)
+	return
 }
 
 // announceJob announces jobs to queue listeners.
@@ -636,47 +2426,101 @@ func (p *PgBackend) announceJob(ctx context.Context, queue, jobID string) {
 	}
 }
 
-func (p *PgBackend) pendingJobs(ctx context.Context, queue string) (jobsCh chan string) {
+// pendingJobs starts the queue's pending jobs fetcher, which sends the IDs of the queue's pending jobs to jobsCh at
+// startup, and again every time that it's woken by processPendingJobs
+//
+// Each queue has one fetcher, so that announcements of many pending jobs that arrive while the queue's pending jobs
+// are already being fetched are coalesced, rather than each fetching them concurrently. Fetchers that fail to fetch
+// pending jobs try again after the job check interval.
+func (p *PgBackend) pendingJobs(ctx context.Context, queue string) (jobsCh chan string, wake chan struct{}) {
 	jobsCh = make(chan string)
+	wake = make(chan struct{}, 1)
+	wake <- struct{}{}
 
-	conn, err := p.pool.Acquire(ctx)
-	if err != nil {
-		p.logger.Error("failed to acquire database connection to listen for pending queue items", err)
-		return
-	}
-
-	go func(ctx context.Context) {
-		defer conn.Release()
-
+	go func() {
 		for {
-			jobID, err := p.getPendingJobID(ctx, conn, queue)
-			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, context.Canceled) {
-					break
+			select {
+			case <-wake:
+				if err := p.fetchPendingJobs(ctx, queue, jobsCh); err != nil {
+					p.logger.Error("failed to fetch pending jobs", "error", err, "queue", queue)
+					time.AfterFunc(p.config.JobCheckInterval, func() { p.processPendingJobs(wake) })
 				}
-
-				p.logger.Error("failed to fetch pending job", "error", err, "job_id", jobID)
-			} else {
-				jobsCh <- jobID
+			case <-ctx.Done():
+				return
 			}
 		}
-	}(ctx)
+	}()
 
 	return
 }
 
+// fetchPendingJobs sends the IDs of a queue's pending jobs to jobsCh until no pending jobs remain
+//
+// No connection is held while waiting for workers to receive job IDs, since workers need connections to process jobs.
+func (p *PgBackend) fetchPendingJobs(ctx context.Context, queue string, jobsCh chan string) (err error) {
+	for {
+		var jobID string
+		jobID, err = p.getPendingJobID(ctx, queue)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, context.Canceled) {
+				return nil
+			}
+
+			return
+		}
+
+		select {
+		case jobsCh <- jobID:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// processPendingJobs wakes the queue's pending jobs fetcher, unless it has already been woken. See pendingJobs.
+func (p *PgBackend) processPendingJobs(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
 // handleJob is the workhorse of Neoq
-// it receives pending, periodic, and retry job ids asynchronously
-// 1. handleJob first creates a transactions inside of which a row lock is acquired for the job to be processed.
+// it is called for every pending, periodic, and retry job id that is received asynchronously
+// 1. handleJob first creates a transactions inside of which a row lock is acquired for the queue's next pending job,
+// which is not necessarily the job that was received, since jobs are processed in order of priority. Received job IDs
+// only signal that the queue has pending jobs, and are processed once they're the queue's next pending job.
 // 2. handleJob secondly calls the handler on the job, and finally updates the job's status
-func (p *PgBackend) handleJob(ctx context.Context, jobID string, h handler.Handler) (err error) {
+//
+// Rate-limited queues wait for a token before their jobs are locked, since tokens are taken outside of jobs'
+// transactions. Queues limited by key take a token for the queue's next pending job before locking that job. Tokens
+// are refunded when no job is locked with them.
+func (p *PgBackend) handleJob(ctx context.Context, h handler.Handler) (err error) {
 	var job *jobs.Job
 	var tx pgx.Tx
-	conn, err := p.pool.Acquire(ctx)
+	var jobID string
+	var bucket string // the rate limit bucket that a token was taken from, which is refunded when no job is locked
+	start := time.Now()
+	if h.RateLimited() && h.RateLimit.Key == nil {
+		bucket = h.Queue
+		err = p.waitForRateLimit(ctx, p.pool, h, bucket)
+		if err != nil {
+			return
+		}
+	}
+
+	conn, release, err := p.acquireConn(ctx, h)
 	if err != nil {
 		return
 	}
-	defer conn.Release()
+	defer release()
+
+	if h.RateLimited() && h.RateLimit.Key != nil {
+		jobID, bucket, err = p.rateLimitByKey(ctx, conn, h)
+		if err != nil || jobID == "" {
+			return
+		}
+	}
 
 	tx, err = conn.Begin(ctx)
 	if err != nil {
@@ -684,29 +2528,92 @@ func (p *PgBackend) handleJob(ctx context.Context, jobID string, h handler.Handl
 	}
 	defer func(ctx context.Context) { _ = tx.Rollback(ctx) }(ctx) // rollback has no effect if the transaction has been committed
 
-	job, err = p.getPendingJob(ctx, tx, jobID)
+	fetchStart := time.Now()
+	if jobID != "" {
+		job, err = p.lockJob(ctx, tx, jobID)
+	} else {
+		job, err = p.getPendingJob(ctx, tx, h.Queue)
+	}
+	if errors.Is(err, pgx.ErrNoRows) && bucket != "" {
+		if rerr := p.refundRateLimitToken(ctx, h.RateLimit, bucket); rerr != nil {
+			p.logger.Error("job's rate limit token was not refunded", "error", rerr, "queue", h.Queue)
+		}
+	}
 	if err != nil {
 		return
 	}
 
-	if job.Deadline != nil && job.Deadline.Before(time.Now().UTC()) {
-		err = jobs.ErrJobExceededDeadline
-		p.logger.Debug("job deadline is in he past, skipping", "job_id", job.ID)
-		err = p.updateJob(ctx, err)
+	// jobs that are unique while queued don't duplicate jobs that are being processed
+	_, err = tx.Exec(ctx, RunningJobLockQuery, job.ID)
+	if err != nil {
 		return
 	}
+	fetchEnd := time.Now()
+
+	p.mu.Lock()
+	p.running[job.ID] = job
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.running, job.ID)
+		p.mu.Unlock()
+	}()
+
+	// the job's trace context is only known once it's fetched, so its spans are started retroactively
+	ctx, span := p.config.StartJobSpan(ctx, job, start)
+	defer func() { neoq.EndSpan(span, err) }()
+	_, fetchSpan := p.config.Tracer().Start(ctx, "neoq.fetch", trace.WithTimestamp(fetchStart))
+	fetchSpan.End(trace.WithTimestamp(fetchEnd))
 
 	ctx = withJobContext(ctx, job)
+	rw := &resultWriter{p: p, job: job, mu: &sync.Mutex{}}
+	ctx = jobs.WithResultWriter(ctx, rw)
+	ctx = p.config.ExtractMetadata(ctx, job)
 	ctx = context.WithValue(ctx, txCtxVarKey, tx)
+	htx := &handlerTx{guardedTx: guardedTx{Tx: tx, guard: &txGuard{mu: &sync.Mutex{}}}}
+	ctx = context.WithValue(ctx, handlerTxCtxVarKey, htx)
 
 	// check if the job is being retried and increment retry count accordingly
 	if job.Status != internal.JobStatusNew {
 		job.Retries++
 	}
+	event := neoq.JobEvent{Job: job, Attempt: job.Retries + 1}
+
+	if job.Deadline != nil && job.Deadline.Before(time.Now().UTC()) {
+		p.logger.Debug("job deadline is in he past, skipping", "job_id", job.ID)
+		event.Err = jobs.ErrJobExceededDeadline
+		err = p.updateJob(ctx, h, event.Err)
+		if err != nil {
+			return fmt.Errorf("error updating job status: %w", err)
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to commit skipped job: %w", err)
+		}
+
+		event.Type = neoq.JobSkipped
+		p.config.FireJobEvent(ctx, event)
+		event.Type = neoq.JobDead
+		p.config.FireJobEvent(ctx, event)
+		return
+	}
 
 	// execute the queue handler of this job
-	jobErr := handler.Exec(ctx, h)
-	err = p.updateJob(ctx, jobErr)
+	event.Type = neoq.JobStarted
+	p.config.FireJobEvent(ctx, event)
+	execStart := time.Now()
+	hctx, hspan := p.config.StartHandlerSpan(ctx, job)
+	jobErr := handler.Exec(hctx, h)
+	neoq.EndSpan(hspan, jobErr)
+	event.Duration = time.Since(execStart)
+	jobErr = htx.finish(ctx, jobErr)
+	err = rw.finish(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("unable to delete job progress: %w", err)
+	}
+
+	err = p.updateJob(ctx, h, jobErr)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			return
@@ -723,32 +2630,71 @@ func (p *PgBackend) handleJob(ctx context.Context, jobID string, h handler.Handl
 		return fmt.Errorf("%s %w", errMsg, err)
 	}
 
+	p.fireOutcomeEvents(ctx, h, event, jobErr)
+
 	return nil
 }
 
+// fireOutcomeEvents fires the lifecycle events that follow jobs' handlers running, once their outcomes are committed
+func (p *PgBackend) fireOutcomeEvents(ctx context.Context, h handler.Handler, event neoq.JobEvent, jobErr error) {
+	if _, snoozed := jobs.Snoozed(jobErr); snoozed {
+		event.Err = jobErr
+		event.Type = neoq.JobSnoozed
+		p.config.FireJobEvent(ctx, event)
+		return
+	}
+
+	if jobErr == nil {
+		event.Type = neoq.JobSucceeded
+		p.config.FireJobEvent(ctx, event)
+		return
+	}
+
+	event.Err = jobErr
+	event.Type = neoq.JobFailed
+	p.config.FireJobEvent(ctx, event)
+
+	event.Type = neoq.JobRetried
+	if !retryable(h, event.Job, jobErr) {
+		event.Type = neoq.JobDead
+	}
+	p.config.FireJobEvent(ctx, event)
+}
+
+// retryable determines whether failed jobs may be retried
+//
+// Jobs that have exhausted their retries, or that failed permanently, are not retried. See [jobs.IsPermanent].
+func retryable(h handler.Handler, job *jobs.Job, jobErr error) bool {
+	return job.Retries < h.JobMaxRetries(job) && !jobs.IsPermanent(jobErr)
+}
+
 // listen uses Postgres LISTEN to listen for jobs on a queue
-// TODO: There is currently no handling of listener disconnects in PgBackend.
-// This will lead to jobs not getting processed until the worker is restarted.
-// Implement disconnect handling.
+//
+// When the listener's connection is lost, it reconnects and announces the queue's pending jobs, so that jobs that
+// were announced while it was disconnected are processed
 func (p *PgBackend) listen(ctx context.Context, queue string) (c chan string, ready chan bool) {
 	c = make(chan string, p.handlers[queue].Concurrency)
 	ready = make(chan bool)
 
-	go func(ctx context.Context) {
-		conn, err := p.pool.Acquire(ctx)
-		if err != nil {
-			p.logger.Error("unable to acquire new listener connnection", "error", err)
-			return
-		}
-		defer p.release(ctx, conn, queue)
+	p.mu.Lock()
+	stats, ok := p.listeners[queue]
+	if !ok {
+		stats = &listenerStats{}
+		p.listeners[queue] = stats
+	}
+	p.mu.Unlock()
 
-		// set this connection's idle in transaction timeout to infinite so it is not intermittently disconnected
-		_, err = conn.Exec(ctx, fmt.Sprintf("SET idle_in_transaction_session_timeout = '0'; LISTEN %s", queue))
+	go func(ctx context.Context) {
+		conn, err := p.listenerConn(ctx, queue)
 		if err != nil {
-			err = fmt.Errorf("unable to configure listener connection: %w", err)
 			p.logger.Error("unable to configure listener connection", "error", err)
 			return
 		}
+		defer func() {
+			if conn != nil {
+				p.release(ctx, conn, queue)
+			}
+		}()
 
 		// notify start() that we're ready to listen for jobs
 		ready <- true
@@ -761,6 +2707,18 @@ func (p *PgBackend) listen(ctx context.Context, queue string) (c chan string, re
 				}
 
 				p.logger.Error("failed to wait for notification", "error", waitErr)
+				if !conn.Conn().IsClosed() {
+					continue
+				}
+
+				conn, err = p.reconnectListener(ctx, conn, queue)
+				if err != nil {
+					return
+				}
+				stats.reconnects.Add(1)
+
+				// jobs may have been announced while disconnected
+				c <- pendingJobsAnnouncementID
 				continue
 			}
 
@@ -769,6 +2727,7 @@ func (p *PgBackend) listen(ctx context.Context, queue string) (c chan string, re
 				return
 			}
 
+			stats.notifications.Add(1)
 			c <- notification.Payload
 		}
 	}(ctx)
@@ -776,6 +2735,53 @@ func (p *PgBackend) listen(ctx context.Context, queue string) (c chan string, re
 	return c, ready
 }
 
+// listenerConn acquires a connection that listens for new jobs on a queue
+func (p *PgBackend) listenerConn(ctx context.Context, queue string) (conn *pgxpool.Conn, err error) {
+	conn, err = p.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire new listener connnection: %w", err)
+	}
+
+	// set this connection's idle in transaction timeout to infinite so it is not intermittently disconnected
+	_, err = conn.Exec(ctx, fmt.Sprintf("SET idle_in_transaction_session_timeout = '0'; LISTEN %s", queue))
+	if err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("unable to configure listener connection: %w", err)
+	}
+
+	return
+}
+
+// reconnectListener replaces a queue listener's lost connection, retrying with backoff until it succeeds or ctx is
+// done
+func (p *PgBackend) reconnectListener(ctx context.Context, lost *pgxpool.Conn, queue string) (conn *pgxpool.Conn,
+	err error,
+) {
+	// closed connections are destroyed, rather than returned to the pool
+	lost.Release()
+
+	backoff := time.Second
+	for {
+		conn, err = p.listenerConn(ctx, queue)
+		if err == nil {
+			p.logger.Info("reconnected queue listener", "queue", queue)
+			return
+		}
+
+		p.logger.Error("unable to reconnect queue listener", "queue", queue, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxListenerReconnectBackoff {
+			backoff = maxListenerReconnectBackoff
+		}
+	}
+}
+
 func (p *PgBackend) release(ctx context.Context, conn *pgxpool.Conn, queue string) {
 	query := fmt.Sprintf("SET idle_in_transaction_session_timeout = '%d'; UNLISTEN %s", p.config.IdleTransactionTimeout, queue)
 	_, err := conn.Exec(ctx, query)
@@ -790,8 +2796,38 @@ func (p *PgBackend) release(ctx context.Context, conn *pgxpool.Conn, queue strin
 	conn.Release()
 }
 
-func (p *PgBackend) getPendingJob(ctx context.Context, tx pgx.Tx, jobID string) (job *jobs.Job, err error) {
-	row, err := tx.Query(ctx, PendingJobQuery, jobID)
+// priorityAging is the number of seconds that jobs are aged by for every level of priority, which is zero when jobs are
+// ordered strictly by priority
+func (p *PgBackend) priorityAging() float64 {
+	if p.config.PriorityAging <= 0 {
+		return 0
+	}
+
+	return p.config.PriorityAging.Seconds()
+}
+
+// pendingJobQuery is the query that selects a queue's next pending job, by aged run_after, or strictly by priority
+// when priority aging is disabled
+func (p *PgBackend) pendingJobQuery() string {
+	if p.config.PriorityAging <= 0 {
+		return StrictPendingJobQuery
+	}
+
+	return PendingJobQuery
+}
+
+// pendingJobIDQuery is the query that selects the ID of a queue's next pending job. See pendingJobQuery.
+func (p *PgBackend) pendingJobIDQuery() string {
+	if p.config.PriorityAging <= 0 {
+		return StrictPendingJobIDQuery
+	}
+
+	return PendingJobIDQuery
+}
+
+// getPendingJob locks and fetches the next pending job on queue
+func (p *PgBackend) getPendingJob(ctx context.Context, tx pgx.Tx, queue string) (job *jobs.Job, err error) {
+	row, err := tx.Query(ctx, p.pendingJobQuery(), queue)
 	if err != nil {
 		return
 	}
@@ -804,8 +2840,54 @@ func (p *PgBackend) getPendingJob(ctx context.Context, tx pgx.Tx, jobID string)
 	return
 }
 
-func (p *PgBackend) getPendingJobID(ctx context.Context, conn *pgxpool.Conn, queue string) (jobID string, err error) {
-	err = conn.QueryRow(ctx, PendingJobIDQuery, queue).Scan(&jobID)
+// lockJob locks a pending job by ID for processing
+func (p *PgBackend) lockJob(ctx context.Context, tx pgx.Tx, jobID string) (job *jobs.Job, err error) {
+	row, err := tx.Query(ctx, LockJobQuery, jobID)
+	if err != nil {
+		return
+	}
+
+	return pgx.CollectOneRow(row, pgx.RowToAddrOfStructByName[jobs.Job])
+}
+
+// getJob fetches a single job using the given query, which must select jobFields by job ID
+func (p *PgBackend) getJob(ctx context.Context, query, jobID string) (job *jobs.Job, err error) {
+	rows, err := p.pool.Query(ctx, query, jobID)
+	if err != nil {
+		err = fmt.Errorf("error fetching job: %w", err)
+		return
+	}
+
+	job, err = pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[jobs.Job])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = jobs.ErrJobNotFound
+			return
+		}
+
+		err = fmt.Errorf("error fetching job: %w", err)
+	}
+
+	return
+}
+
+func (p *PgBackend) getPendingJobID(ctx context.Context, queue string) (jobID string, err error) {
+	err = p.pool.QueryRow(ctx, p.pendingJobIDQuery(), queue).Scan(&jobID)
+	return
+}
+
+// parseJobIDs converts job IDs as returned by Enqueue to their database representation
+func parseJobIDs(jobIDs []string) (ids []int64, err error) {
+	ids = make([]int64, 0, len(jobIDs))
+	for _, jobID := range jobIDs {
+		var id int64
+		id, err = strconv.ParseInt(jobID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidJobID, jobID)
+		}
+		ids = append(ids, id)
+	}
+
 	return
 }
 
@@ -11,12 +11,16 @@ import (
 
 	"github.com/acaloiaro/neoq"
 	"github.com/acaloiaro/neoq/backends/postgres"
+	"github.com/acaloiaro/neoq/codec"
 	"github.com/acaloiaro/neoq/handler"
 	"github.com/acaloiaro/neoq/internal"
 	"github.com/acaloiaro/neoq/jobs"
 	"github.com/acaloiaro/neoq/logging"
 	"github.com/acaloiaro/neoq/testutils"
 	"github.com/jackc/pgx/v5"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var errPeriodicTimeout = errors.New("timed out waiting for periodic job")
@@ -43,10 +47,35 @@ func flushDB() {
 	}
 	defer conn.Close(context.Background())
 
-	_, err = conn.Query(context.Background(), "DELETE FROM neoq_jobs") // nolint: gocritic
+	_, err = conn.Exec(context.Background(), "DELETE FROM neoq_jobs") // nolint: gocritic
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "'neoq_jobs' table flush failed: %v\n", err)
 	}
+
+	_, err = conn.Exec(context.Background(), "DELETE FROM neoq_dead_jobs")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "'neoq_dead_jobs' table flush failed: %v\n", err)
+	}
+
+	_, err = conn.Exec(context.Background(), "DELETE FROM neoq_job_dependencies")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "'neoq_job_dependencies' table flush failed: %v\n", err)
+	}
+
+	_, err = conn.Exec(context.Background(), "DELETE FROM neoq_batches")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "'neoq_batches' table flush failed: %v\n", err)
+	}
+
+	_, err = conn.Exec(context.Background(), "DELETE FROM neoq_rate_limits")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "'neoq_rate_limits' table flush failed: %v\n", err)
+	}
+
+	_, err = conn.Exec(context.Background(), "DELETE FROM neoq_job_progress")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "'neoq_job_progress' table flush failed: %v\n", err)
+	}
 }
 
 func TestMain(m *testing.M) {
@@ -366,3 +395,2559 @@ results_loop:
 		flushDB()
 	})
 }
+
+// TestGetJob tests that jobs can be looked up by ID once they've been enqueued and processed
+func TestGetJob(t *testing.T) {
+	const queue = "testing"
+	done := make(chan bool)
+	defer close(done)
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	})
+
+	err = nq.Start(ctx, h)
+	if err != nil {
+		t.Error(err)
+	}
+
+	jid, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue: queue,
+		Payload: map[string]interface{}{
+			"message": "hello world",
+		},
+	})
+	if err != nil || jid == jobs.DuplicateJobID {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-done:
+	}
+
+	var j *jobs.Job
+	timeoutTimer := time.After(5 * time.Second)
+	for j == nil || j.Status != internal.JobStatusProcessed {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("job status was never updated to '%s': %+v", internal.JobStatusProcessed, j)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		j, err = nq.GetJob(ctx, jid)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if j.Queue != queue || j.Payload["message"] != "hello world" || !j.RanAt.Valid {
+		t.Errorf("job was not retrieved as it was enqueued: %+v", j)
+	}
+
+	_, err = nq.GetJob(ctx, "-1000")
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected '%v', got: %v", jobs.ErrJobNotFound, err)
+	}
+
+	_, err = nq.GetJob(ctx, "not-a-job-id")
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected '%v' for a malformed job ID, got: %v", jobs.ErrJobNotFound, err)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestDeadJobs tests that dead jobs can be listed, requeued, and deleted
+// nolint: gocognit, gocyclo
+func TestDeadJobs(t *testing.T) {
+	const queue = "testing"
+	done := make(chan bool)
+	defer close(done)
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	deadJobIDs := []string{}
+	for i := 0; i < 3; i++ {
+		var id string
+		err = conn.QueryRow(ctx, `INSERT INTO neoq_dead_jobs(id, queue, fingerprint, payload, retries, max_retries, error)
+			VALUES (nextval('neoq_jobs_id_seq'), $1, $2, $3, 23, 23, 'something bad happened') RETURNING id`,
+			queue, fmt.Sprintf("fingerprint-%d", i), map[string]any{"message": fmt.Sprintf("hello world: %d", i)}).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deadJobIDs = append(deadJobIDs, id)
+	}
+
+	deadJobs, err := nq.ListDeadJobs(ctx, neoq.ListQueue(queue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadJobs) != 3 {
+		t.Fatalf("expected 3 dead jobs, found: %d", len(deadJobs))
+	}
+
+	page, err := nq.ListDeadJobs(ctx, neoq.ListQueue(queue), neoq.ListPage(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || fmt.Sprint(page[0].ID) != deadJobIDs[2] {
+		t.Errorf("expected the second page to contain only job %s, got: %v", deadJobIDs[2], page)
+	}
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	})
+
+	err = nq.Start(ctx, h)
+	if err != nil {
+		t.Error(err)
+	}
+
+	count, err := nq.RequeueDeadJobs(ctx, deadJobIDs[0])
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 job to be requeued, got %d: %v", count, err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("requeued job was never processed")
+	case <-done:
+	}
+
+	requeued, err := nq.GetJob(ctx, deadJobIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued.Retries != 0 {
+		t.Errorf("requeued jobs should have their retries reset, but it has %d", requeued.Retries)
+	}
+
+	count, err = nq.DeleteDeadJobs(ctx, deadJobIDs[1])
+	if err != nil || count != 1 {
+		t.Errorf("expected 1 job to be deleted, got %d: %v", count, err)
+	}
+
+	count, err = nq.PurgeDeadJobs(ctx, queue)
+	if err != nil || count != 1 {
+		t.Errorf("expected 1 job to be purged, got %d: %v", count, err)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestEnqueueMany tests that many jobs can be enqueued at once, and that duplicates are reported per job
+func TestEnqueueMany(t *testing.T) {
+	const queue = "testing"
+	const numJobs = 100
+	done := make(chan bool)
+	defer close(done)
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	})
+
+	err = nq.Start(ctx, h)
+	if err != nil {
+		t.Error(err)
+	}
+
+	js := []*jobs.Job{}
+	for i := 0; i < numJobs; i++ {
+		js = append(js, &jobs.Job{
+			Queue:   queue,
+			Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", i)},
+		})
+	}
+	// the last job duplicates the first
+	js = append(js, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "hello world: 0"}})
+
+	jobIDs, err := nq.EnqueueMany(ctx, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobIDs) != numJobs+1 {
+		t.Fatalf("expected %d job IDs, got: %d", numJobs+1, len(jobIDs))
+	}
+
+	if jobIDs[numJobs] != jobs.DuplicateJobID {
+		t.Errorf("expected the last job to be a duplicate, but its ID is: %s", jobIDs[numJobs])
+	}
+
+	timeoutTimer := time.After(10 * time.Second)
+	for i := 0; i < numJobs; i++ {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("expected %d jobs to be processed, but only %d were", numJobs, i)
+		case <-done:
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestEnqueueManyLarge tests that enqueueing more unique jobs at once than the database can hold locks for doesn't
+// run out of locks, and that the jobs are still deduplicated
+func TestEnqueueManyLarge(t *testing.T) {
+	const queue = "testing"
+	// more than the default lock table holds, i.e. max_locks_per_transaction * max_connections
+	const numJobs = 20000
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	newJobs := func() []*jobs.Job {
+		js := make([]*jobs.Job, 0, numJobs)
+		for i := 0; i < numJobs; i++ {
+			js = append(js, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"i": i}})
+		}
+		return js
+	}
+
+	jobIDs, err := nq.EnqueueMany(ctx, newJobs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, jobID := range jobIDs {
+		if jobID == jobs.DuplicateJobID {
+			t.Fatalf("expected job %d not to be a duplicate", i)
+		}
+	}
+
+	jobIDs, err = nq.EnqueueMany(ctx, newJobs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, jobID := range jobIDs {
+		if jobID != jobs.DuplicateJobID {
+			t.Fatalf("expected job %d to duplicate the job that was enqueued first, got ID: %s", i, jobID)
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestEnqueueManyAnnouncements tests that many announcements of pending jobs don't exhaust the connection pool that
+// workers process jobs with
+func TestEnqueueManyAnnouncements(t *testing.T) {
+	const queue = "testing"
+	const numBatches = 20
+	const batchSize = 5
+	done := make(chan bool, numBatches*batchSize)
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	separator := "?"
+	if strings.Contains(connString, "?") {
+		separator = "&"
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString+separator+"pool_max_conns=4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	}, handler.Concurrency(2))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for b := 0; b < numBatches; b++ {
+		js := make([]*jobs.Job, 0, batchSize)
+		for i := 0; i < batchSize; i++ {
+			js = append(js, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": fmt.Sprintf("%d-%d", b, i)}})
+		}
+
+		if _, err = nq.EnqueueMany(ctx, js); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timeoutTimer := time.After(10 * time.Second)
+	for i := 0; i < numBatches*batchSize; i++ {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("expected %d jobs to be processed, but only %d were", numBatches*batchSize, i)
+		case <-done:
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestEnqueueTx tests that jobs enqueued in a caller's transaction are only processed when the transaction commits
+func TestEnqueueTx(t *testing.T) {
+	const queue = "testing"
+	done := make(chan string, 2)
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- j.Payload["message"].(string)
+		return
+	})
+
+	err = nq.Start(ctx, h)
+	if err != nil {
+		t.Error(err)
+	}
+
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	pg := nq.(*postgres.PgBackend)
+	enqueueTx := func(message string) (jobID string, tx pgx.Tx) {
+		tx, err = conn.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jobID, err = pg.EnqueueTx(ctx, tx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": message}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// duplicate jobs do not abort the caller's transaction
+		_, err = pg.EnqueueTx(ctx, tx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": message}})
+		if !errors.Is(err, postgres.ErrDuplicateJob) {
+			t.Fatalf("expected '%v', got: %v", postgres.ErrDuplicateJob, err)
+		}
+
+		return jobID, tx
+	}
+
+	rolledBackJobID, tx := enqueueTx("rolled back")
+	err = tx.Rollback(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, tx = enqueueTx("committed")
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case message := <-done:
+		if message != "committed" {
+			t.Errorf("expected only the committed job to be processed, but processed: %s", message)
+		}
+	}
+
+	_, err = nq.GetJob(ctx, rolledBackJobID)
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected rolled back job to not be found, got: %v", err)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestTxFromContext tests that handlers' writes with the job's transaction are committed when they succeed, and rolled
+// back when they fail
+// nolint: gocognit, gocyclo
+func TestTxFromContext(t *testing.T) {
+	const queue = "testing"
+	done := make(chan bool, 2)
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS neoq_test_handler_writes (message text)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = conn.Exec(ctx, "DROP TABLE neoq_test_handler_writes") }()
+
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		defer func() { done <- true }()
+
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		tx, err := postgres.TxFromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if !errors.Is(tx.Commit(ctx), postgres.ErrHandlerTxManaged) {
+			return errors.New("handlers should not be able to commit their transactions") // nolint: goerr113
+		}
+
+		message := j.Payload["message"].(string)
+		_, err = tx.Exec(ctx, "INSERT INTO neoq_test_handler_writes(message) VALUES ($1)", message)
+		if err != nil {
+			return
+		}
+
+		if message == "fail" {
+			err = errors.New("something bad happened") // nolint: goerr113
+		}
+
+		return
+	})
+
+	err = nq.Start(ctx, h)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, message := range []string{"succeed", "fail"} {
+		_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": message}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-done:
+		}
+	}
+
+	// allow time for job statuses to be updated
+	time.Sleep(100 * time.Millisecond)
+
+	var messages []string
+	rows, err := conn.Query(ctx, "SELECT message FROM neoq_test_handler_writes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 1 || messages[0] != "succeed" {
+		t.Errorf("expected only the successful handler's write to be committed, got: %v", messages)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestTxFromContextTimeout tests that handlers which keep using their transactions after timing out can't use them
+// concurrently with neoq, and that their writes are rolled back
+func TestTxFromContextTimeout(t *testing.T) {
+	const queue = "testing"
+	stopped := make(chan error, 1)
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS neoq_test_handler_writes (message text)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = conn.Exec(ctx, "DROP TABLE neoq_test_handler_writes") }()
+
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		tx, err := postgres.TxFromContext(ctx)
+		if err != nil {
+			stopped <- err
+			return
+		}
+
+		// the handler ignores its context, so it keeps writing after it times out
+		for {
+			_, err = tx.Exec(context.Background(), "INSERT INTO neoq_test_handler_writes(message) VALUES ('late')")
+			if err != nil && !errors.Is(err, postgres.ErrHandlerTxBusy) {
+				stopped <- err
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}, handler.JobTimeout(100*time.Millisecond), handler.MaxRetries(jobs.NoRetries))
+
+	err = nq.Start(ctx, h)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "time out"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case err = <-stopped:
+		if !errors.Is(err, postgres.ErrHandlerTxFinished) {
+			t.Errorf("expected the handler's transaction to be finished once it timed out, got: %v", err)
+		}
+	}
+
+	// allow time for the job's status to be updated
+	time.Sleep(100 * time.Millisecond)
+
+	var count int
+	err = conn.QueryRow(ctx, "SELECT count(*) FROM neoq_test_handler_writes").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("expected the timed out handler's writes to be rolled back, got %d rows", count)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestCodecs tests that jobs with payloads encoded by codecs other than JSON are stored and decoded by their handlers
+func TestCodecs(t *testing.T) {
+	const queue = "testing"
+	const msgpackQueue = "msgpack"
+	done := make(chan string)
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithCodec(msgpackQueue, codec.Msgpack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	err = nq.Start(ctx, handler.NewTyped(queue, func(_ context.Context, b []byte) (err error) {
+		done <- string(b)
+		return
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = nq.Start(ctx, handler.NewTyped(msgpackQueue, func(_ context.Context, p map[string]any) (err error) {
+		done <- fmt.Sprint(p["message"])
+		return
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawJob, err := jobs.NewEncoded(queue, []byte("hello raw"), codec.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawJobID, err := nq.Enqueue(ctx, rawJob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: msgpackQueue, Payload: map[string]any{"message": "hello msgpack"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := map[string]bool{}
+	for len(received) < 2 {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for jobs, received: %v", received)
+		case p := <-done:
+			received[p] = true
+		}
+	}
+
+	if !received["hello raw"] || !received["hello msgpack"] {
+		t.Errorf("expected payloads 'hello raw' and 'hello msgpack' to be received, got: %v", received)
+	}
+
+	job, err := nq.GetJob(ctx, rawJobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Codec != codec.Raw.Name() || string(job.RawPayload) != "hello raw" {
+		t.Errorf("expected job to record its raw payload and codec, got: %s %q", job.Codec, job.RawPayload)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestMiddleware tests that backend-wide middleware wraps handler middleware, which wraps handlers
+func TestMiddleware(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	calls := make(chan string, 10) // nolint: gomnd
+	record := func(name string) handler.Middleware {
+		return func(next handler.Func) handler.Func {
+			return func(ctx context.Context) error {
+				calls <- name
+				return next(ctx)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithMiddleware(record("backend")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, record("handler")(func(_ context.Context) (err error) { return }),
+		handler.WithMiddleware(record("handler_mw")))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "hello world"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"backend", "handler_mw", "handler"} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for middleware '%s'", expected)
+		case c := <-calls:
+			if c != expected {
+				t.Fatalf("expected middleware '%s' to be called, got: '%s'", expected, c)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestEnqueueInterceptors tests that enqueue interceptors intercept jobs enqueued individually, in bulk, and in
+// transactions
+func TestEnqueueInterceptors(t *testing.T) {
+	const queue = "testing"
+	errInvalidJob := errors.New("invalid job")
+	done := make(chan string, 10) // nolint: gomnd
+
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithEnqueueInterceptor(func(ctx context.Context, job *jobs.Job, next neoq.EnqueueFunc) (string, error) {
+			if _, ok := job.Payload["invalid"]; ok {
+				return "", errInvalidJob
+			}
+
+			job.Payload["tenant"] = "acme"
+			return next(ctx, job)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprint(j.Payload["tenant"])
+		return
+	})
+
+	err = nq.Start(ctx, h)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"invalid": true}})
+	if !errors.Is(err, errInvalidJob) {
+		t.Errorf("expected invalid job to be rejected with '%v', got: %v", errInvalidJob, err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "hello world"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]interface{}{"message": "hello world 1"}},
+		{Queue: queue, Payload: map[string]interface{}{"message": "hello world 2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.(*postgres.PgBackend).EnqueueTx(ctx, tx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": "hello world 3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 4 jobs to be processed, but only %d were", i)
+		case tenant := <-done:
+			if tenant != "acme" {
+				t.Errorf("expected the interceptor to stamp the job's tenant, got: %s", tenant)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestJobHooks tests that lifecycle hooks are called as jobs succeed, and as jobs are skipped for exceeding their
+// deadlines
+func TestJobHooks(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	events := make(chan neoq.JobEventType, 10) // nolint: gomnd
+	hook := func(_ context.Context, e neoq.JobEvent) {
+		events <- e.Type
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithOnJobStarted(hook),
+		neoq.WithOnJobSucceeded(hook),
+		neoq.WithOnJobDead(hook),
+		neoq.WithOnJobSkipped(hook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) { return }, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "hello world"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvents := func(expected ...neoq.JobEventType) {
+		t.Helper()
+		for _, e := range expected {
+			select {
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for event: %v", e)
+			case fired := <-events:
+				if fired != e {
+					t.Fatalf("expected event %v, got: %v", e, fired)
+				}
+			}
+		}
+	}
+
+	expectEvents(neoq.JobStarted, neoq.JobSucceeded)
+
+	deadline := time.Now().Add(-time.Hour)
+	jobID, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:    queue,
+		Payload:  map[string]interface{}{"message": "hello skipped world"},
+		Deadline: &deadline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvents(neoq.JobSkipped, neoq.JobDead)
+
+	job, err := nq.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(job.Error.String, jobs.ErrJobExceededDeadline.Error()) {
+		t.Errorf("expected skipped job's error to be '%v', got: %s", jobs.ErrJobExceededDeadline, job.Error.String)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestTracing tests that handlers' spans are children of the spans that enqueued their jobs, and that fetching jobs
+// and updating their statuses are traced
+func TestTracing(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan trace.SpanContext, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		done <- trace.SpanContextFromContext(ctx)
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	enqueueCtx, parent := tp.Tracer("test").Start(ctx, "request")
+	_, err = nq.Enqueue(enqueueCtx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": "hello world"}})
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case sc := <-done:
+		if sc.TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("expected handler to run in trace %s, got: %s", parent.SpanContext().TraceID(), sc.TraceID())
+		}
+	}
+
+	// the processing span ends once the job's status is committed
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	timeout := time.After(5 * time.Second)
+	for spans["neoq.process"] == nil {
+		select {
+		case <-timeout:
+			t.Fatalf("expected job processing to be traced, got spans: %v", spans)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+	}
+
+	process := spans["neoq.process"].SpanContext().SpanID()
+	if spans["neoq.process"].Parent().SpanID() != spans["neoq.enqueue"].SpanContext().SpanID() {
+		t.Error("expected the process span to be a child of the enqueue span")
+	}
+
+	for _, name := range []string{"neoq.fetch", "neoq.handle", "neoq.update"} {
+		if spans[name] == nil || spans[name].Parent().SpanID() != process {
+			t.Errorf("expected a '%s' span that is a child of the process span", name)
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+type requestIDKey struct{}
+
+// TestMetadata tests that job metadata carries propagated context values to handlers
+func TestMetadata(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithContextPropagator(neoq.ContextValuePropagator("request_id", requestIDKey{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprintf("%v %s %s", ctx.Value(requestIDKey{}), j.Metadata["request_id"], j.Metadata["tenant"])
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(context.WithValue(ctx, requestIDKey{}, "req-1"), &jobs.Job{
+		Queue:    queue,
+		Payload:  map[string]interface{}{"message": "hello world"},
+		Metadata: map[string]string{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case got := <-done:
+		if got != "req-1 req-1 acme" {
+			t.Errorf("expected handler to observe request ID and metadata 'req-1 req-1 acme', got: '%s'", got)
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestMaxRetries tests that jobs' maximum retries, and their handlers' defaults, determine whether failed jobs retry
+func TestMaxRetries(t *testing.T) {
+	const noRetriesQueue = "no_retries"
+	outcomes := make(chan string, 10) // nolint: gomnd
+	recordOutcome := func(outcome string) neoq.JobHook {
+		return func(_ context.Context, event neoq.JobEvent) {
+			outcomes <- fmt.Sprintf("%s %s", event.Job.Payload["name"], outcome)
+		}
+	}
+
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithOnJobRetried(recordOutcome("retried")),
+		neoq.WithOnJobDead(recordOutcome("dead")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	fail := func(_ context.Context) error { return errors.New("something bad happened") }
+	if err = nq.Start(ctx, handler.New(queue, fail)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = nq.Start(ctx, handler.New(noRetriesQueue, fail, handler.MaxRetries(jobs.NoRetries))); err != nil {
+		t.Fatal(err)
+	}
+
+	testJobs := map[string]*jobs.Job{
+		"job_no_retries":     {Queue: queue, MaxRetries: jobs.NoRetries},
+		"job_default":        {Queue: queue},
+		"handler_no_retries": {Queue: noRetriesQueue},
+		"job_overrides":      {Queue: noRetriesQueue, MaxRetries: 1},
+	}
+	expected := map[string]bool{
+		"job_no_retries dead":     true,
+		"job_default retried":     true,
+		"handler_no_retries dead": true,
+		"job_overrides retried":   true,
+	}
+	for name, job := range testJobs {
+		job.Payload = map[string]interface{}{"name": name, "nonce": internal.RandInt(10000000000)}
+		if _, err = nq.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range testJobs {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case outcome := <-outcomes:
+			if !expected[outcome] {
+				t.Errorf("unexpected job outcome: %s", outcome)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestRetryPolicy tests that failed jobs are retried per their own retry policies, which take precedence over their
+// handlers'
+func TestRetryPolicy(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithJobCheckInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan bool, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Retries == 0 {
+			return errors.New("something bad happened")
+		}
+
+		done <- true
+		return
+	}, handler.RetryPolicy(jobs.ConstantBackoff(time.Hour)))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+		Backoff: jobs.ConstantBackoff(time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the failed job to be retried per its retry policy")
+	case <-done:
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestPermanentErrors tests that jobs which fail permanently are not retried, and have their errors recorded
+func TestPermanentErrors(t *testing.T) {
+	errUserDeleted := errors.New("user deleted")
+	dead := make(chan neoq.JobEvent, 1)
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithOnJobDead(func(_ context.Context, event neoq.JobEvent) { dead <- event }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) error {
+		return jobs.Permanent(errUserDeleted)
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the permanently failed job to be dead")
+	case event := <-dead:
+		if !errors.Is(event.Err, errUserDeleted) || !jobs.IsPermanent(event.Err) {
+			t.Errorf("expected the job's error to be permanent and wrap '%v', got: %v", errUserDeleted, event.Err)
+		}
+
+		if event.Attempt != 1 {
+			t.Errorf("expected the job to be attempted once, got: %d", event.Attempt)
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestSnooze tests that handlers can snooze their jobs without failing them or counting retries
+func TestSnooze(t *testing.T) {
+	snoozes := make(chan neoq.JobEvent, 2) // nolint: gomnd
+	failures := make(chan neoq.JobEvent, 1)
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithJobCheckInterval(10*time.Millisecond),
+		neoq.WithOnJobSnoozed(func(_ context.Context, event neoq.JobEvent) { snoozes <- event }),
+		neoq.WithOnJobFailed(func(_ context.Context, event neoq.JobEvent) { failures <- event }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan *jobs.Job, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Snoozes < 2 { // nolint: gomnd
+			return jobs.Snooze(time.Millisecond)
+		}
+
+		done <- j
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the snoozed job to run again")
+	case j := <-done:
+		if j.Retries != 0 || j.Snoozes != 2 {
+			t.Errorf("expected the job to be snoozed twice without retrying, got %d snoozes and %d retries", j.Snoozes,
+				j.Retries)
+		}
+	}
+
+	select {
+	case event := <-failures:
+		t.Errorf("expected snoozed jobs not to fail, got: %v", event.Err)
+	default:
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestPriority tests that jobs with higher priorities are processed first
+func TestPriority(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	// jobs are queued before their handler starts, so that its only worker fetches them in order of priority
+	for _, priority := range []int{-1, 0, 1} {
+		_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Priority: priority, Payload: map[string]any{"priority": priority}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	processed := make(chan int, 3) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		processed <- j.Priority
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{1, 0, -1} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case priority := <-processed:
+			if priority != expected {
+				t.Errorf("expected the job with priority %d to be processed next, got priority %d", expected, priority)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestPriorityAging tests that jobs with low priorities are processed first once they've waited long enough
+func TestPriorityAging(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithPriorityAging(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	// the low-priority job has been due for two minutes, so it has aged past the high-priority job
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Priority: 1, Payload: map[string]any{"priority": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:    queue,
+		Payload:  map[string]any{"priority": 0},
+		RunAfter: time.Now().UTC().Add(-2 * time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processed := make(chan int, 2) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		processed <- j.Priority
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{0, 1} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case priority := <-processed:
+			if priority != expected {
+				t.Errorf("expected the job with priority %d to be processed next, got priority %d", expected, priority)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestStrictPriority tests that jobs are processed strictly by priority when priority aging is disabled
+func TestStrictPriority(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithPriorityAging(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	// the low-priority job has been due for two minutes, but jobs do not age
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Priority: 1, Payload: map[string]any{"priority": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:    queue,
+		Payload:  map[string]any{"priority": 0},
+		RunAfter: time.Now().UTC().Add(-2 * time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processed := make(chan int, 2) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		processed <- j.Priority
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{1, 0} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case priority := <-processed:
+			if priority != expected {
+				t.Errorf("expected the job with priority %d to be processed next, got priority %d", expected, priority)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestResults tests that the results and progress written by handlers can be looked up with their jobs
+func TestResults(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	progressed := make(chan bool)
+	release := make(chan bool)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		if err = jobs.WriteProgress(ctx, 42, "8,400 of 20,000 rows imported"); err != nil {
+			return
+		}
+		progressed <- true
+		<-release
+
+		if err = jobs.WriteProgress(ctx, 100, "20,000 of 20,000 rows imported"); err != nil {
+			return
+		}
+
+		return jobs.WriteResult(ctx, []byte("imported"))
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jobID, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"file": "rows.csv"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-progressed:
+	}
+
+	job, err := nq.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Progress == nil || *job.Progress != (jobs.Progress{Percent: 42, Message: "8,400 of 20,000 rows imported"}) {
+		t.Errorf("expected the job's progress to be reported while it runs, got: %+v", job.Progress)
+	}
+	close(release)
+
+	timeout := time.After(5 * time.Second)
+	for job.Status != internal.JobStatusProcessed {
+		select {
+		case <-timeout:
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		if job, err = nq.GetJob(ctx, jobID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if string(job.Result) != "imported" || job.Progress == nil || job.Progress.Percent != 100 {
+		t.Errorf("expected the job's result and progress to be persisted, got result %q and progress %+v", job.Result,
+			job.Progress)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestResultsFromAnotherProcess tests that the results and progress of jobs can be looked up by other backends while
+// the jobs are being processed
+func TestResultsFromAnotherProcess(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	// the other backend doesn't process jobs, so the job's progress can only be known to it through the database
+	other, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Shutdown(ctx)
+
+	release := make(chan bool)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		if err = jobs.WriteResult(ctx, []byte("partial")); err != nil {
+			return
+		}
+		if err = jobs.WriteProgress(ctx, 42, "8,400 of 20,000 rows imported"); err != nil {
+			return
+		}
+		<-release
+
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+
+	jobID, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"file": "rows.csv"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var job *jobs.Job
+	timeout := time.After(5 * time.Second)
+	for {
+		if job, err = other.GetJob(ctx, jobID); err != nil {
+			t.Fatal(err)
+		}
+
+		if job.Progress != nil && job.Progress.Percent == 42 {
+			if string(job.Result) != "partial" || job.Status == internal.JobStatusProcessed {
+				t.Errorf("expected the running job's result to be 'partial', got result %q and status %s", job.Result,
+					job.Status)
+			}
+			break
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("expected the job's progress to be visible to other backends while it runs, got: %+v", job.Progress)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+func TestDependencies(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	// waiting jobs are released by the jobs that they depend on, rather than every job check interval
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithJobCheckInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	release := make(chan bool)
+	processed := make(chan string, 2)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		name := j.Payload["name"].(string)
+		if name == "parent" {
+			<-release
+		}
+		processed <- name
+		return
+	})
+	h.WithOptions(handler.Concurrency(2))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"name": "orphan"}, DependsOn: []string{"-42"}})
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected jobs that depend on unknown jobs to be rejected, got: %v", err)
+	}
+
+	parentID, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"name": "parent"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	childID, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:     queue,
+		Payload:   map[string]any{"name": "child"},
+		DependsOn: []string{parentID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := nq.GetJob(ctx, childID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.Status != internal.JobStatusWaiting || len(child.DependsOn) != 1 || child.DependsOn[0] != parentID {
+		t.Errorf("expected the child job to wait for its parent, got status %s and dependencies %v", child.Status,
+			child.DependsOn)
+	}
+	close(release)
+
+	for _, expected := range []string{"parent", "child"} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case name := <-processed:
+			if name != expected {
+				t.Errorf("expected %s to be processed, got: %s", expected, name)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+func TestDependencyPolicy(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	tests := []struct {
+		name   string
+		policy jobs.DependencyPolicy
+		status string
+	}{
+		{name: "cancel dependents", policy: jobs.CancelDependents, status: internal.JobStatusCancelled},
+		{name: "fail dependents", policy: jobs.FailDependents, status: internal.JobStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			nq, err := neoq.New(ctx,
+				neoq.WithBackend(postgres.Backend),
+				postgres.WithConnectionString(connString),
+				neoq.WithJobCheckInterval(100*time.Millisecond),
+				neoq.WithDependencyPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nq.Shutdown(ctx)
+
+			h := handler.New(queue, func(ctx context.Context) (err error) {
+				return jobs.Permanent(errors.New("the parent job failed"))
+			})
+			if err = nq.Start(ctx, h); err != nil {
+				t.Fatal(err)
+			}
+
+			parentID, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"name": "parent"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			childID, err := nq.Enqueue(ctx, &jobs.Job{
+				Queue:     queue,
+				Payload:   map[string]any{"name": "child"},
+				DependsOn: []string{parentID},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			timeout := time.After(5 * time.Second)
+			for {
+				job, err := nq.GetJob(ctx, childID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if job.Status == tt.status {
+					if job.Error.String != jobs.ErrDependencyFailed.Error() {
+						t.Errorf("expected the child job to record why it did not run, got: %s", job.Error.String)
+					}
+					break
+				}
+
+				select {
+				case <-timeout:
+					t.Fatalf("expected the child job to be %s, got status: %s", tt.status, job.Status)
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+
+			t.Cleanup(func() {
+				flushDB()
+			})
+		})
+	}
+}
+
+func TestBatches(t *testing.T) {
+	const queue = "testing"
+	const callbackQueue = "batch_callbacks"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	// batches are completed by their last jobs, rather than every job check interval
+	ctx := context.Background()
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(connString),
+		neoq.WithJobCheckInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Payload["fail"] == true {
+			return jobs.Permanent(errors.New("customer not found"))
+		}
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries := make(chan *jobs.BatchStatus, 1)
+	callbackHandler := handler.New(callbackQueue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		summary, err := jobs.BatchFromCallback(j)
+		if err != nil {
+			return
+		}
+		summaries <- summary
+		return
+	})
+	if err = nq.Start(ctx, callbackHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	batchID, err := nq.EnqueueBatch(ctx, &jobs.Batch{
+		Jobs: []*jobs.Job{
+			{Queue: queue, Payload: map[string]any{"customer": 1}},
+			{Queue: queue, Payload: map[string]any{"customer": 2}},
+			{Queue: queue, Payload: map[string]any{"customer": 2}},
+			{Queue: queue, Payload: map[string]any{"customer": 3, "fail": true}},
+		},
+		Callback: &jobs.Job{Queue: callbackQueue, Payload: map[string]any{"report": "customers"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary *jobs.BatchStatus
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case summary = <-summaries:
+	}
+
+	if summary.ID != batchID || summary.Total != 3 || summary.Succeeded != 2 || summary.Dead != 1 || !summary.Done() {
+		t.Errorf("expected the callback to summarize the batch's three jobs, got: %+v", summary)
+	}
+
+	status, err := nq.GetBatch(ctx, batchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Pending() != 0 || !status.Done() || status.CallbackJobID == "" {
+		t.Errorf("expected the batch to be complete with its callback queued, got: %+v", status)
+	}
+
+	// batches without jobs are completed when they're enqueued
+	batchID, err = nq.EnqueueBatch(ctx, &jobs.Batch{
+		Callback: &jobs.Job{Queue: callbackQueue, Payload: map[string]any{"report": "nobody"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case summary = <-summaries:
+	}
+
+	if summary.ID != batchID || summary.Total != 0 || !summary.Done() {
+		t.Errorf("expected the callback to summarize the empty batch, got: %+v", summary)
+	}
+
+	_, err = nq.GetBatch(ctx, "-42")
+	if !errors.Is(err, jobs.ErrBatchNotFound) {
+		t.Errorf("expected unknown batches not to be found, got: %v", err)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestRateLimit tests that rate-limited queues process jobs no faster than their limit, without consuming retries
+func TestRateLimit(t *testing.T) {
+	const queue = "testing"
+	const numJobs = 4
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan *jobs.Job, numJobs)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		done <- j
+		return
+	}, handler.Concurrency(numJobs), handler.RateLimit(2, 400*time.Millisecond)) // nolint: gomnd
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < numJobs; i++ {
+		_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"i": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < numJobs; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected every rate-limited job to be processed")
+		case j := <-done:
+			if j.Retries != 0 {
+				t.Errorf("expected rate-limited jobs not to consume retries, got %d retries", j.Retries)
+			}
+		}
+	}
+
+	// the first two jobs use the bucket's two tokens, and the next two wait 200ms each for theirs
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("expected %d jobs to take at least 400ms at 2 jobs per 400ms, took %s", numJobs, elapsed)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestRateLimitRefund tests that rate limit tokens are not spent when workers are notified of jobs that they can't lock
+func TestRateLimitRefund(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan bool, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		done <- true
+		return
+	}, handler.RateLimit(1, time.Hour))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	// notifications of jobs that don't exist would each spend the bucket's only token if they weren't refunded
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	for i := 0; i < 3; i++ {
+		if _, err = conn.Exec(ctx, fmt.Sprintf("NOTIFY %s, '%d'", queue, i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"refunded": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the job to be processed with a refunded token")
+	case <-done:
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestRateLimitKey tests that jobs limited by key are limited separately, and that jobs whose key has no tokens don't
+// hold up jobs with other keys
+func TestRateLimitKey(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 3) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		done <- j.Payload["customer"].(string)
+		return
+	}, handler.RateLimit(1, time.Hour), handler.RateLimitKey(func(j *jobs.Job) string {
+		customer, _ := j.Payload["customer"].(string)
+		return customer
+	}))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, customer := range []string{"a", "a", "b"} {
+		_, err = nq.Enqueue(ctx, &jobs.Job{
+			Queue:   queue,
+			Payload: map[string]interface{}{"customer": customer, "nonce": internal.RandInt(10000000000)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	processed := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected one job per customer to be processed, got: %v", processed)
+		case customer := <-done:
+			processed[customer]++
+		}
+	}
+
+	select {
+	case customer := <-done:
+		t.Errorf("expected customer %s's second job to wait for its rate limit", customer)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if processed["a"] != 1 || processed["b"] != 1 {
+		t.Errorf("expected one job per customer to be processed, got: %v", processed)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestGlobalConcurrency tests that queues process no more jobs at once than their global concurrency, across every
+// process handling them
+func TestGlobalConcurrency(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	started := make(chan bool, 3) // nolint: gomnd
+	release := make(chan bool)
+	var nq neoq.Neoq
+
+	// two backends stand in for two processes handling the same queue
+	for i := 0; i < 2; i++ {
+		backend, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer backend.Shutdown(ctx)
+
+		h := handler.New(queue, func(_ context.Context) (err error) {
+			started <- true
+			<-release
+			return
+		}, handler.Concurrency(2), handler.GlobalConcurrency(2)) // nolint: gomnd
+		if err = backend.Start(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+		nq = backend
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"i": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-started:
+		}
+	}
+
+	select {
+	case <-started:
+		t.Error("expected the third job to wait for one of the first two to finish")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	stats, err := nq.QueueStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].GlobalConcurrency != 2 || stats[0].GlobalRunning != 2 {
+		t.Errorf("expected the queue to report 2 of 2 globally running jobs, got: %+v", stats)
+	}
+	close(release)
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the third job to run once the first two finished")
+	case <-started:
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestUniqueScopes tests that jobs duplicate jobs that are being processed unless they're unique while queued, and that
+// jobs that are not unique are never duplicates
+func TestUniqueScopes(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	started := make(chan bool, 3) // nolint: gomnd
+	release := make(chan bool)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		started <- true
+		<-release
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+
+	payload := map[string]interface{}{"message": "hello world"}
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-started:
+	}
+
+	tests := []struct {
+		name      string
+		unique    *jobs.Unique
+		duplicate bool
+	}{
+		{name: "unique while active", unique: nil, duplicate: true},
+		{name: "unique while queued", unique: jobs.UniqueWhile(jobs.UniqueWhileQueued), duplicate: false},
+		{name: "unique while queued, with a queued job", unique: jobs.UniqueWhile(jobs.UniqueWhileQueued), duplicate: true},
+		{name: "not unique", unique: jobs.UniqueWhile(jobs.NotUnique), duplicate: false},
+	}
+	for _, tt := range tests {
+		_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: tt.unique})
+		duplicate := errors.Is(err, postgres.ErrDuplicateJob)
+		if err != nil && !duplicate {
+			t.Fatal(err)
+		}
+
+		if duplicate != tt.duplicate {
+			t.Errorf("%s: expected duplicate to be %t, got %t", tt.name, tt.duplicate, duplicate)
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestUniqueWindow tests that jobs which are unique within a window duplicate jobs that were processed within it
+func TestUniqueWindow(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]interface{}{"message": "hello world"}
+	jid, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: jobs.UniqueWithin(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		job, err := nq.GetJob(ctx, jid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job.Status == internal.JobStatusProcessed {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the job to be processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: jobs.UniqueWithin(time.Hour)})
+	if !errors.Is(err, postgres.ErrDuplicateJob) {
+		t.Errorf("expected a job that's unique within an hour to duplicate a job processed within the hour, got: %v", err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload})
+	if err != nil {
+		t.Errorf("expected a job that's unique while active not to duplicate a processed job, got: %v", err)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestUniqueKeys tests that jobs with unique keys, or whose handlers have unique keys, are fingerprinted by the payload
+// fields named by their keys
+func TestUniqueKeys(t *testing.T) {
+	const queue = "testing"
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		return
+	}, handler.Unique(jobs.UniqueWhile(jobs.UniqueWhileActive, "customer_id")))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	runAfter := time.Now().Add(time.Hour)
+	jobIDs, err := nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]interface{}{"customer_id": 1, "document_id": 1}, RunAfter: runAfter},
+		{Queue: queue, Payload: map[string]interface{}{"customer_id": 1, "document_id": 2}, RunAfter: runAfter},
+		{Queue: queue, Payload: map[string]interface{}{"customer_id": 2, "document_id": 1}, RunAfter: runAfter},
+		{
+			Queue:    queue,
+			Payload:  map[string]interface{}{"customer_id": 3, "document_id": 3},
+			Unique:   jobs.UniqueWhile(jobs.UniqueWhileActive, "document_id"),
+			RunAfter: runAfter,
+		},
+		{
+			Queue:    queue,
+			Payload:  map[string]interface{}{"customer_id": 4, "document_id": 3},
+			Unique:   jobs.UniqueWhile(jobs.UniqueWhileActive, "document_id"),
+			RunAfter: runAfter,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []bool{false, true, false, false, true}
+	for i, jobID := range jobIDs {
+		if duplicate := jobID == jobs.DuplicateJobID; duplicate != expected[i] {
+			t.Errorf("job %d: expected duplicate to be %t, got %t", i, expected[i], duplicate)
+		}
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestDebounce tests that debounced jobs replace the payloads of pending jobs with the same key, and push back their
+// run_after, so that only the last job's payload is processed
+func TestDebounce(t *testing.T) {
+	const queue = "testing"
+	const window = 500 * time.Millisecond
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 10)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprint(j.Payload["message"])
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jid, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:       queue,
+		Payload:     map[string]interface{}{"message": "first"},
+		EnqueueMode: jobs.Debounce("doc:1", window),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jids, err := nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]interface{}{"message": "second"}, EnqueueMode: jobs.Debounce("doc:1", window)},
+		{Queue: queue, Payload: map[string]interface{}{"message": "last"}, EnqueueMode: jobs.Debounce("doc:1", window)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueuedAt := time.Now()
+
+	for _, id := range jids {
+		if id != jid {
+			t.Errorf("expected debounced jobs to be enqueued as the pending job %s, got: %s", jid, id)
+		}
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case message := <-done:
+		if message != "last" {
+			t.Errorf("expected the last debounced payload to be processed, got: %s", message)
+		}
+		if time.Since(enqueuedAt) < window {
+			t.Error("expected the debounced job to be pushed back by the last enqueue")
+		}
+	}
+
+	select {
+	case message := <-done:
+		t.Errorf("expected debounced jobs to be processed once, got another job: %s", message)
+	case <-time.After(2 * window):
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
+
+// TestThrottle tests that throttled jobs are dropped while pending jobs have the same key
+func TestThrottle(t *testing.T) {
+	const queue = "testing"
+	const window = 500 * time.Millisecond
+	connString := os.Getenv("TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_DATABASE_URL not set")
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(postgres.Backend), postgres.WithConnectionString(connString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 10)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprint(j.Payload["message"])
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:       queue,
+		Payload:     map[string]interface{}{"message": "first"},
+		EnqueueMode: jobs.Throttle("doc:1", window),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:       queue,
+		Payload:     map[string]interface{}{"message": "second"},
+		EnqueueMode: jobs.Throttle("doc:1", window),
+	})
+	if !errors.Is(err, postgres.ErrDuplicateJob) {
+		t.Errorf("expected a throttled job to be dropped while a job with its key is pending, got: %v", err)
+	}
+
+	jids, err := nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]interface{}{"message": "third"}, EnqueueMode: jobs.Throttle("doc:1", window)},
+		{Queue: queue, Payload: map[string]interface{}{"message": "other"}, EnqueueMode: jobs.Throttle("doc:2", window)},
+		{Queue: queue, Payload: map[string]interface{}{"message": "another"}, EnqueueMode: jobs.Throttle("doc:2", window)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jids[0] != jobs.DuplicateJobID || jids[2] != jobs.DuplicateJobID {
+		t.Error("expected throttled jobs to be dropped while jobs with their keys are pending")
+	}
+	if jids[1] == jobs.DuplicateJobID {
+		t.Error("expected a throttled job with a different key to be enqueued")
+	}
+
+	processed := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case message := <-done:
+			processed[message] = true
+		}
+	}
+	if !processed["first"] || !processed["other"] {
+		t.Errorf("expected the first job with each key to be processed, got: %v", processed)
+	}
+
+	t.Cleanup(func() {
+		flushDB()
+	})
+}
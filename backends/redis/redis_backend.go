@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,18 +19,103 @@ import (
 	"github.com/acaloiaro/neoq/internal"
 	"github.com/acaloiaro/neoq/jobs"
 	"github.com/acaloiaro/neoq/logging"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/guregu/null"
 	"github.com/hibiken/asynq"
 	"github.com/iancoleman/strcase"
 	"github.com/jsuar/go-cron-descriptor/pkg/crondescriptor"
 	"golang.org/x/exp/slog"
 )
 
-// All jobs are placed on the same 'default' queue (until a compelling case is made for using different asynq queues
-// for every job)
-const defaultAsynqQueue = "default"
+// Jobs are placed on one of three asynq queues by their priorities. Jobs with priority 0 are placed on the 'default'
+// queue, and jobs with positive and negative priorities on the 'high' and 'low' queues respectively.
+const (
+	defaultAsynqQueue      = "default"
+	highPriorityAsynqQueue = "high"
+	lowPriorityAsynqQueue  = "low"
+)
+
+// asynqQueues are the asynq queues that jobs are placed on, from highest to lowest priority
+var asynqQueues = []string{highPriorityAsynqQueue, defaultAsynqQueue, lowPriorityAsynqQueue}
+
+// asynqQueueWeights are the relative frequencies with which asynq processes tasks from each of asynqQueues. Queues are
+// not processed in strict priority order, so that low-priority jobs are not starved by high-priority jobs.
+var asynqQueueWeights = map[string]int{
+	highPriorityAsynqQueue: 6,
+	defaultAsynqQueue:      3,
+	lowPriorityAsynqQueue:  1,
+}
+
+// rateLimitKeyPrefix prefixes the keys of rate limit buckets
+const rateLimitKeyPrefix = "neoq:rate_limit:"
+
+// rateLimitScript takes a token from the rate limit bucket at KEYS[1], which holds up to ARGV[1] tokens and gains one
+// every ARGV[2] microseconds, returning the number of microseconds to wait for a token when the bucket is empty. When
+// ARGV[3] is 1, tokens are reserved from empty buckets, leaving them in deficit until they refill. Otherwise, no token
+// is taken from empty buckets. Buckets expire once they're full, since full buckets are the same as missing buckets.
+var rateLimitScript = goredis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local tokens = capacity
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated_at")
+if bucket[1] and bucket[2] then
+	tokens = math.min(capacity, tonumber(bucket[1]) + (now - tonumber(bucket[2])) / interval)
+end
+local wait = 0
+if tokens < 1 then
+	wait = (1 - tokens) * interval
+end
+if tokens >= 1 or ARGV[3] == "1" then
+	tokens = tokens - 1
+end
+redis.call("HSET", KEYS[1], "tokens", string.format("%.17g", tokens), "updated_at", string.format("%.0f", now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) * interval / 1000) + 1)
+return string.format("%.0f", wait)`)
 
-// ErrInvalidAddr indicates that the provided address is not a valid redis connection string
-var ErrInvalidAddr = errors.New("invalid connecton string: see documentation for valid connection strings")
+// uniqueKeyPrefix prefixes the keys that jobs which are unique while queued hold until they're processed, and
+// activeUniqueKeyPrefix the keys that other unique jobs hold until they're processed or die. Keys expire uniqueKeyTTL
+// after jobs are due, in case their tasks are deleted before they're processed.
+const (
+	uniqueKeyPrefix       = "neoq:unique:"
+	activeUniqueKeyPrefix = "neoq:unique_active:"
+	uniqueKeyTTL          = 24 * time.Hour
+)
+
+// releaseUniqueKeyScript deletes the unique key at KEYS[1] if it's held by the task with ID ARGV[1]
+var releaseUniqueKeyScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// expireUniqueKeyScript expires the unique key at KEYS[1] in ARGV[2] milliseconds if it's held by the task with ID
+// ARGV[1]
+var expireUniqueKeyScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// taskPayloadVersion distinguishes task payloads that are taskPayloads from those enqueued by earlier versions of neoq,
+// which are jobs' JSON payloads
+const taskPayloadVersion = 1
+
+var (
+	// ErrInvalidAddr indicates that the provided address is not a valid redis connection string
+	ErrInvalidAddr = errors.New("invalid connecton string: see documentation for valid connection strings")
+	// ErrDependenciesNotSupported indicates that jobs depend on other jobs, which the Redis backend does not support
+	ErrDependenciesNotSupported = errors.New("the redis backend does not support job dependencies")
+	// ErrBatchesNotSupported indicates that jobs were enqueued in a batch, which the Redis backend does not support
+	ErrBatchesNotSupported = errors.New("the redis backend does not support batches")
+	// ErrGlobalConcurrencyNotSupported indicates that a handler has a global concurrency, which the Redis backend does not
+	// support
+	ErrGlobalConcurrencyNotSupported = errors.New("the redis backend does not support global concurrency")
+	// ErrEnqueueModesNotSupported indicates that jobs have enqueue modes, which the Redis backend does not support
+	ErrEnqueueModesNotSupported = errors.New("the redis backend does not support enqueue modes")
+)
 
 // RedisBackend is a Redis-backed neoq backend
 // nolint: revive
@@ -43,6 +130,33 @@ type RedisBackend struct {
 	mu           *sync.Mutex // mutext to protect mutating backend state
 	taskProvider *memoryTaskConfigProvider
 	mgr          *asynq.PeriodicTaskManager
+	handlers     map[string]handler.Handler // the handlers of every queue
+	rdb          goredis.UniversalClient    // takes tokens for rate-limited queues, and holds unique keys
+}
+
+// taskPayload is the payload of asynq tasks, carrying jobs' payloads along with the codec that encoded them, the
+// trace context that they were enqueued with, and their metadata
+type taskPayload struct {
+	Version      int               `json:"neoq_version"`
+	Payload      map[string]any    `json:"payload,omitempty"`
+	RawPayload   []byte            `json:"raw_payload,omitempty"`
+	Codec        string            `json:"codec,omitempty"`
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	MaxRetries   int               `json:"max_retries,omitempty"`
+	Backoff      *jobs.Backoff     `json:"backoff,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	Fingerprint  string            `json:"fingerprint,omitempty"`
+	UniqueKey    string            `json:"unique_key,omitempty"` // the key held by unique jobs
+	// The uniqueness of jobs that hold unique keys. Jobs that hold keys without it were enqueued by earlier versions of
+	// neoq, when only jobs that are unique while queued held keys.
+	Unique *jobs.Unique `json:"unique,omitempty"`
+}
+
+// taskResult is the result of asynq tasks, carrying the results and progress written by jobs' handlers
+type taskResult struct {
+	Result   []byte         `json:"result,omitempty"`
+	Progress *jobs.Progress `json:"progress,omitempty"`
 }
 
 type memoryTaskConfigProvider struct {
@@ -75,11 +189,30 @@ func (m *memoryTaskConfigProvider) addConfig(taskConfig *asynq.PeriodicTaskConfi
 }
 
 // Backend is a [neoq.BackendInitializer] that initializes a new Redis-backed neoq backend
+//
+// Job priorities are coarse with Redis: jobs with positive priorities are processed about twice as often as jobs with
+// priority 0, and six times as often as jobs with negative priorities. Jobs are not aged, see [neoq.WithPriorityAging].
+//
+// Jobs may not depend on other jobs with Redis. Jobs with [jobs.Job.DependsOn] are rejected with
+// [ErrDependenciesNotSupported]. Likewise, batches are rejected with [ErrBatchesNotSupported].
+//
+// Jobs whose rate limit key has no tokens are snoozed, see [handler.RateLimitKey]. Handlers with a global concurrency
+// are rejected with [ErrGlobalConcurrencyNotSupported].
+//
+// Jobs may not be debounced or throttled with Redis. Jobs with [jobs.Job.EnqueueMode] are rejected with
+// [ErrEnqueueModesNotSupported].
+//
+// asynq's Unique option is not used, since it deduplicates tasks by their entire payloads, including jobs' metadata.
+// Instead, unique jobs hold keys named after their fingerprints: jobs that are unique while queued until they're
+// processed, and other unique jobs until they're processed or die, or for their window after they're processed if
+// they're unique within a window. Jobs that are unique while queued only duplicate each other. Keys are independent of
+// how long completed jobs are retained, see [WithRetention].
 func Backend(_ context.Context, opts ...neoq.ConfigOption) (backend neoq.Neoq, err error) {
 	b := &RedisBackend{
 		config:       neoq.NewConfig(),
 		mu:           &sync.Mutex{},
 		taskProvider: newMemoryTaskConfigProvider(),
+		handlers:     map[string]handler.Handler{},
 	}
 
 	for _, opt := range opts {
@@ -102,11 +235,19 @@ func Backend(_ context.Context, opts ...neoq.ConfigOption) (backend neoq.Neoq, e
 	}
 	b.inspector = asynq.NewInspector(clientOpt)
 	b.client = asynq.NewClient(clientOpt)
+	b.rdb = clientOpt.MakeRedisClient().(goredis.UniversalClient)
 	b.server = asynq.NewServer(
 		clientOpt,
 		asynq.Config{
 			Concurrency:     b.config.BackendConcurrency,
+			Queues:          asynqQueueWeights,
 			ShutdownTimeout: b.config.ShutdownTimeout,
+			RetryDelayFunc:  b.retryDelay,
+			// snoozed tasks are retried without counting as failures
+			IsFailure: func(err error) bool {
+				_, snoozed := jobs.Snoozed(err)
+				return !snoozed
+			},
 		},
 	)
 
@@ -136,11 +277,10 @@ func Backend(_ context.Context, opts ...neoq.ConfigOption) (backend neoq.Neoq, e
 		}
 	}()
 
-	go func() {
-		if err := b.server.Run(b.mux); err != nil {
-			log.Fatal(err)
-		}
-	}()
+	// the server is started before returning, so that backends that are shut down right away don't leave it running
+	if err = b.server.Start(b.mux); err != nil {
+		return nil, fmt.Errorf("unable to start asynq server: %w", err)
+	}
 
 	backend = b
 
@@ -168,6 +308,15 @@ func WithConcurrency(concurrency int) neoq.ConfigOption {
 	}
 }
 
+// WithRetention configures the time that completed jobs are retained for, so that their results can be looked up with
+// GetJob. By default, asynq deletes completed tasks as soon as they complete. Retained jobs are not duplicated, unless
+// they're unique within a window that hasn't passed.
+//
+// WithRetention is equivalent to [neoq.WithCompletedJobRetention].
+func WithRetention(retention time.Duration) neoq.ConfigOption {
+	return neoq.WithCompletedJobRetention(retention)
+}
+
 // WithShutdownTimeout specifies the duration to wait to let workers finish their tasks
 // before forcing them to abort durning Shutdown()
 //
@@ -180,63 +329,616 @@ func WithShutdownTimeout(timeout time.Duration) neoq.ConfigOption {
 
 // Enqueue queues jobs to be executed asynchronously
 func (b *RedisBackend) Enqueue(ctx context.Context, job *jobs.Job) (jobID string, err error) {
+	return b.config.InterceptEnqueue(ctx, job, b.enqueue)
+}
+
+// enqueue queues jobs that have passed through the configured enqueue interceptors
+func (b *RedisBackend) enqueue(ctx context.Context, job *jobs.Job) (jobID string, err error) {
 	if job.Queue == "" {
 		err = jobs.ErrNoQueueSpecified
 		return
 	}
 
+	if len(job.DependsOn) > 0 {
+		err = ErrDependenciesNotSupported
+		return
+	}
+
+	if job.EnqueueMode != nil {
+		err = ErrEnqueueModesNotSupported
+		return
+	}
+
+	err = jobs.EncodePayload(job, b.config.Codec(job.Queue))
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	if h, ok := b.handlers[job.Queue]; ok {
+		job.Unique = h.JobUnique(job)
+	}
+	b.mu.Unlock()
+
 	err = jobs.FingerprintJob(job)
 	if err != nil {
 		return
 	}
 
+	taskID := uuid.NewString()
+	uniqueKey := jobUniqueKey(job)
+	if uniqueKey != "" {
+		err = b.acquireUniqueKey(ctx, uniqueKey, taskID, job.RunAfter)
+		if err != nil {
+			return
+		}
+	}
+
 	var payload []byte
-	payload, err = json.Marshal(job.Payload)
+	payload, err = jobToTaskPayload(job, uniqueKey)
 	if err != nil {
 		return
 	}
 	task := asynq.NewTask(job.Queue, payload)
-	_, err = b.client.EnqueueContext(ctx, task, jobToTaskOptions(job)...)
+	_, err = b.client.EnqueueContext(ctx, task, b.jobToTaskOptions(job, taskID)...)
 	if err != nil {
 		err = fmt.Errorf("unable to enqueue task: %w", err)
+		if uniqueKey != "" {
+			b.releaseUniqueKey(ctx, uniqueKey, taskID)
+		}
+		return
+	}
+
+	return taskID, nil
+}
+
+// acquireUniqueKey acquires a unique key for the task with ID taskID, returning [asynq.ErrDuplicateTask] when another
+// task holds it
+func (b *RedisBackend) acquireUniqueKey(ctx context.Context, key, taskID string, runAfter time.Time) (err error) {
+	ttl := uniqueKeyTTL
+	if d := time.Until(runAfter); d > 0 {
+		ttl += d
+	}
+
+	acquired, err := b.rdb.SetNX(ctx, key, taskID, ttl).Result()
+	if err != nil {
+		return fmt.Errorf("unable to acquire unique key: %w", err)
+	}
+
+	if !acquired {
+		return fmt.Errorf("unable to enqueue task: %w", asynq.ErrDuplicateTask)
+	}
+
+	return
+}
+
+// jobUniqueKey is the unique key that a job holds, if it's unique
+func jobUniqueKey(job *jobs.Job) string {
+	switch job.UniqueScope() {
+	case jobs.NotUnique:
+		return ""
+	case jobs.UniqueWhileQueued:
+		return uniqueKeyPrefix + job.Fingerprint
+	default:
+		return activeUniqueKeyPrefix + job.Fingerprint
+	}
+}
+
+// completeUniqueKey updates the unique key held by a task once its handler returns err
+//
+// Jobs that are unique while queued release their keys when they're processed, and hold them again when they're
+// snoozed or retried. Other jobs hold their keys until they're processed or die, and jobs that are unique within a
+// window hold them for their window after they're processed.
+func (b *RedisBackend) completeUniqueKey(ctx context.Context, key, taskID string, unique *jobs.Unique, err error) {
+	queued := unique.Scope == jobs.UniqueWhileQueued
+	switch {
+	case err != nil && !errors.Is(err, asynq.SkipRetry):
+		if queued {
+			b.requeueUniqueKey(ctx, key, taskID)
+		}
+	case err == nil && unique.Scope == jobs.UniqueWithinWindow && unique.Window > 0:
+		if rerr := expireUniqueKeyScript.Run(ctx, b.rdb, []string{key}, taskID, unique.Window.Milliseconds()).Err(); rerr != nil {
+			b.logger.Error("unable to expire unique key", "task_id", taskID, "error", rerr)
+		}
+	case !queued:
+		b.releaseUniqueKey(ctx, key, taskID)
+	}
+}
+
+// requeueUniqueKey acquires a unique key again for a task that's been queued again, unless another task holds it
+func (b *RedisBackend) requeueUniqueKey(ctx context.Context, key, taskID string) {
+	err := b.acquireUniqueKey(ctx, key, taskID, time.Time{})
+	if err != nil && !errors.Is(err, asynq.ErrDuplicateTask) {
+		b.logger.Error("unable to acquire unique key", "task_id", taskID, "error", err)
+	}
+}
+
+// releaseUniqueKey releases a unique key held by the task with ID taskID, if it still holds it
+func (b *RedisBackend) releaseUniqueKey(ctx context.Context, key, taskID string) {
+	err := releaseUniqueKeyScript.Run(ctx, b.rdb, []string{key}, taskID).Err()
+	if err != nil {
+		b.logger.Error("unable to release unique key", "task_id", taskID, "error", err)
+	}
+}
+
+// EnqueueMany queues many jobs to be executed asynchronously
+//
+// asynq v0.24's client enqueues every task with a script of its own, and offers no way to enqueue tasks in batches or
+// to pipeline them, so each job is enqueued individually, with a round trip of its own. Jobs that conflict with an
+// existing task have the job ID [jobs.DuplicateJobID].
+//
+// Enqueueing stops at the first job that fails to be enqueued. Jobs that were enqueued before it remain enqueued, so
+// jobIDs always has an entry for every job: the IDs of the jobs that were enqueued, and empty IDs for the job that
+// failed and the jobs after it, which were not enqueued.
+func (b *RedisBackend) EnqueueMany(ctx context.Context, js []*jobs.Job) (jobIDs []string, err error) {
+	return b.config.InterceptEnqueueMany(ctx, js, b.enqueueMany)
+}
+
+// enqueueMany queues many jobs that have passed through the configured enqueue interceptors
+func (b *RedisBackend) enqueueMany(ctx context.Context, js []*jobs.Job) (jobIDs []string, err error) {
+	for _, job := range js {
+		if job.Queue == "" {
+			err = jobs.ErrNoQueueSpecified
+			return
+		}
+
+		if len(job.DependsOn) > 0 {
+			err = ErrDependenciesNotSupported
+			return
+		}
+
+		if job.EnqueueMode != nil {
+			err = ErrEnqueueModesNotSupported
+			return
+		}
+	}
+
+	jobIDs = make([]string, len(js))
+	for i, job := range js {
+		var jobID string
+		jobID, err = b.enqueue(ctx, job)
+		if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+			jobIDs[i] = jobs.DuplicateJobID
+			err = nil
+			continue
+		}
+
+		if err != nil {
+			return
+		}
+
+		jobIDs[i] = jobID
+	}
+
+	return
+}
+
+// EnqueueBatch is not supported by the Redis backend, and returns [ErrBatchesNotSupported]
+func (b *RedisBackend) EnqueueBatch(_ context.Context, _ *jobs.Batch) (batchID string, err error) {
+	return "", ErrBatchesNotSupported
+}
+
+// GetBatch is not supported by the Redis backend, and returns [ErrBatchesNotSupported]
+func (b *RedisBackend) GetBatch(_ context.Context, _ string) (status *jobs.BatchStatus, err error) {
+	return nil, ErrBatchesNotSupported
+}
+
+// GetJob retrieves a job by ID
+//
+// Completed tasks are only retained by asynq for their retention period, after which they are no longer found
+func (b *RedisBackend) GetJob(_ context.Context, jobID string) (job *jobs.Job, err error) {
+	ti, err := b.taskInfo(jobID)
+	if err != nil {
+		if errors.Is(err, asynq.ErrTaskNotFound) {
+			err = fmt.Errorf("%w: %s", jobs.ErrJobNotFound, jobID)
+			return
+		}
+
+		err = fmt.Errorf("unable to fetch task info: %w", err)
+		return
+	}
+
+	job = taskInfoToJob(ti)
+
+	return
+}
+
+// ListDeadJobs lists asynq's archived tasks, most recently failed first
+//
+// Listing dead jobs requires scanning all archived tasks, because every neoq queue shares the same asynq queues
+func (b *RedisBackend) ListDeadJobs(_ context.Context, opts ...neoq.ListOption) (deadJobs []*jobs.Job, err error) {
+	o := neoq.NewListOptions(opts...)
+	deadJobs = []*jobs.Job{}
+
+	tasks, err := b.archivedTasks(o.Queue)
+	if err != nil {
+		err = fmt.Errorf("unable to list archived tasks: %w", err)
+		return
+	}
+
+	if o.Offset() >= len(tasks) {
+		tasks = nil
+	} else {
+		end := o.Offset() + o.PageSize
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		tasks = tasks[o.Offset():end]
+	}
+
+	for _, ti := range tasks {
+		deadJobs = append(deadJobs, taskInfoToJob(ti))
+	}
+
+	return
+}
+
+// RequeueDeadJobs re-enqueues archived tasks with their retries reset
+func (b *RedisBackend) RequeueDeadJobs(ctx context.Context, jobIDs ...string) (count int, err error) {
+	for _, jobID := range jobIDs {
+		var ti *asynq.TaskInfo
+		var found bool
+		ti, found, err = b.archivedTask(jobID)
+		if err != nil {
+			return
+		}
+
+		if !found {
+			continue
+		}
+
+		// unique jobs hold their keys again once they're requeued, unless they've been taken
+		key, _ := taskUnique(ti.Payload)
+		if key != "" {
+			err = b.acquireUniqueKey(ctx, key, jobID, time.Time{})
+			if errors.Is(err, asynq.ErrDuplicateTask) {
+				b.logger.Debug("dead job duplicates a queued job, not requeueing", "task_id", jobID)
+				err = nil
+				continue
+			}
+
+			if err != nil {
+				return
+			}
+		}
+
+		err = b.requeueArchivedTask(ctx, ti)
+		if err != nil {
+			if key != "" {
+				b.releaseUniqueKey(ctx, key, jobID)
+			}
+			return
+		}
+
+		count++
+	}
+
+	return
+}
+
+// requeueArchivedTask replaces an archived task with a new task with the same ID and payload
+//
+// asynq does not reset the retry count of archived tasks that are run again, so they're replaced rather than run again
+func (b *RedisBackend) requeueArchivedTask(ctx context.Context, ti *asynq.TaskInfo) (err error) {
+	err = b.inspector.DeleteTask(ti.Queue, ti.ID)
+	if err != nil {
+		return fmt.Errorf("unable to requeue archived task: %w", err)
+	}
+
+	job := taskInfoToJob(ti)
+	job.RunAfter = time.Time{}
+	_, err = b.client.EnqueueContext(ctx, asynq.NewTask(ti.Type, ti.Payload), b.jobToTaskOptions(job, ti.ID)...)
+	if err != nil {
+		return fmt.Errorf("unable to requeue archived task: %w", err)
 	}
 
-	return job.Fingerprint, err
+	return
+}
+
+// DeleteDeadJobs deletes archived tasks
+func (b *RedisBackend) DeleteDeadJobs(_ context.Context, jobIDs ...string) (count int, err error) {
+	for _, jobID := range jobIDs {
+		var ti *asynq.TaskInfo
+		var found bool
+		ti, found, err = b.archivedTask(jobID)
+		if err != nil {
+			return
+		}
+
+		if !found {
+			continue
+		}
+
+		err = b.inspector.DeleteTask(ti.Queue, jobID)
+		if err != nil {
+			err = fmt.Errorf("unable to delete archived task: %w", err)
+			return
+		}
+
+		count++
+	}
+
+	return
+}
+
+// PurgeDeadJobs deletes all archived tasks for a queue, or all archived tasks when queue is empty
+func (b *RedisBackend) PurgeDeadJobs(_ context.Context, queue string) (count int, err error) {
+	if queue == "" {
+		var existing []string
+		existing, err = b.inspector.Queues()
+		if err != nil {
+			err = fmt.Errorf("unable to purge archived tasks: %w", err)
+			return
+		}
+
+		for _, aq := range existing {
+			if _, ok := asynqQueueWeights[aq]; !ok {
+				continue
+			}
+
+			var n int
+			n, err = b.inspector.DeleteAllArchivedTasks(aq)
+			if err != nil {
+				err = fmt.Errorf("unable to purge archived tasks: %w", err)
+				return
+			}
+			count += n
+		}
+
+		return
+	}
+
+	tasks, err := b.archivedTasks(queue)
+	if err != nil {
+		err = fmt.Errorf("unable to purge archived tasks: %w", err)
+		return
+	}
+
+	for _, ti := range tasks {
+		err = b.inspector.DeleteTask(ti.Queue, ti.ID)
+		if err != nil {
+			err = fmt.Errorf("unable to purge archived tasks: %w", err)
+			return
+		}
+		count++
+	}
+
+	return
+}
+
+// archivedTask fetches an archived task by ID. Tasks that do not exist or are not archived are not found.
+func (b *RedisBackend) archivedTask(taskID string) (ti *asynq.TaskInfo, found bool, err error) {
+	ti, err = b.taskInfo(taskID)
+	if err != nil {
+		if errors.Is(err, asynq.ErrTaskNotFound) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("unable to fetch task info: %w", err)
+	}
+
+	return ti, ti.State == asynq.TaskStateArchived, nil
+}
+
+// QueueStats reports the number of pending tasks on every queue
+//
+// All queues share the backend's concurrency. The time that the oldest pending job became due is only known for the
+// queues of the oldest pending tasks of each priority.
+func (b *RedisBackend) QueueStats(_ context.Context) (stats []neoq.QueueStats, err error) {
+	byQueue := map[string]*neoq.QueueStats{}
+	b.mu.Lock()
+	for queue := range b.handlers {
+		byQueue[queue] = &neoq.QueueStats{Queue: queue, Concurrency: b.config.BackendConcurrency}
+	}
+	b.mu.Unlock()
+
+	for _, aq := range asynqQueues {
+		err = b.pendingTaskStats(aq, byQueue)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, qs := range byQueue {
+		stats = append(stats, *qs)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Queue < stats[j].Queue })
+
+	return stats, nil
+}
+
+// pendingTaskStats counts the pending tasks on an asynq queue by the neoq queues that they belong to
+func (b *RedisBackend) pendingTaskStats(aq string, byQueue map[string]*neoq.QueueStats) (err error) {
+	info, err := b.inspector.GetQueueInfo(aq)
+	if err != nil && !errors.Is(err, asynq.ErrQueueNotFound) {
+		return fmt.Errorf("error getting queue info: %w", err)
+	}
+
+	for page := 1; info != nil && info.Pending > 0; page++ {
+		var pageTasks []*asynq.TaskInfo
+		pageTasks, err = b.inspector.ListPendingTasks(aq, asynq.Page(page), asynq.PageSize(neoq.DefaultPageSize))
+		if err != nil {
+			return fmt.Errorf("error listing pending tasks: %w", err)
+		}
+
+		for i, ti := range pageTasks {
+			qs, ok := byQueue[ti.Type]
+			if !ok {
+				qs = &neoq.QueueStats{Queue: ti.Type}
+				byQueue[ti.Type] = qs
+			}
+			qs.Pending++
+
+			// the first pending task is the oldest on its asynq queue
+			if page == 1 && i == 0 {
+				oldest := time.Now().UTC().Add(-info.Latency)
+				if qs.OldestPending.IsZero() || oldest.Before(qs.OldestPending) {
+					qs.OldestPending = oldest
+				}
+			}
+		}
+
+		if len(pageTasks) < neoq.DefaultPageSize {
+			break
+		}
+	}
+
+	return nil
+}
+
+// archivedTasks lists all archived tasks of the given neoq queue, or of all queues when queue is empty, most recently
+// failed first
+func (b *RedisBackend) archivedTasks(queue string) (tasks []*asynq.TaskInfo, err error) {
+	for _, aq := range asynqQueues {
+		for page := 1; ; page++ {
+			var pageTasks []*asynq.TaskInfo
+			pageTasks, err = b.inspector.ListArchivedTasks(aq, asynq.Page(page), asynq.PageSize(neoq.DefaultPageSize))
+			if err != nil {
+				if !errors.Is(err, asynq.ErrQueueNotFound) {
+					return nil, err
+				}
+
+				err = nil
+				break
+			}
+
+			for _, ti := range pageTasks {
+				if queue == "" || ti.Type == queue {
+					tasks = append(tasks, ti)
+				}
+			}
+
+			if len(pageTasks) < neoq.DefaultPageSize {
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].LastFailedAt.After(tasks[j].LastFailedAt) })
+
+	return tasks, nil
+}
+
+// taskInfo fetches a task by ID from whichever of the asynq queues it's on
+func (b *RedisBackend) taskInfo(taskID string) (ti *asynq.TaskInfo, err error) {
+	for _, aq := range asynqQueues {
+		ti, err = b.inspector.GetTaskInfo(aq, taskID)
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			continue
+		}
+
+		return
+	}
+
+	return nil, asynq.ErrTaskNotFound
 }
 
 // Start starts processing jobs with the specified queue and handler
 func (b *RedisBackend) Start(_ context.Context, h handler.Handler) (err error) {
+	if h.GlobalConcurrency > 0 {
+		return fmt.Errorf("%w: %s", ErrGlobalConcurrencyNotSupported, h.Queue)
+	}
+
+	h.Middleware = b.config.HandlerMiddleware(h)
+	b.mu.Lock()
+	b.handlers[h.Queue] = h
+	b.mu.Unlock()
+
 	b.mux.HandleFunc(h.Queue, func(ctx context.Context, t *asynq.Task) (err error) {
+		processStart := time.Now()
 		taskID := t.ResultWriter().TaskID()
-		var p map[string]any
-		if err = json.Unmarshal(t.Payload(), &p); err != nil {
+		aq, _ := asynq.GetQueueName(ctx)
+		ti, err := b.inspector.GetTaskInfo(aq, taskID)
+		if err != nil {
+			b.logger.Error("unable to process job", "error", err)
+			return
+		}
+
+		job := &jobs.Job{
+			CreatedAt:  time.Now().UTC(),
+			Queue:      h.Queue,
+			MaxRetries: ti.MaxRetry,
+		}
+		if err = taskPayloadToJob(t.Payload(), job); err != nil {
 			b.logger.Info("job has no payload", "task_id", taskID)
 		}
+		// tasks enqueued by earlier versions of neoq were identified by their jobs' fingerprints
+		if job.Fingerprint == "" {
+			job.Fingerprint = taskID
+		}
+		job.TaskID = taskID
+		job.Deadline = &ti.Deadline
+		job.RunAfter = ti.NextProcessAt
+		job.Retries = ti.Retried
+		event := neoq.JobEvent{Job: job, Attempt: job.Retries + 1}
 
-		ti, err := b.inspector.GetTaskInfo(defaultAsynqQueue, taskID)
-		if err != nil {
-			b.logger.Error("unable to process job", "error", err)
+		// jobs are snoozed when their rate limit key has no tokens, which reschedules them without consuming retries
+		if err = b.rateLimit(ctx, h, job); err != nil {
 			return
 		}
+
+		// unique jobs' keys are released or expired once they're processed, see completeUniqueKey
+		uniqueKey, unique := taskUnique(t.Payload())
+		if uniqueKey != "" {
+			if unique.Scope == jobs.UniqueWhileQueued {
+				b.releaseUniqueKey(ctx, uniqueKey, taskID)
+			}
+			defer func() { b.completeUniqueKey(ctx, uniqueKey, taskID, unique, err) }()
+		}
+
+		ctx, span := b.config.StartJobSpan(ctx, job, processStart)
+		defer func() { neoq.EndSpan(span, err) }()
+		ctx = withJobContext(ctx, job)
+		ctx = jobs.WithResultWriter(ctx, &resultWriter{mu: &sync.Mutex{}, job: job, w: t.ResultWriter()})
+		ctx = b.config.ExtractMetadata(ctx, job)
 		if !ti.Deadline.IsZero() && ti.Deadline.UTC().Before(time.Now().UTC()) {
-			err = jobs.ErrJobExceededDeadline
 			b.logger.Debug("job deadline is in the past, skipping", "task_id", taskID)
+			event.Err = jobs.ErrJobExceededDeadline
+			event.Type = neoq.JobSkipped
+			b.config.FireJobEvent(ctx, event)
+			event.Type = neoq.JobDead
+			b.config.FireJobEvent(ctx, event)
+			// jobs that have exceeded their deadlines are archived, rather than retried
+			return fmt.Errorf("%w: %w", event.Err, asynq.SkipRetry)
+		}
+
+		event.Type = neoq.JobStarted
+		b.config.FireJobEvent(ctx, event)
+		start := time.Now()
+		hctx, hspan := b.config.StartHandlerSpan(ctx, job)
+		err = handler.Exec(hctx, h)
+		neoq.EndSpan(hspan, err)
+		event.Duration = time.Since(start)
+		if err == nil {
+			event.Type = neoq.JobSucceeded
+			b.config.FireJobEvent(ctx, event)
 			return
 		}
 
-		job := &jobs.Job{
-			CreatedAt: time.Now().UTC(),
-			Queue:     h.Queue,
-			Payload:   p,
-			Deadline:  &ti.Deadline,
-			RunAfter:  ti.NextProcessAt,
+		// snoozed tasks are rescheduled by asynq with the delay from retryDelay
+		if _, snoozed := jobs.Snoozed(err); snoozed {
+			event.Err = err
+			event.Type = neoq.JobSnoozed
+			b.config.FireJobEvent(ctx, event)
+			return
 		}
 
-		ctx = withJobContext(ctx, job)
-		err = handler.Exec(ctx, h)
-		if err != nil {
-			b.logger.Error("error handling job", "error", err)
+		b.logger.Error("error handling job", "error", err)
+		event.Err = err
+		event.Type = neoq.JobFailed
+		b.config.FireJobEvent(ctx, event)
+
+		// jobs that failed permanently are not retried, and asynq only knows the maximum retries of jobs that set their own
+		if jobs.IsPermanent(err) || job.Retries >= h.JobMaxRetries(job) {
+			err = fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+		}
+
+		// asynq archives tasks that have exhausted their retries, or that skip retries
+		event.Type = neoq.JobRetried
+		if errors.Is(err, asynq.SkipRetry) {
+			event.Type = neoq.JobDead
 		}
+		b.config.FireJobEvent(ctx, event)
 
 		return
 	})
@@ -244,6 +946,50 @@ func (b *RedisBackend) Start(_ context.Context, h handler.Handler) (err error) {
 	return nil
 }
 
+// rateLimit waits for the handler's rate limit to allow job to be processed, or snoozes job when its key has no tokens,
+// so that it doesn't hold up jobs with other keys
+func (b *RedisBackend) rateLimit(ctx context.Context, h handler.Handler, job *jobs.Job) (err error) {
+	if !h.RateLimited() {
+		return
+	}
+
+	bucket, keyed := h.RateLimit.Bucket(h.Queue, job)
+	wait, err := b.takeRateLimitToken(ctx, h.RateLimit, bucket, !keyed)
+	if err != nil || wait <= 0 {
+		return
+	}
+
+	if keyed {
+		b.logger.Debug("job's rate limit exceeded, rescheduling", "fingerprint", job.Fingerprint, "bucket", bucket)
+		return jobs.Snooze(h.RateLimitDelay(wait))
+	}
+
+	select {
+	case <-time.After(wait):
+		return
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// takeRateLimitToken takes a token from a rate limit bucket, returning how long to wait for it when the bucket is empty.
+// See rateLimitScript.
+func (b *RedisBackend) takeRateLimitToken(ctx context.Context, rate *handler.Rate, bucket string, reserve bool,
+) (wait time.Duration, err error) {
+	reserveArg := 0
+	if reserve {
+		reserveArg = 1
+	}
+
+	micros, err := rateLimitScript.Run(ctx, b.rdb, []string{rateLimitKeyPrefix + bucket}, rate.Limit,
+		rate.Interval().Microseconds(), reserveArg).Float64()
+	if err != nil {
+		return 0, fmt.Errorf("unable to take rate limit token: %w", err)
+	}
+
+	return time.Duration(micros * float64(time.Microsecond)), nil
+}
+
 // StartCron starts processing jobs with the specified cron schedule and handler
 //
 // See: https://pkg.go.dev/github.com/robfig/cron?#hdr-CRON_Expression_Format for details on the cron spec format
@@ -276,9 +1022,26 @@ func (b *RedisBackend) StartCron(ctx context.Context, cronSpec string, h handler
 	return
 }
 
+// retryDelay is the asynq RetryDelayFunc that applies the retry policies of jobs and their handlers to failed tasks,
+// and reschedules snoozed tasks after the time they were snoozed for
+func (b *RedisBackend) retryDelay(retried int, err error, t *asynq.Task) time.Duration {
+	if d, snoozed := jobs.Snoozed(err); snoozed {
+		return d
+	}
+
+	b.mu.Lock()
+	h := b.handlers[t.Type()]
+	b.mu.Unlock()
+
+	job := &jobs.Job{Queue: t.Type(), Retries: retried}
+	_ = taskPayloadToJob(t.Payload(), job)
+
+	return h.RetryDelay(job, err)
+}
+
 // jobToTaskOptions converts jobs.Job to a slice of asynq.Option that corresponds with its settings
-func jobToTaskOptions(job *jobs.Job) (opts []asynq.Option) {
-	opts = append(opts, asynq.TaskID(job.Fingerprint))
+func (b *RedisBackend) jobToTaskOptions(job *jobs.Job, taskID string) (opts []asynq.Option) {
+	opts = append(opts, asynq.TaskID(taskID), asynq.Queue(asynqQueue(job.Priority)))
 
 	if !job.RunAfter.IsZero() {
 		opts = append(opts, asynq.ProcessAt(job.RunAfter))
@@ -288,6 +1051,142 @@ func jobToTaskOptions(job *jobs.Job) (opts []asynq.Option) {
 		opts = append(opts, asynq.Deadline(*job.Deadline))
 	}
 
+	// jobs that don't set their own maximum retries are retried until their handlers' maximum is reached, which is
+	// only known when they're processed
+	maxRetry := math.MaxInt32
+	if job.MaxRetries == jobs.NoRetries {
+		maxRetry = 0
+	} else if job.MaxRetries > 0 {
+		maxRetry = job.MaxRetries
+	}
+	opts = append(opts, asynq.MaxRetry(maxRetry))
+
+	if b.config.CompletedJobRetention > 0 {
+		opts = append(opts, asynq.Retention(b.config.CompletedJobRetention))
+	}
+
+	return
+}
+
+// jobToTaskPayload encodes jobs' payloads as asynq task payloads, along with the unique key that they hold, if any
+func jobToTaskPayload(job *jobs.Job, uniqueKey string) (payload []byte, err error) {
+	return json.Marshal(taskPayload{
+		Version:      taskPayloadVersion,
+		Payload:      job.Payload,
+		RawPayload:   job.RawPayload,
+		Codec:        job.Codec,
+		TraceContext: job.TraceContext,
+		Metadata:     job.Metadata,
+		MaxRetries:   job.MaxRetries,
+		Backoff:      job.Backoff,
+		Priority:     job.Priority,
+		Fingerprint:  job.Fingerprint,
+		UniqueKey:    uniqueKey,
+		Unique:       taskUniqueness(job, uniqueKey),
+	})
+}
+
+// asynqQueue is the asynq queue that jobs with the given priority are placed on
+func asynqQueue(priority int) string {
+	switch {
+	case priority > 0:
+		return highPriorityAsynqQueue
+	case priority < 0:
+		return lowPriorityAsynqQueue
+	default:
+		return defaultAsynqQueue
+	}
+}
+
+// taskPayloadToJob decodes asynq task payloads onto the jobs they belong to
+//
+// Task payloads enqueued by earlier versions of neoq are decoded as JSON job payloads
+func taskPayloadToJob(payload []byte, job *jobs.Job) (err error) {
+	var tp taskPayload
+	if err = json.Unmarshal(payload, &tp); err != nil || tp.Version == 0 {
+		return json.Unmarshal(payload, &job.Payload)
+	}
+
+	job.Payload = tp.Payload
+	job.RawPayload = tp.RawPayload
+	job.Codec = tp.Codec
+	job.TraceContext = tp.TraceContext
+	job.Metadata = tp.Metadata
+	job.MaxRetries = tp.MaxRetries
+	job.Backoff = tp.Backoff
+	job.Priority = tp.Priority
+	job.Fingerprint = tp.Fingerprint
+
+	return
+}
+
+// taskUniqueness is the uniqueness recorded in the task payloads of jobs that hold unique keys
+func taskUniqueness(job *jobs.Job, uniqueKey string) *jobs.Unique {
+	if uniqueKey == "" {
+		return nil
+	}
+
+	return &jobs.Unique{Scope: job.UniqueScope(), Window: job.UniqueWindow()}
+}
+
+// taskUnique is the unique key held by the job of an asynq task payload, if any, and the job's uniqueness
+func taskUnique(payload []byte) (key string, unique *jobs.Unique) {
+	var tp taskPayload
+	if err := json.Unmarshal(payload, &tp); err != nil || tp.UniqueKey == "" {
+		return "", nil
+	}
+
+	if tp.Unique == nil {
+		return tp.UniqueKey, &jobs.Unique{Scope: jobs.UniqueWhileQueued}
+	}
+
+	return tp.UniqueKey, tp.Unique
+}
+
+// taskInfoToJob converts asynq.TaskInfo to the jobs.Job that it corresponds with
+func taskInfoToJob(ti *asynq.TaskInfo) (job *jobs.Job) {
+	job = &jobs.Job{
+		TaskID:     ti.ID,
+		Queue:      ti.Type,
+		Retries:    ti.Retried,
+		MaxRetries: ti.MaxRetry,
+		RunAfter:   ti.NextProcessAt,
+	}
+
+	if len(ti.Payload) > 0 {
+		_ = taskPayloadToJob(ti.Payload, job)
+	}
+
+	// tasks enqueued by earlier versions of neoq were identified by their jobs' fingerprints
+	if job.Fingerprint == "" {
+		job.Fingerprint = ti.ID
+	}
+
+	var tr taskResult
+	if len(ti.Result) > 0 && json.Unmarshal(ti.Result, &tr) == nil {
+		job.Result = tr.Result
+		job.Progress = tr.Progress
+	}
+
+	if !ti.Deadline.IsZero() {
+		job.Deadline = &ti.Deadline
+	}
+
+	if ti.LastErr != "" {
+		job.Error = null.StringFrom(ti.LastErr)
+	}
+
+	switch ti.State {
+	case asynq.TaskStateCompleted:
+		job.Status = internal.JobStatusProcessed
+		job.RanAt = null.TimeFrom(ti.CompletedAt)
+	case asynq.TaskStateRetry, asynq.TaskStateArchived:
+		job.Status = internal.JobStatusFailed
+		job.RanAt = null.TimeFrom(ti.LastFailedAt)
+	case asynq.TaskStateActive, asynq.TaskStatePending, asynq.TaskStateScheduled, asynq.TaskStateAggregating:
+		job.Status = internal.JobStatusNew
+	}
+
 	return
 }
 
@@ -333,6 +1232,45 @@ func (b *RedisBackend) SetLogger(logger logging.Logger) {
 func (b *RedisBackend) Shutdown(ctx context.Context) {
 	b.client.Close()
 	b.server.Shutdown()
+	b.rdb.Close()
+}
+
+// resultWriter is the [jobs.ResultWriter] of the Redis backend, which writes results and progress as the results of
+// jobs' asynq tasks
+type resultWriter struct {
+	mu  *sync.Mutex
+	job *jobs.Job
+	w   *asynq.ResultWriter
+}
+
+func (w *resultWriter) WriteResult(_ context.Context, result []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.job.Result = append([]byte(nil), result...)
+	return w.write()
+}
+
+func (w *resultWriter) WriteProgress(_ context.Context, progress jobs.Progress) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.job.Progress = &progress
+	return w.write()
+}
+
+// write writes the job's result and progress, since asynq tasks only have one result
+func (w *resultWriter) write() (err error) {
+	result, err := json.Marshal(taskResult{Result: w.job.Result, Progress: w.job.Progress})
+	if err != nil {
+		return fmt.Errorf("unable to encode job result: %w", err)
+	}
+
+	if _, err = w.w.Write(result); err != nil {
+		return fmt.Errorf("unable to write job result: %w", err)
+	}
+
+	return nil
 }
 
 // withJobContext creates a new context with the Job set
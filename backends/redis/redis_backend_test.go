@@ -6,15 +6,20 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/acaloiaro/neoq"
+	"github.com/acaloiaro/neoq/codec"
 	"github.com/acaloiaro/neoq/handler"
 	"github.com/acaloiaro/neoq/internal"
 	"github.com/acaloiaro/neoq/jobs"
 	"github.com/acaloiaro/neoq/testutils"
 	"github.com/hibiken/asynq"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -368,3 +373,1600 @@ func TestJobProcessingWithJobDeadline(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// TestEnqueueMany tests that many jobs can be enqueued at once, and that duplicates are reported per job
+func TestEnqueueMany(t *testing.T) {
+	const numJobs = 10
+	done := make(chan bool)
+
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	password := os.Getenv("REDIS_PASSWORD")
+	ctx := context.Background()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(password),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		done <- true
+		return
+	})
+
+	err = nq.Start(ctx, h)
+	if err != nil {
+		t.Error(err)
+	}
+
+	nonce := internal.RandInt(10000000000)
+	js := []*jobs.Job{}
+	for i := 0; i < numJobs; i++ {
+		js = append(js, &jobs.Job{
+			Queue:   queue,
+			Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d %d", nonce, i)},
+		})
+	}
+	// the last job duplicates the first
+	js = append(js, &jobs.Job{Queue: queue, Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d 0", nonce)}})
+
+	jobIDs, err := nq.EnqueueMany(ctx, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jobIDs[numJobs] != jobs.DuplicateJobID {
+		t.Errorf("expected the last job to be a duplicate, but its ID is: %s", jobIDs[numJobs])
+	}
+
+	timeoutTimer := time.After(10 * time.Second)
+	for i := 0; i < numJobs; i++ {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("expected %d jobs to be processed, but only %d were", numJobs, i)
+		case <-done:
+		}
+	}
+}
+
+// TestEnqueueManyPartialFailure tests that when enqueueing many jobs fails part way, the IDs of the jobs that were
+// enqueued before the failure are returned, and that their interceptors observe their IDs rather than the error
+func TestEnqueueManyPartialFailure(t *testing.T) {
+	const queue = "partial_failure"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	var mu sync.Mutex
+	observed := map[string]error{}
+	interceptor := func(ctx context.Context, job *jobs.Job, next neoq.EnqueueFunc) (jobID string, err error) {
+		jobID, err = next(ctx, job)
+		mu.Lock()
+		observed[fmt.Sprint(job.Payload["message"])] = err
+		mu.Unlock()
+		return
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		neoq.WithEnqueueInterceptor(interceptor),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	nonce := internal.RandInt(10000000000)
+	js := []*jobs.Job{
+		{Queue: queue, Payload: map[string]interface{}{"message": fmt.Sprintf("partial failure: %d 0", nonce)}},
+		{Queue: queue, Payload: map[string]interface{}{"message": fmt.Sprintf("partial failure: %d 1", nonce)}},
+		// payloads that can't be encoded fail to be enqueued
+		{Queue: queue, Payload: map[string]interface{}{"message": fmt.Sprintf("partial failure: %d 2", nonce), "ch": make(chan int)}},
+		{Queue: queue, Payload: map[string]interface{}{"message": fmt.Sprintf("partial failure: %d 3", nonce)}},
+	}
+
+	jobIDs, err := nq.EnqueueMany(ctx, js)
+	if err == nil {
+		t.Fatal("expected enqueueing a job with a payload that can't be encoded to fail")
+	}
+
+	if len(jobIDs) != len(js) {
+		t.Fatalf("expected %d job IDs, got: %v", len(js), jobIDs)
+	}
+
+	for i, jobID := range jobIDs {
+		message := fmt.Sprint(js[i].Payload["message"])
+		enqueued := i < 2
+		if enqueued != (jobID != "") {
+			t.Errorf("job %d: unexpected job ID %q", i, jobID)
+		}
+
+		if enqueued != (observed[message] == nil) {
+			t.Errorf("job %d: interceptor observed unexpected error: %v", i, observed[message])
+		}
+
+		if !enqueued {
+			continue
+		}
+
+		_, err = nq.GetJob(ctx, jobID)
+		if err != nil {
+			t.Errorf("job %d was not enqueued: %v", i, err)
+		}
+	}
+}
+
+// TestGetJob tests that pending, completed, and dead jobs can be retrieved by ID, and that unknown jobs are not found
+// nolint: gocognit
+func TestGetJob(t *testing.T) {
+	const queue = "get_job"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		WithRetention(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Payload["fail"] == true {
+			return jobs.Permanent(errors.New("something bad happened"))
+		}
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := internal.RandInt(10000000000)
+	runAfter := time.Now().Add(time.Hour)
+	pendingID, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:    queue,
+		Payload:  map[string]interface{}{"message": fmt.Sprintf("pending: %d", nonce)},
+		RunAfter: runAfter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	completedID, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("completed: %d", nonce)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadID, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("dead: %d", nonce), "fail": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := nq.GetJob(ctx, pendingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Status != internal.JobStatusNew || pending.Queue != queue || pending.TaskID != pendingID ||
+		pending.Payload["message"] != fmt.Sprintf("pending: %d", nonce) || !pending.RunAfter.Equal(runAfter.Truncate(time.Second)) {
+		t.Errorf("pending job was not retrieved as it was enqueued: %+v", pending)
+	}
+
+	for _, expected := range []struct {
+		id     string
+		status string
+	}{
+		{id: completedID, status: internal.JobStatusProcessed},
+		{id: deadID, status: internal.JobStatusFailed},
+	} {
+		var j *jobs.Job
+		timeout := time.After(5 * time.Second)
+		for j == nil || j.Status != expected.status {
+			select {
+			case <-timeout:
+				t.Fatalf("job status was never updated to '%s': %+v", expected.status, j)
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			j, err = nq.GetJob(ctx, expected.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !j.RanAt.Valid || j.Queue != queue || j.TaskID != expected.id {
+			t.Errorf("job was not retrieved as it was processed: %+v", j)
+		}
+
+		if dead := expected.id == deadID; dead != j.Error.Valid {
+			t.Errorf("expected only the dead job to have an error, got: %+v", j)
+		}
+	}
+
+	_, err = nq.GetJob(ctx, "unknown")
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected '%v', got: %v", jobs.ErrJobNotFound, err)
+	}
+}
+
+// TestDeadJobs tests that dead jobs can be listed, requeued, and deleted
+// nolint: gocognit, gocyclo
+func TestDeadJobs(t *testing.T) {
+	const queue = "dead_jobs"
+	const numJobs = 3
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		WithRetention(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	// jobs die the first time that they run, and succeed once they're requeued
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	ran := make(chan bool, numJobs*2)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		attempts[j.TaskID]++
+		attempt := attempts[j.TaskID]
+		mu.Unlock()
+		ran <- true
+
+		if attempt == 1 {
+			return jobs.Permanent(errors.New("something bad happened"))
+		}
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := internal.RandInt(10000000000)
+	for i := 0; i < numJobs; i++ {
+		_, err = nq.Enqueue(ctx, &jobs.Job{
+			Queue:   queue,
+			Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d %d", nonce, i)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deadJobs []*jobs.Job
+	timeoutTimer := time.After(5 * time.Second)
+	for len(deadJobs) < numJobs {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("expected %d dead jobs, found: %d", numJobs, len(deadJobs))
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		deadJobs, err = nq.ListDeadJobs(ctx, neoq.ListQueue(queue))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := nq.ListDeadJobs(ctx, neoq.ListQueue(queue), neoq.ListPage(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].TaskID != deadJobs[2].TaskID {
+		t.Errorf("expected the second page to contain only job %s, got: %v", deadJobs[2].TaskID, page)
+	}
+
+	<-ran
+	<-ran
+	<-ran
+	count, err := nq.RequeueDeadJobs(ctx, deadJobs[0].TaskID, "unknown")
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 job to be requeued, got %d: %v", count, err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("requeued job was never processed")
+	case <-ran:
+	}
+
+	var requeued *jobs.Job
+	timeoutTimer = time.After(5 * time.Second)
+	for requeued == nil || requeued.Status != internal.JobStatusProcessed {
+		select {
+		case <-timeoutTimer:
+			t.Fatalf("requeued job was never processed: %+v", requeued)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		requeued, err = nq.GetJob(ctx, deadJobs[0].TaskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err = nq.DeleteDeadJobs(ctx, deadJobs[1].TaskID, "unknown")
+	if err != nil || count != 1 {
+		t.Errorf("expected 1 job to be deleted, got %d: %v", count, err)
+	}
+
+	_, err = nq.GetJob(ctx, deadJobs[1].TaskID)
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected deleted dead job to not be found, got: %v", err)
+	}
+
+	count, err = nq.PurgeDeadJobs(ctx, queue)
+	if err != nil || count != 1 {
+		t.Errorf("expected 1 job to be purged, got %d: %v", count, err)
+	}
+
+	deadJobs, err = nq.ListDeadJobs(ctx, neoq.ListQueue(queue))
+	if err != nil || len(deadJobs) != 0 {
+		t.Errorf("expected no dead jobs once they've been purged, got %v: %v", deadJobs, err)
+	}
+}
+
+// TestCodecs tests that jobs with payloads encoded by codecs other than JSON are decoded by their handlers
+func TestCodecs(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	const msgpackQueue = "msgpack"
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		neoq.WithCodec(msgpackQueue, codec.Msgpack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string)
+	err = nq.Start(ctx, handler.NewTyped(queue, func(_ context.Context, b []byte) (err error) {
+		done <- string(b)
+		return
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = nq.Start(ctx, handler.NewTyped(msgpackQueue, func(_ context.Context, p map[string]any) (err error) {
+		done <- fmt.Sprint(p["message"])
+		return
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawPayload := fmt.Sprintf("hello raw: %d", internal.RandInt(10000000000))
+	rawJob, err := jobs.NewEncoded(queue, []byte(rawPayload), codec.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgpackPayload := fmt.Sprintf("hello msgpack: %d", internal.RandInt(10000000000))
+	msgpackJob := &jobs.Job{Queue: msgpackQueue, Payload: map[string]any{"message": msgpackPayload}}
+
+	for _, job := range []*jobs.Job{rawJob, msgpackJob} {
+		_, err = nq.Enqueue(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := map[string]bool{}
+	for len(received) < 2 {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for jobs, received: %v", received)
+		case p := <-done:
+			received[p] = true
+		}
+	}
+
+	if !received[rawPayload] || !received[msgpackPayload] {
+		t.Errorf("expected payloads '%s' and '%s' to be received, got: %v", rawPayload, msgpackPayload, received)
+	}
+}
+
+// TestMiddleware tests that backend-wide middleware wraps handler middleware, which wraps handlers
+func TestMiddleware(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	calls := make(chan string, 10) // nolint: gomnd
+	record := func(name string) handler.Middleware {
+		return func(next handler.Func) handler.Func {
+			return func(ctx context.Context) error {
+				calls <- name
+				return next(ctx)
+			}
+		}
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		neoq.WithMiddleware(record("backend")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, record("handler")(func(_ context.Context) (err error) { return }),
+		handler.WithMiddleware(record("handler_mw")))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"backend", "handler_mw", "handler"} {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for middleware '%s'", expected)
+		case c := <-calls:
+			if c != expected {
+				t.Fatalf("expected middleware '%s' to be called, got: '%s'", expected, c)
+			}
+		}
+	}
+}
+
+// TestTracing tests that handlers' spans are children of the spans that enqueued their jobs
+func TestTracing(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		neoq.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan trace.SpanContext, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		done <- trace.SpanContextFromContext(ctx)
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	enqueueCtx, parent := tp.Tracer("test").Start(ctx, "request")
+	_, err = nq.Enqueue(enqueueCtx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case sc := <-done:
+		if sc.TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("expected handler to run in trace %s, got: %s", parent.SpanContext().TraceID(), sc.TraceID())
+		}
+	}
+}
+
+type requestIDKey struct{}
+
+// TestMetadata tests that job metadata carries propagated context values to handlers
+func TestMetadata(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		neoq.WithContextPropagator(neoq.ContextValuePropagator("request_id", requestIDKey{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- fmt.Sprintf("%v %s %s", ctx.Value(requestIDKey{}), j.Metadata["request_id"], j.Metadata["tenant"])
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(context.WithValue(ctx, requestIDKey{}, "req-1"), &jobs.Job{
+		Queue:    queue,
+		Payload:  map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+		Metadata: map[string]string{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case got := <-done:
+		if got != "req-1 req-1 acme" {
+			t.Errorf("expected handler to observe request ID and metadata 'req-1 req-1 acme', got: '%s'", got)
+		}
+	}
+}
+
+// TestMaxRetries tests that jobs' maximum retries, and their handlers' defaults, determine whether failed jobs retry
+func TestMaxRetries(t *testing.T) {
+	const queue = "max_retries"
+	const noRetriesQueue = "no_retries"
+	outcomes := make(chan string, 10) // nolint: gomnd
+	recordOutcome := func(outcome string) neoq.JobHook {
+		return func(_ context.Context, event neoq.JobEvent) {
+			outcomes <- fmt.Sprintf("%s %s", event.Job.Payload["name"], outcome)
+		}
+	}
+
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		neoq.WithOnJobRetried(recordOutcome("retried")),
+		neoq.WithOnJobDead(recordOutcome("dead")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	fail := func(_ context.Context) error { return errors.New("something bad happened") }
+	if err = nq.Start(ctx, handler.New(queue, fail)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = nq.Start(ctx, handler.New(noRetriesQueue, fail, handler.MaxRetries(jobs.NoRetries))); err != nil {
+		t.Fatal(err)
+	}
+
+	testJobs := map[string]*jobs.Job{
+		"job_no_retries":     {Queue: queue, MaxRetries: jobs.NoRetries},
+		"job_default":        {Queue: queue},
+		"handler_no_retries": {Queue: noRetriesQueue},
+		"job_overrides":      {Queue: noRetriesQueue, MaxRetries: 1},
+	}
+	expected := map[string]bool{
+		"job_no_retries dead":     true,
+		"job_default retried":     true,
+		"handler_no_retries dead": true,
+		"job_overrides retried":   true,
+	}
+	for name, job := range testJobs {
+		job.Payload = map[string]interface{}{"name": name, "nonce": internal.RandInt(10000000000)}
+		if _, err = nq.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range testJobs {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case outcome := <-outcomes:
+			if !expected[outcome] {
+				t.Errorf("unexpected job outcome: %s", outcome)
+			}
+		}
+	}
+}
+
+// TestRetryPolicy tests that failed jobs are retried per their own retry policies, which take precedence over their
+// handlers'
+func TestRetryPolicy(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan bool, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Retries == 0 {
+			return errors.New("something bad happened")
+		}
+
+		done <- true
+		return
+	}, handler.RetryPolicy(jobs.ConstantBackoff(time.Hour)))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+		Backoff: jobs.ConstantBackoff(time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(15 * time.Second):
+		t.Fatal("expected the failed job to be retried per its retry policy")
+	case <-done:
+	}
+}
+
+// TestPermanentErrors tests that jobs which fail permanently are not retried, and have their errors recorded
+func TestPermanentErrors(t *testing.T) {
+	const queue = "permanent_errors"
+	errUserDeleted := errors.New("user deleted")
+	dead := make(chan neoq.JobEvent, 1)
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		neoq.WithOnJobDead(func(_ context.Context, event neoq.JobEvent) { dead <- event }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) error {
+		return jobs.Permanent(errUserDeleted)
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the permanently failed job to be dead")
+	case event := <-dead:
+		if !errors.Is(event.Err, errUserDeleted) || !jobs.IsPermanent(event.Err) {
+			t.Errorf("expected the job's error to be permanent and wrap '%v', got: %v", errUserDeleted, event.Err)
+		}
+
+		if event.Attempt != 1 {
+			t.Errorf("expected the job to be attempted once, got: %d", event.Attempt)
+		}
+	}
+}
+
+// TestSnooze tests that handlers can snooze their jobs without failing them or counting retries
+func TestSnooze(t *testing.T) {
+	const queue = "snooze"
+	snoozes := make(chan neoq.JobEvent, 2) // nolint: gomnd
+	failures := make(chan neoq.JobEvent, 1)
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		neoq.WithOnJobSnoozed(func(_ context.Context, event neoq.JobEvent) { snoozes <- event }),
+		neoq.WithOnJobFailed(func(_ context.Context, event neoq.JobEvent) { failures <- event }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan *jobs.Job, 1)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if len(snoozes) == 0 {
+			return jobs.Snooze(time.Millisecond)
+		}
+
+		done <- j
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(15 * time.Second):
+		t.Fatal("expected the snoozed job to run again")
+	case j := <-done:
+		if j.Retries != 0 {
+			t.Errorf("expected the job to be snoozed without retrying, got %d retries", j.Retries)
+		}
+	}
+
+	select {
+	case event := <-failures:
+		t.Errorf("expected snoozed jobs not to fail, got: %v", event.Err)
+	default:
+	}
+}
+
+// TestPriority tests that jobs are processed from the asynq queues of their priorities
+func TestPriority(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	priorities := []int{-1, 0, 1}
+	processed := make(chan bool, len(priorities))
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if aq, _ := asynq.GetQueueName(ctx); aq != asynqQueue(j.Priority) {
+			t.Errorf("expected the job with priority %d to be on the %s queue, got: %s", j.Priority, asynqQueue(j.Priority), aq)
+		}
+
+		processed <- true
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, priority := range priorities {
+		_, err = nq.Enqueue(ctx, &jobs.Job{
+			Queue:    queue,
+			Priority: priority,
+			Payload:  map[string]any{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range priorities {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-processed:
+		}
+	}
+}
+
+// TestResults tests that the results and progress written by handlers can be looked up with their jobs
+func TestResults(t *testing.T) {
+	const queue = "results"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		WithRetention(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	progressed := make(chan bool)
+	release := make(chan bool)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		if err = jobs.WriteProgress(ctx, 42, "8,400 of 20,000 rows imported"); err != nil {
+			return
+		}
+		progressed <- true
+		<-release
+
+		if err = jobs.WriteProgress(ctx, 100, "20,000 of 20,000 rows imported"); err != nil {
+			return
+		}
+
+		return jobs.WriteResult(ctx, []byte("imported"))
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	jobID, err := nq.Enqueue(ctx, &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-progressed:
+	}
+
+	job, err := nq.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Progress == nil || *job.Progress != (jobs.Progress{Percent: 42, Message: "8,400 of 20,000 rows imported"}) {
+		t.Errorf("expected the job's progress to be reported while it runs, got: %+v", job.Progress)
+	}
+	close(release)
+
+	timeout := time.After(5 * time.Second)
+	for job.Status != internal.JobStatusProcessed {
+		select {
+		case <-timeout:
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		if job, err = nq.GetJob(ctx, jobID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if string(job.Result) != "imported" || job.Progress == nil || job.Progress.Percent != 100 {
+		t.Errorf("expected the job's result and progress to be persisted, got result %q and progress %+v", job.Result,
+			job.Progress)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: map[string]any{"name": "child"}, DependsOn: []string{"parent"}})
+	if !errors.Is(err, ErrDependenciesNotSupported) {
+		t.Errorf("expected jobs with dependencies to be rejected, got: %v", err)
+	}
+
+	_, err = nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]any{"name": "parent"}},
+		{Queue: queue, Payload: map[string]any{"name": "child"}, DependsOn: []string{"parent"}},
+	})
+	if !errors.Is(err, ErrDependenciesNotSupported) {
+		t.Errorf("expected batches with dependencies to be rejected, got: %v", err)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	_, err = nq.EnqueueBatch(ctx, &jobs.Batch{Jobs: []*jobs.Job{{Queue: queue, Payload: map[string]any{"customer": 1}}}})
+	if !errors.Is(err, ErrBatchesNotSupported) {
+		t.Errorf("expected batches to be rejected, got: %v", err)
+	}
+}
+
+// TestRateLimit tests that rate-limited queues process jobs no faster than their limit, without consuming retries
+func TestRateLimit(t *testing.T) {
+	// rate-limited queues are separate from other tests' queues, so that other tests' jobs don't use their tokens
+	const queue = "rate_limited"
+	const numJobs = 4
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		WithConcurrency(numJobs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan *jobs.Job, numJobs)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		done <- j
+		return
+	}, handler.RateLimit(2, 400*time.Millisecond)) // nolint: gomnd
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < numJobs; i++ {
+		_, err = nq.Enqueue(ctx, &jobs.Job{
+			Queue:   queue,
+			Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < numJobs; i++ {
+		select {
+		case <-time.After(15 * time.Second):
+			t.Fatal("expected every rate-limited job to be processed")
+		case j := <-done:
+			if j.Retries != 0 {
+				t.Errorf("expected rate-limited jobs not to consume retries, got %d retries", j.Retries)
+			}
+		}
+	}
+
+	// the first two jobs use the bucket's two tokens, and the next two wait 200ms each for theirs
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("expected %d jobs to take at least 400ms at 2 jobs per 400ms, took %s", numJobs, elapsed)
+	}
+}
+
+// TestRateLimitKey tests that jobs limited by key are limited separately, and that jobs whose key has no tokens don't
+// hold up jobs with other keys
+func TestRateLimitKey(t *testing.T) {
+	const queue = "rate_limited_by_key"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 3) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		done <- j.Payload["customer"].(string)
+		return
+	}, handler.RateLimit(1, time.Hour), handler.RateLimitKey(func(j *jobs.Job) string {
+		customer, _ := j.Payload["customer"].(string)
+		return customer
+	}))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	// customers are unique to each run, since their buckets outlive the test
+	a := fmt.Sprintf("a-%d", internal.RandInt(10000000000))
+	b := fmt.Sprintf("b-%d", internal.RandInt(10000000000))
+	for _, customer := range []string{a, a, b} {
+		_, err = nq.Enqueue(ctx, &jobs.Job{
+			Queue:   queue,
+			Payload: map[string]interface{}{"customer": customer, "nonce": internal.RandInt(10000000000)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	processed := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(15 * time.Second):
+			t.Fatalf("expected one job per customer to be processed, got: %v", processed)
+		case customer := <-done:
+			processed[customer]++
+		}
+	}
+
+	select {
+	case customer := <-done:
+		t.Errorf("expected customer %s's second job to wait for its rate limit", customer)
+	case <-time.After(2 * time.Second):
+	}
+
+	if processed[a] != 1 || processed[b] != 1 {
+		t.Errorf("expected one job per customer to be processed, got: %v", processed)
+	}
+}
+
+// TestGlobalConcurrency tests that handlers with a global concurrency are rejected
+func TestGlobalConcurrency(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) { return }, handler.GlobalConcurrency(1))
+	err = nq.Start(ctx, h)
+	if !errors.Is(err, ErrGlobalConcurrencyNotSupported) {
+		t.Errorf("expected handlers with a global concurrency to be rejected, got: %v", err)
+	}
+}
+
+// TestUniqueScopes tests that jobs duplicate jobs that are being processed unless they're unique while queued, and that
+// jobs that are not unique are never duplicates
+func TestUniqueScopes(t *testing.T) {
+	const queue = "unique_scopes"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		WithConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	started := make(chan bool, 3) // nolint: gomnd
+	release := make(chan bool)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		started <- true
+		<-release
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+
+	payload := map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))}
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case <-started:
+	}
+
+	tests := []struct {
+		name      string
+		unique    *jobs.Unique
+		duplicate bool
+	}{
+		{name: "unique while active", unique: nil, duplicate: true},
+		{name: "unique while queued", unique: jobs.UniqueWhile(jobs.UniqueWhileQueued), duplicate: false},
+		{name: "unique while queued, with a queued job", unique: jobs.UniqueWhile(jobs.UniqueWhileQueued), duplicate: true},
+		{name: "not unique", unique: jobs.UniqueWhile(jobs.NotUnique), duplicate: false},
+	}
+	for _, tt := range tests {
+		_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: tt.unique})
+		duplicate := errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask)
+		if err != nil && !duplicate {
+			t.Fatal(err)
+		}
+
+		if duplicate != tt.duplicate {
+			t.Errorf("%s: expected duplicate to be %t, got %t", tt.name, tt.duplicate, duplicate)
+		}
+	}
+}
+
+// TestUniqueWindow tests that jobs which are unique within a window duplicate jobs that were processed within it
+func TestUniqueWindow(t *testing.T) {
+	const queue = "unique_window"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	processed := make(chan bool, 1)
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		processed <- true
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))}
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: jobs.UniqueWithin(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(15 * time.Second):
+		t.Fatal("expected the job to be processed")
+	case <-processed:
+	}
+
+	// completed tasks aren't retained, so the job's unique key outlives it for its window
+	time.Sleep(100 * time.Millisecond)
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload, Unique: jobs.UniqueWithin(time.Hour)})
+	if !errors.Is(err, asynq.ErrDuplicateTask) {
+		t.Errorf("expected a job that's unique within an hour to duplicate a job processed within the hour, got: %v", err)
+	}
+}
+
+// TestUniqueRetention tests that jobs which are unique while active don't duplicate completed jobs, however long
+// completed jobs are retained
+func TestUniqueRetention(t *testing.T) {
+	const queue = "unique_retention"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond),
+		WithRetention(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))}
+	jid, err := nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(15 * time.Second); ; {
+		job, err := nq.GetJob(ctx, jid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job.Status == internal.JobStatusProcessed {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the job to be processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload})
+	if err != nil {
+		t.Errorf("expected a job that's unique while active not to duplicate a retained, completed job, got: %v", err)
+	}
+
+	if _, err = nq.GetJob(ctx, jid); err != nil {
+		t.Errorf("expected the completed job to be retained, got: %v", err)
+	}
+}
+
+// TestUniqueKeys tests that jobs with unique keys, or whose handlers have unique keys, are fingerprinted by the payload
+// fields named by their keys
+func TestUniqueKeys(t *testing.T) {
+	const queue = "unique_keys"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	h := handler.New(queue, func(_ context.Context) (err error) {
+		return
+	}, handler.Unique(jobs.UniqueWhile(jobs.UniqueWhileActive, "customer_id")))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	customerID := internal.RandInt(10000000000)
+	runAfter := time.Now().Add(time.Hour)
+	jobIDs, err := nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: queue, Payload: map[string]interface{}{"customer_id": customerID, "document_id": 1}, RunAfter: runAfter},
+		{Queue: queue, Payload: map[string]interface{}{"customer_id": customerID, "document_id": 2}, RunAfter: runAfter},
+		{Queue: queue, Payload: map[string]interface{}{"customer_id": customerID + 1, "document_id": 1}, RunAfter: runAfter},
+		{
+			Queue:    queue,
+			Payload:  map[string]interface{}{"customer_id": customerID, "document_id": customerID},
+			Unique:   jobs.UniqueWhile(jobs.UniqueWhileActive, "document_id"),
+			RunAfter: runAfter,
+		},
+		{
+			Queue:    queue,
+			Payload:  map[string]interface{}{"customer_id": customerID + 2, "document_id": customerID},
+			Unique:   jobs.UniqueWhile(jobs.UniqueWhileActive, "document_id"),
+			RunAfter: runAfter,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []bool{false, true, false, false, true}
+	for i, jobID := range jobIDs {
+		if duplicate := jobID == jobs.DuplicateJobID; duplicate != expected[i] {
+			t.Errorf("job %d: expected duplicate to be %t, got %t", i, expected[i], duplicate)
+		}
+	}
+}
+
+// TestFingerprints tests that jobs keep their fingerprints, which are independent of the task IDs that identify them
+func TestFingerprints(t *testing.T) {
+	const queue = "fingerprints"
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	done := make(chan string, 1)
+	release := make(chan bool)
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		done <- j.Fingerprint
+		<-release
+		return
+	})
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+
+	job := &jobs.Job{
+		Queue:   queue,
+		Payload: map[string]interface{}{"message": fmt.Sprintf("hello world: %d", internal.RandInt(10000000000))},
+	}
+	jobID, err := nq.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Fingerprint == "" || jobID == job.Fingerprint {
+		t.Fatalf("expected the job's ID to differ from its fingerprint, got ID: %s fingerprint: %s", jobID, job.Fingerprint)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal(jobs.ErrJobTimeout)
+	case fingerprint := <-done:
+		if fingerprint != job.Fingerprint {
+			t.Errorf("expected handler to observe fingerprint %s, got: %s", job.Fingerprint, fingerprint)
+		}
+	}
+
+	j, err := nq.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j.Fingerprint != job.Fingerprint || j.TaskID != jobID {
+		t.Errorf("expected job to have fingerprint %s and task ID %s, got: %s and %s", job.Fingerprint, jobID,
+			j.Fingerprint, j.TaskID)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	_, err = nq.Enqueue(ctx, &jobs.Job{
+		Queue:       "debounce",
+		Payload:     map[string]any{"name": "debounced"},
+		EnqueueMode: jobs.Debounce("doc:1", time.Second),
+	})
+	if !errors.Is(err, ErrEnqueueModesNotSupported) {
+		t.Errorf("expected debounced jobs to be rejected, got: %v", err)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	connString := os.Getenv("TEST_REDIS_URL")
+	if connString == "" {
+		t.Skip("Skipping: TEST_REDIS_URL not set")
+		return
+	}
+
+	ctx := context.TODO()
+	nq, err := neoq.New(
+		ctx,
+		neoq.WithBackend(Backend),
+		WithAddr(connString),
+		WithPassword(os.Getenv("REDIS_PASSWORD")),
+		WithShutdownTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+
+	_, err = nq.EnqueueMany(ctx, []*jobs.Job{
+		{Queue: "throttle", Payload: map[string]any{"name": "first"}},
+		{Queue: "throttle", Payload: map[string]any{"name": "throttled"}, EnqueueMode: jobs.Throttle("doc:1", time.Second)},
+	})
+	if !errors.Is(err, ErrEnqueueModesNotSupported) {
+		t.Errorf("expected throttled jobs to be rejected, got: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Package codec provides the encodings that neoq can use to store job payloads
+//
+// By default, job payloads are JSON objects. Codecs allow payloads to be stored in other encodings, e.g. protobuf
+// messages or raw binary blobs, without first converting them to JSON.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrUnsupportedType = errors.New("codec does not support this type")
+
+var (
+	// JSON encodes payloads as JSON. It is the default codec.
+	JSON Codec = jsonCodec{}
+	// Raw passes []byte payloads through unmodified
+	Raw Codec = rawCodec{}
+	// Protobuf encodes payloads that are protobuf messages
+	Protobuf Codec = protobufCodec{}
+	// Msgpack encodes payloads as MessagePack
+	Msgpack Codec = msgpackCodec{}
+)
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Codec{
+		JSON.Name():     JSON,
+		Raw.Name():      Raw,
+		Protobuf.Name(): Protobuf,
+		Msgpack.Name():  Msgpack,
+	}
+)
+
+// Codec encodes and decodes job payloads
+//
+// Every job records the name of the codec that encoded its payload, so that its payload can later be decoded by the
+// same codec.
+type Codec interface {
+	// Name uniquely identifies the codec
+	Name() string
+	// Marshal encodes v
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes data into v, which must be a pointer
+	Unmarshal(data []byte, v any) error
+}
+
+// Register makes a codec available for decoding job payloads by its name. Codecs registered with the name of an
+// existing codec replace the existing codec.
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry[c.Name()] = c
+}
+
+// Lookup finds the registered codec with the given name
+func Lookup(name string) (c Codec, ok bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c, ok = registry[name]
+	return
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		return []byte(b), nil
+	default:
+		return nil, fmt.Errorf("%w: %T is not []byte", ErrUnsupportedType, v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch b := v.(type) {
+	case *[]byte:
+		*b = append([]byte(nil), data...)
+	case *string:
+		*b = string(data)
+	default:
+		return fmt.Errorf("%w: %T is not *[]byte", ErrUnsupportedType, v)
+	}
+
+	return nil
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a proto.Message", ErrUnsupportedType, v)
+	}
+
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	// v may be a pointer to a (possibly nil) message pointer, e.g. when decoding the payloads of typed handlers
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && rv.Elem().Kind() == reflect.Pointer {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+
+		if m, ok := rv.Elem().Interface().(proto.Message); ok {
+			return proto.Unmarshal(data, m)
+		}
+	}
+
+	return fmt.Errorf("%w: %T is not a proto.Message", ErrUnsupportedType, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -5,10 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"runtime"
 	"runtime/debug"
 	"strings"
 	"time"
+
+	"github.com/acaloiaro/neoq/codec"
+	"github.com/acaloiaro/neoq/internal"
+	"github.com/acaloiaro/neoq/jobs"
 )
 
 const (
@@ -24,6 +29,13 @@ var (
 // Func is a function that Handlers execute for every Job on a queue
 type Func func(ctx context.Context) error
 
+// Middleware wraps Funcs with cross-cutting logic that runs around every job execution, e.g. logging or error
+// reporting. Middleware calls the Func that it wraps to continue executing the job.
+type Middleware func(Func) Func
+
+// TypedFunc is a function that typed Handlers execute for every Job on a queue, receiving the Job's decoded payload
+type TypedFunc[T any] func(ctx context.Context, payload T) error
+
 // Handler handles jobs on a queue
 type Handler struct {
 	Handle        Func
@@ -31,6 +43,45 @@ type Handler struct {
 	JobTimeout    time.Duration
 	QueueCapacity int64
 	Queue         string
+	Codec         codec.Codec      // the codec that decodes the payloads of typed handlers' jobs
+	Middleware    []Middleware     // middleware that wraps Handle, outermost first
+	MaxRetries    int              // the maximum number of times that jobs which don't set their own maximum are retried
+	RetryPolicy   jobs.RetryPolicy // how long failed jobs wait before retrying, when they don't set their own Backoff
+	RateLimit     *Rate            // how often jobs may be processed, across every process handling the queue
+	// GlobalConcurrency is the number of jobs that may be processed at once across every process handling the queue
+	GlobalConcurrency int
+	// Unique is how jobs on the queue that don't configure their own uniqueness are deduplicated
+	Unique *jobs.Unique
+}
+
+// Rate limits how often a queue's jobs are processed. See [RateLimit].
+type Rate struct {
+	Limit int                      // the number of jobs that may be processed every period
+	Per   time.Duration            // the period
+	Key   func(j *jobs.Job) string // divides jobs into groups that are limited separately, see [RateLimitKey]
+}
+
+// Interval is the time that it takes for the rate to allow one more job
+func (r *Rate) Interval() time.Duration {
+	if r.Limit <= 0 {
+		return r.Per
+	}
+
+	return r.Per / time.Duration(r.Limit)
+}
+
+// Bucket is the name of the token bucket that limits j, and whether j's bucket is limited separately by its key
+func (r *Rate) Bucket(queue string, j *jobs.Job) (bucket string, keyed bool) {
+	if r.Key == nil {
+		return queue, false
+	}
+
+	key := r.Key(j)
+	if key == "" {
+		return queue, false
+	}
+
+	return queue + ":" + key, true
 }
 
 // Option is function that sets optional configuration for Handlers
@@ -60,6 +111,17 @@ func Concurrency(c int) Option {
 	}
 }
 
+// GlobalConcurrency configures handlers to process at most n jobs at once across every process handling the queue,
+// whereas [Concurrency] is the number of jobs processed at once by each process
+//
+// Workers that find the queue at its global concurrency wait for a running job to finish before processing jobs. See
+// the backends' documentation for whether global concurrency is supported.
+func GlobalConcurrency(n int) Option {
+	return func(h *Handler) {
+		h.GlobalConcurrency = n
+	}
+}
+
 // MaxQueueCapacity configures Handlers to enforce a maximum capacity on the queues that it handles
 // queues that have reached capacity cause Enqueue() to block until the queue is below capacity
 func MaxQueueCapacity(capacity int64) Option {
@@ -75,6 +137,140 @@ func Queue(queue string) Option {
 	}
 }
 
+// Codec configures the codec with which typed handlers decode job payloads that do not record a codec, or that were
+// encoded by a codec of the same name. See [NewTyped].
+//
+// Codecs that are not registered with [codec.Register] may only be used by handlers configured with them.
+func Codec(c codec.Codec) Option {
+	return func(h *Handler) {
+		h.Codec = c
+	}
+}
+
+// WithMiddleware configures handlers with middleware that wraps every job execution
+//
+// Middleware is applied in the order it's given, i.e. the first middleware is the outermost. Backend-wide middleware,
+// configured with [pkg/github.com/acaloiaro/neoq.WithMiddleware], wraps handlers' middleware.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(h *Handler) {
+		h.Middleware = append(h.Middleware, mw...)
+	}
+}
+
+// MaxRetries configures the maximum number of times that jobs on the handler's queue are retried, when they don't set
+// their own MaxRetries. Use [jobs.NoRetries] to never retry them.
+func MaxRetries(n int) Option {
+	return func(h *Handler) {
+		h.MaxRetries = n
+	}
+}
+
+// RateLimit configures handlers to process at most n jobs every period, across every process handling the queue
+//
+// Jobs that exceed the limit wait for their turn without consuming retries. Workers wait for the queue's next job to
+// be allowed before processing it, unless jobs are limited by key. See [RateLimitKey].
+func RateLimit(n int, per time.Duration) Option {
+	return func(h *Handler) {
+		if h.RateLimit == nil {
+			h.RateLimit = &Rate{}
+		}
+
+		h.RateLimit.Limit = n
+		h.RateLimit.Per = per
+	}
+}
+
+// RateLimitKey configures rate-limited handlers to limit jobs separately by key, e.g. by a customer ID from jobs'
+// payloads. Jobs with empty keys share the queue's limit.
+//
+// Jobs with keys that exceed their limit are rescheduled for some time after their key is next allowed a job, so that
+// they don't hold up jobs with other keys.
+func RateLimitKey(key func(j *jobs.Job) string) Option {
+	return func(h *Handler) {
+		if h.RateLimit == nil {
+			h.RateLimit = &Rate{}
+		}
+
+		h.RateLimit.Key = key
+	}
+}
+
+// RateLimited determines whether the handler's jobs are rate limited. See [RateLimit].
+func (h Handler) RateLimited() bool {
+	return h.RateLimit != nil && h.RateLimit.Limit > 0 && h.RateLimit.Per > 0
+}
+
+// RateLimitDelay is the time that jobs limited by key are rescheduled for when their key has no tokens: the time until
+// the key's next token, plus jitter of up to one period so that the key's waiting jobs don't all retry at once
+func (h Handler) RateLimitDelay(wait time.Duration) time.Duration {
+	if h.RateLimit == nil || h.RateLimit.Per <= 0 {
+		return wait
+	}
+
+	return wait + time.Duration(rand.Int63n(int64(h.RateLimit.Per))) // nolint: gosec
+}
+
+// Unique configures how jobs on the handler's queue are deduplicated, when they don't configure their own uniqueness
+//
+// Uniqueness is applied when jobs are enqueued, so handlers' uniqueness only applies to jobs enqueued with backends that
+// the handlers were started with. Jobs enqueued by other processes configure their own uniqueness. See [jobs.Unique].
+func Unique(u *jobs.Unique) Option {
+	return func(h *Handler) {
+		h.Unique = u
+	}
+}
+
+// JobUnique is how j is deduplicated: its own uniqueness if set, otherwise the handler's uniqueness, if any
+func (h Handler) JobUnique(j *jobs.Job) *jobs.Unique {
+	if j.Unique != nil {
+		return j.Unique
+	}
+
+	return h.Unique
+}
+
+// JobMaxRetries is the maximum number of times that j may be retried: its own MaxRetries if set, otherwise the
+// handler's MaxRetries if set, otherwise [internal.DefaultMaxRetries]
+func (h Handler) JobMaxRetries(j *jobs.Job) int {
+	n := j.MaxRetries
+	if n == 0 {
+		n = h.MaxRetries
+	}
+
+	if n == 0 {
+		n = internal.DefaultMaxRetries
+	}
+
+	// jobs.NoRetries
+	if n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// RetryPolicy configures how long failed jobs on the handler's queue wait before they're retried, when they don't set
+// their own Backoff. See [jobs.ConstantBackoff], [jobs.LinearBackoff], [jobs.ExponentialBackoff], and
+// [jobs.RetryPolicyFunc].
+func RetryPolicy(p jobs.RetryPolicy) Option {
+	return func(h *Handler) {
+		h.RetryPolicy = p
+	}
+}
+
+// RetryDelay is the time that j waits before retrying, after failing with err: per j's Backoff if set, otherwise the
+// handler's RetryPolicy if set, otherwise [jobs.DefaultRetryPolicy]
+func (h Handler) RetryDelay(j *jobs.Job, err error) time.Duration {
+	policy := jobs.DefaultRetryPolicy
+	if j.Backoff != nil {
+		policy = j.Backoff
+	} else if h.RetryPolicy != nil {
+		policy = h.RetryPolicy
+	}
+
+	return policy.RetryDelay(j.Retries+1, err)
+}
+
 // New creates new queue handlers for specific queues. This function is to be usued to create new Handlers for
 // non-periodic jobs (most jobs). Use [NewPeriodic] to initialize handlers for periodic jobs.
 func New(queue string, f Func, opts ...Option) (h Handler) {
@@ -98,14 +294,47 @@ func New(queue string, f Func, opts ...Option) (h Handler) {
 	return
 }
 
+// NewTyped creates new queue handlers for jobs with payloads of type T. Jobs' payloads are decoded into T before f is
+// called. Use [jobs.NewTyped] to create jobs with JSON payloads of type T, or [jobs.NewEncoded] to create jobs with
+// payloads encoded by other codecs.
+//
+// Jobs with payloads that cannot be decoded into T fail with an error wrapping [jobs.ErrInvalidPayload], and are not
+// retried.
+func NewTyped[T any](queue string, f TypedFunc[T], opts ...Option) (h Handler) {
+	h = New(queue, nil, opts...)
+	c := h.Codec
+	h.Handle = func(ctx context.Context) (err error) {
+		var j *jobs.Job
+		j, err = jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		var payload T
+		err = j.DecodePayloadWith(c, &payload)
+		if err != nil {
+			return
+		}
+
+		return f(ctx, payload)
+	}
+
+	return
+}
+
 // NewPeriodic creates new queue handlers for periodic jobs.  Use [New] to initialize handlers for non-periodic jobs.
 func NewPeriodic(f Func, opts ...Option) (h Handler) {
 	h = New("", f, opts...)
 	return
 }
 
-// Exec executes handler functions with a concrete timeout
+// Exec executes handler functions, wrapped in their middleware, with a concrete timeout
 func Exec(ctx context.Context, handler Handler) (err error) {
+	handle := handler.Handle
+	for i := len(handler.Middleware) - 1; i >= 0; i-- {
+		handle = handler.Middleware[i](handle)
+	}
+
 	timeoutCtx, cancel := context.WithTimeout(ctx, handler.JobTimeout)
 	defer cancel()
 
@@ -134,13 +363,19 @@ func Exec(ctx context.Context, handler Handler) (err error) {
 			done <- true
 		}()
 
-		errCh <- handler.Handle(ctx)
+		errCh <- handle(ctx)
 	}(ctx)
 
 	select {
 	case <-done:
 		err = <-errCh
-		if err != nil {
+		if _, snoozed := jobs.Snoozed(err); snoozed {
+			break
+		}
+
+		if jobs.IsPermanent(err) {
+			err = fmt.Errorf("job failed permanently: %w", err)
+		} else if err != nil {
 			err = fmt.Errorf("job failed to process: %w", err)
 		}
 
@@ -9,21 +9,31 @@ import (
 
 type contextKey struct{}
 
+type resultWriterContextKey struct{}
+
 const (
 	JobStatusNew       = "new"
 	JobStatusProcessed = "processed"
 	JobStatusFailed    = "failed"
+	JobStatusWaiting   = "waiting"   // jobs that are waiting for the jobs that they depend on to be processed
+	JobStatusCancelled = "cancelled" // jobs that were cancelled because jobs that they depend on died
+
+	// DefaultMaxRetries is the number of times jobs are retried when they do not specify their own maximum
+	DefaultMaxRetries = 23
 )
 
-var JobCtxVarKey contextKey
+var (
+	JobCtxVarKey          contextKey
+	ResultWriterCtxVarKey resultWriterContextKey
+)
 
 // CalculateBackoff calculates the number of seconds to back off before the next retry
 // this formula is unabashedly taken from Sidekiq because it is good.
-func CalculateBackoff(retryCount int) time.Time {
+func CalculateBackoff(retryCount int) time.Duration {
 	const backoffExponent = 4
 	const maxInt = 30
 	p := int(math.Round(math.Pow(float64(retryCount), backoffExponent)))
-	return time.Now().UTC().Add(time.Duration(p+15+RandInt(maxInt)*retryCount+1) * time.Second)
+	return time.Duration(p+15+RandInt(maxInt)*retryCount+1) * time.Second
 }
 
 // RandInt returns a random integer up to max
@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BatchPayloadKey is the key of the batch summary, a [BatchStatus], in the payloads of batch callback jobs
+const BatchPayloadKey = "batch"
+
+// ErrBatchNotFound indicates that no batch with a given ID is known to a backend
+var ErrBatchNotFound = errors.New("batch not found")
+
+// Batch is a group of jobs whose completion is tracked together
+type Batch struct {
+	// The jobs in the batch. Jobs that are duplicates are not queued, and are not part of the batch.
+	Jobs []*Job
+	// The job that is queued once every job in the batch has been processed or died, with a summary of the batch in its
+	// payload under [BatchPayloadKey], alongside the callback's own payload. Callbacks' payloads must be JSON. See
+	// [BatchFromCallback].
+	Callback *Job
+}
+
+// BatchStatus summarizes the state of a batch
+type BatchStatus struct {
+	ID            string     `json:"id"`
+	Total         int        `json:"total"`                     // The number of jobs in the batch
+	Succeeded     int        `json:"succeeded"`                 // The number of jobs that were processed successfully
+	Dead          int        `json:"dead"`                      // The number of jobs that died, or were cancelled
+	CallbackJobID string     `json:"callback_job_id,omitempty"` // The ID of the batch's callback job, once it's queued
+	CreatedAt     time.Time  `json:"created_at"`                // The time the batch was created
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`    // The time that every job in the batch was terminal
+}
+
+// Pending is the number of jobs in the batch that have neither been processed nor died
+func (s *BatchStatus) Pending() int {
+	return s.Total - s.Succeeded - s.Dead
+}
+
+// Done reports whether every job in the batch has been processed or died
+func (s *BatchStatus) Done() bool {
+	return s.CompletedAt != nil
+}
+
+// BatchFromCallback gets the summary of the batch that a callback job was queued for from the job's payload
+func BatchFromCallback(j *Job) (status *BatchStatus, err error) {
+	var payload struct {
+		Batch *BatchStatus `json:"batch"`
+	}
+
+	err = j.DecodePayload(&payload)
+	if err != nil {
+		return
+	}
+
+	if payload.Batch == nil {
+		return nil, fmt.Errorf("%w: job %d is not a batch callback", ErrBatchNotFound, j.ID)
+	}
+
+	return payload.Batch, nil
+}
+
+// NewBatchCallback prepares a batch's callback job to be queued with the summary of the batch in its payload
+func NewBatchCallback(callback *Job, status BatchStatus) *Job {
+	job := *callback
+	job.ID = 0
+	job.Fingerprint = ""
+	job.Payload = map[string]any{BatchPayloadKey: status}
+	for k, v := range callback.Payload {
+		if k != BatchPayloadKey {
+			job.Payload[k] = v
+		}
+	}
+
+	return &job
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrBatchedEnqueueMode indicates that a batch contains jobs with enqueue modes, which may not be batched
+var ErrBatchedEnqueueMode = errors.New("jobs with enqueue modes may not be batched")
+
+// EnqueueStrategy is what happens to jobs with an enqueue mode when a pending job has the same key
+type EnqueueStrategy int
+
+const (
+	// Debounced jobs replace the payloads and metadata of pending jobs with the same key, and push back their RunAfter
+	Debounced EnqueueStrategy = iota
+	// Throttled jobs are dropped when a pending job has the same key
+	Throttled
+)
+
+// EnqueueMode coalesces jobs with the same key on the same queue while one of them is pending, i.e. scheduled but not
+// yet due. Jobs with enqueue modes run Window after they're enqueued, regardless of their RunAfter.
+//
+// Jobs with enqueue modes are fingerprinted by their keys, and are not otherwise deduplicated. See [Unique].
+type EnqueueMode struct {
+	Strategy EnqueueStrategy
+	Key      string
+	Window   time.Duration
+}
+
+// Debounce runs jobs window after the last job with the same key is enqueued, with the last job's payload
+//
+// Enqueueing a job while a job with the same key is pending replaces the pending job's payload and metadata, and pushes
+// back its RunAfter to window after the job is enqueued. The pending job's ID is returned.
+func Debounce(key string, window time.Duration) *EnqueueMode {
+	return &EnqueueMode{Strategy: Debounced, Key: key, Window: window}
+}
+
+// Throttle runs jobs window after the first job with the same key is enqueued, with the first job's payload
+//
+// Jobs that are enqueued while a job with the same key is pending are dropped, as duplicates of the pending job.
+func Throttle(key string, window time.Duration) *EnqueueMode {
+	return &EnqueueMode{Strategy: Throttled, Key: key, Window: window}
+}
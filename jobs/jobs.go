@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5" // nolint: gosec
 	"encoding/json"
@@ -9,6 +10,7 @@ import (
 	"io"
 	"time"
 
+	"github.com/acaloiaro/neoq/codec"
 	"github.com/acaloiaro/neoq/internal"
 	"github.com/guregu/null"
 )
@@ -18,11 +20,84 @@ var (
 	ErrJobTimeout          = errors.New("timed out waiting for job(s)")
 	ErrNoQueueSpecified    = errors.New("this job does not specify a queue. please specify a queue")
 	ErrJobExceededDeadline = errors.New("the job did not complete before its deadline")
+	ErrJobNotFound         = errors.New("job not found")
+	ErrInvalidPayload      = errors.New("job payload is invalid")
+	ErrDependencyFailed    = errors.New("a job that this job depends on died")
 )
 
+// Permanent wraps err to indicate that the job failed permanently. Jobs that fail permanently are not retried, and are
+// moved to the dead queue with their errors recorded.
+//
+// Permanent returns nil when err is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// IsPermanent determines whether err is permanent, i.e. it wraps an error returned by [Permanent],
+// [ErrInvalidPayload], or [ErrJobExceededDeadline]
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe) || errors.Is(err, ErrInvalidPayload) || errors.Is(err, ErrJobExceededDeadline)
+}
+
+// Snooze is returned by handlers to run their jobs again after d, e.g. when an external system isn't ready yet.
+// Snoozing jobs does not count as a failure or a retry.
+func Snooze(d time.Duration) error {
+	return &snoozeError{d: d}
+}
+
+// Snoozed determines whether err was returned by [Snooze], and if so, for how long the job was snoozed
+func Snoozed(err error) (d time.Duration, ok bool) {
+	var se *snoozeError
+	if errors.As(err, &se) {
+		return se.d, true
+	}
+
+	return
+}
+
+type snoozeError struct {
+	d time.Duration
+}
+
+func (e *snoozeError) Error() string {
+	return fmt.Sprintf("job snoozed for %s", e.d)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
 const (
 	DuplicateJobID = "-1"
 	UnqueuedJobID  = "-2"
+
+	// NoRetries is the MaxRetries of jobs that are never retried
+	NoRetries = -1
+)
+
+// DependencyPolicy determines what happens to jobs that are waiting for other jobs when the jobs that they depend on
+// die. See [Job.DependsOn].
+type DependencyPolicy int
+
+const (
+	// CancelDependents cancels jobs when jobs that they depend on die. Cancelled jobs are never processed.
+	CancelDependents DependencyPolicy = iota
+	// FailDependents moves jobs to the dead queue when jobs that they depend on die, from which they may be requeued
+	// once the jobs that they depend on are requeued
+	FailDependents
 )
 
 // Job contains all the data pertaining to jobs
@@ -30,6 +105,9 @@ const (
 // Jobs are what are placed on queues for processing.
 //
 // The Fingerprint field can be supplied by the user to impact job deduplication.
+//
+// Jobs that don't set MaxRetries may retry the number of times configured by their queue's handler, or the default
+// number of times if their handler doesn't configure it.
 // TODO Factor out usage of the null package: github.com/guregu/null
 type Job struct {
 	ID          int64          `db:"id"`
@@ -37,27 +115,194 @@ type Job struct {
 	Status      string         `db:"status"`      // The status of the job
 	Queue       string         `db:"queue"`       // The queue the job is on
 	Payload     map[string]any `db:"payload"`     // JSON job payload for more complex jobs
+	RawPayload  []byte         `db:"raw_payload"` // Job payload encoded by Codec, for payloads that are not JSON objects
+	Codec       string         `db:"codec"`       // The name of the codec that encoded the job's payload
 	Deadline    *time.Time     `db:"deadline"`    // The time after which the job should no longer be run
 	RunAfter    time.Time      `db:"run_after"`   // The time after which the job is elligible to be picked up by a worker
 	RanAt       null.Time      `db:"ran_at"`      // The last time the job ran
 	Error       null.String    `db:"error"`       // The last error the job elicited
 	Retries     int            `db:"retries"`     // The number of times the job has retried
-	MaxRetries  int            `db:"max_retries"` // The maximum number of times the job can retry
+	MaxRetries  int            `db:"max_retries"` // The maximum number of times the job can retry, see [NoRetries]
 	CreatedAt   time.Time      `db:"created_at"`  // The time the job was created
+	// The trace context of the context that enqueued the job, which handlers' spans are children of
+	TraceContext map[string]string `db:"trace_context"`
+	// Headers that describe the job's envelope rather than its payload, e.g. request or tenant IDs. Metadata does not
+	// affect job deduplication.
+	Metadata map[string]string `db:"metadata"`
+	// The job's retry policy, which takes precedence over its handler's retry policy
+	Backoff *Backoff `db:"backoff"`
+	// The number of times the job's handler has snoozed it. Snoozes are not recorded by the Redis backend.
+	Snoozes int `db:"snoozes"`
+	// Jobs with higher priorities are processed before jobs with lower priorities. Jobs' priorities default to 0 and
+	// may be negative. See [pkg/github.com/acaloiaro/neoq.WithPriorityAging].
+	Priority int `db:"priority"`
+	// The job's result, as written by its handler. See [WriteResult].
+	Result []byte `db:"result"`
+	// How far along the job is, as written by its handler. See [WriteProgress].
+	Progress *Progress `db:"progress"`
+	// The IDs of jobs, as returned by Enqueue, that must be processed before the job is processed. Jobs wait for the
+	// jobs that they depend on, and are cancelled or failed if any of them die. See [DependencyPolicy].
+	DependsOn []string `db:"depends_on"`
+	// The ID of the batch that the job belongs to, if any. See [Batch].
+	BatchID string `db:"batch_id"`
+	// How the job is deduplicated, which takes precedence over its handler's uniqueness. Uniqueness is only applied when
+	// jobs are enqueued, and is not stored with them. See [Unique].
+	Unique *Unique `db:"-"`
+	// How the job is coalesced with pending jobs that have the same key, which is only applied when jobs are enqueued, and
+	// is not stored with them. See [Debounce] and [Throttle].
+	EnqueueMode *EnqueueMode `db:"-"`
+	// The ID of the job's task, as returned by Enqueue, for the Redis backend, which identifies jobs by task IDs rather
+	// than by ID
+	TaskID string `db:"-"`
+}
+
+// NewTyped creates a new job on the specified queue, with its payload JSON-encoded from payload
+//
+// Jobs created with NewTyped are intended to be handled by [pkg/github.com/acaloiaro/neoq/handler.NewTyped] handlers
+// of the same type.
+func NewTyped[T any](queue string, payload T) (job *Job, err error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+
+	var p map[string]any
+	// decode numbers as json.Number so that integers survive the round trip to and from map[string]any
+	d := json.NewDecoder(bytes.NewReader(js))
+	d.UseNumber()
+	err = d.Decode(&p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: payloads must encode to JSON objects: %w", ErrInvalidPayload, err)
+	}
+
+	job = &Job{
+		Queue:   queue,
+		Payload: p,
+	}
+
+	return
+}
+
+// NewEncoded creates a new job on the specified queue, with its payload encoded from payload by c
+//
+// Use NewEncoded for payloads that are not JSON objects, e.g. protobuf messages ([codec.Protobuf]) or binary blobs
+// ([codec.Raw]).
+func NewEncoded(queue string, payload any, c codec.Codec) (job *Job, err error) {
+	raw, err := c.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+
+	job = &Job{
+		Queue:      queue,
+		RawPayload: raw,
+		Codec:      c.Name(),
+	}
+
+	return
+}
+
+// EncodePayload prepares job payloads to be stored by backends, and records the codec that encoded them on the job
+//
+// JSON object payloads are left as-is when c is [codec.JSON]. With any other codec, they are encoded to RawPayload by
+// c. RawPayloads without a codec are assumed to already be encoded by c. When c is nil, [codec.JSON] is used.
+func EncodePayload(j *Job, c codec.Codec) (err error) {
+	if c == nil {
+		c = codec.JSON
+	}
+
+	switch {
+	case j.RawPayload != nil:
+		if j.Codec == "" {
+			j.Codec = c.Name()
+		}
+	case j.Payload != nil && c.Name() != codec.JSON.Name():
+		j.RawPayload, err = c.Marshal(j.Payload)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+		}
+		j.Payload = nil
+		j.Codec = c.Name()
+	default:
+		j.Codec = codec.JSON.Name()
+	}
+
+	return
+}
+
+// DecodePayload decodes the job's payload into v, which must be a pointer
+//
+// Payloads that cannot be decoded into v result in errors wrapping [ErrInvalidPayload]
+func (j *Job) DecodePayload(v any) (err error) {
+	return j.DecodePayloadWith(nil, v)
 }
 
-// FingerprintJob fingerprints jobs as an md5 hash of its queue combined with its JSON-serialized payload
+// DecodePayloadWith decodes the job's payload into v, which must be a pointer
+//
+// c decodes the payload when it's the codec that encoded the payload, or when the job does not record its codec.
+// Otherwise, the payload is decoded by the registered codec with the name recorded on the job. See [codec.Register].
+//
+// Payloads that cannot be decoded into v result in errors wrapping [ErrInvalidPayload]
+func (j *Job) DecodePayloadWith(c codec.Codec, v any) (err error) {
+	if j.RawPayload != nil {
+		name := j.Codec
+		if name == "" && c != nil {
+			name = c.Name()
+		}
+
+		if c == nil || c.Name() != name {
+			var ok bool
+			if c, ok = codec.Lookup(name); !ok {
+				return fmt.Errorf("%w: no codec named '%s' is registered", ErrInvalidPayload, name)
+			}
+		}
+
+		err = c.Unmarshal(j.RawPayload, v)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+		}
+
+		return
+	}
+
+	js, err := json.Marshal(j.Payload)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+
+	err = json.Unmarshal(js, v)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+
+	return
+}
+
+// FingerprintJob fingerprints jobs as an md5 hash of its queue combined with its encoded payload
+//
+// Jobs with a RawPayload are fingerprinted by their encoded bytes, otherwise by their JSON-serialized payload. Job
+// metadata is not fingerprinted, so jobs with the same payload but different metadata are duplicates. Jobs with unique
+// keys are fingerprinted by the payload fields named by their keys, rather than their entire payloads. See [Unique].
+// Jobs with enqueue modes are fingerprinted by their keys. See [EnqueueMode].
 func FingerprintJob(j *Job) (err error) {
 	// only generate a fingerprint if the job is not already fingerprinted
 	if j.Fingerprint != "" {
 		return
 	}
 
-	var js []byte
-	js, err = json.Marshal(j.Payload)
+	js := j.RawPayload
+	switch {
+	case j.EnqueueMode != nil:
+		js = []byte("enqueue_mode:" + j.EnqueueMode.Key)
+	case j.Unique != nil && len(j.Unique.Keys) > 0:
+		js, err = j.uniquePayload()
+	case js == nil:
+		js, err = json.Marshal(j.Payload)
+	}
 	if err != nil {
 		return
 	}
+
 	h := md5.New() // nolint: gosec
 	_, err = io.WriteString(h, j.Queue)
 	if err != nil {
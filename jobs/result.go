@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/acaloiaro/neoq/internal"
+)
+
+// ErrContextHasNoResultWriter indicates that a context does not belong to a job that's being processed
+var ErrContextHasNoResultWriter = errors.New("context has no ResultWriter")
+
+// Progress reports how far along a job is
+type Progress struct {
+	Percent int    `json:"percent"`           // The percentage of the job that's complete, from 0 to 100
+	Message string `json:"message,omitempty"` // Describes the job's progress, e.g. "8,400 of 20,000 rows imported"
+}
+
+// ResultWriter persists what jobs produced, and how far along they are, while their handlers run
+//
+// Backends make a ResultWriter available to handlers through their contexts. See [WriteResult] and [WriteProgress].
+type ResultWriter interface {
+	// WriteResult persists the job's result, replacing any result that was written before
+	WriteResult(ctx context.Context, result []byte) error
+	// WriteProgress persists the job's progress, replacing any progress that was written before
+	WriteProgress(ctx context.Context, progress Progress) error
+}
+
+// WithResultWriter creates a new context with the ResultWriter of the job that is being processed set
+func WithResultWriter(ctx context.Context, w ResultWriter) context.Context {
+	return context.WithValue(ctx, internal.ResultWriterCtxVarKey, w)
+}
+
+// ResultWriterFromContext gets the ResultWriter of the job that is being processed from the context
+func ResultWriterFromContext(ctx context.Context) (w ResultWriter, err error) {
+	var ok bool
+	if w, ok = ctx.Value(internal.ResultWriterCtxVarKey).(ResultWriter); ok {
+		return
+	}
+
+	return nil, ErrContextHasNoResultWriter
+}
+
+// WriteResult persists the result of the job that is being processed, which can later be looked up with the job
+func WriteResult(ctx context.Context, result []byte) (err error) {
+	w, err := ResultWriterFromContext(ctx)
+	if err != nil {
+		return
+	}
+
+	return w.WriteResult(ctx, result)
+}
+
+// WriteProgress persists the progress of the job that is being processed, which can later be looked up with the job.
+// Percentages outside of 0 to 100 are clamped.
+func WriteProgress(ctx context.Context, percent int, message string) (err error) {
+	w, err := ResultWriterFromContext(ctx)
+	if err != nil {
+		return
+	}
+
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 { // nolint: gomnd
+		percent = 100
+	}
+
+	return w.WriteProgress(ctx, Progress{Percent: percent, Message: message})
+}
@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"math"
+	"time"
+
+	"github.com/acaloiaro/neoq/internal"
+)
+
+// BackoffStrategy is the way in which a Backoff's delays grow with the number of attempts
+type BackoffStrategy string
+
+const (
+	BackoffConstant    BackoffStrategy = "constant"    // every delay is the same
+	BackoffLinear      BackoffStrategy = "linear"      // delays grow by the initial delay with every attempt
+	BackoffExponential BackoffStrategy = "exponential" // delays double with every attempt
+)
+
+// DefaultRetryPolicy backs off polynomially, with jitter, from 16 seconds after the first attempt to many days after
+// the 23rd. It's used when neither jobs nor their handlers configure retry policies.
+var DefaultRetryPolicy RetryPolicy = RetryPolicyFunc(func(attempt int, _ error) time.Duration {
+	return internal.CalculateBackoff(attempt - 1)
+})
+
+// RetryPolicy determines how long failed jobs wait before they're retried
+type RetryPolicy interface {
+	// RetryDelay is the time that jobs wait before retrying, after their attempt-th attempt, starting at 1, fails with
+	// err
+	RetryDelay(attempt int, err error) time.Duration
+}
+
+// RetryPolicyFunc is a custom RetryPolicy that is a function of jobs' attempts and errors
+type RetryPolicyFunc func(attempt int, err error) time.Duration
+
+// RetryDelay calls f(attempt, err)
+func (f RetryPolicyFunc) RetryDelay(attempt int, err error) time.Duration {
+	return f(attempt, err)
+}
+
+// Backoff is a RetryPolicy with delays that are constant, or that grow linearly or exponentially with the number of
+// attempts, up to a maximum delay
+//
+// Unlike custom retry policies, Backoffs can be stored alongside jobs. See [Job.Backoff].
+type Backoff struct {
+	Strategy BackoffStrategy `json:"strategy"`
+	Delay    time.Duration   `json:"delay"`         // the delay after the first attempt
+	Max      time.Duration   `json:"max,omitempty"` // the longest delay, if not zero
+}
+
+// ConstantBackoff retries jobs after the same delay following every attempt
+func ConstantBackoff(delay time.Duration) *Backoff {
+	return &Backoff{Strategy: BackoffConstant, Delay: delay}
+}
+
+// LinearBackoff retries jobs after delay following the first attempt, 2*delay following the second, and so on, up to
+// max. A max of zero does not limit delays.
+func LinearBackoff(delay, max time.Duration) *Backoff {
+	return &Backoff{Strategy: BackoffLinear, Delay: delay, Max: max}
+}
+
+// ExponentialBackoff retries jobs after delay following the first attempt, doubling the delay after every subsequent
+// attempt, up to max. A max of zero does not limit delays.
+func ExponentialBackoff(delay, max time.Duration) *Backoff {
+	return &Backoff{Strategy: BackoffExponential, Delay: delay, Max: max}
+}
+
+// RetryDelay is the delay following jobs' attempt-th attempt
+func (b *Backoff) RetryDelay(attempt int, _ error) (delay time.Duration) {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay = b.Delay
+	switch b.Strategy {
+	case BackoffLinear:
+		if delay > 0 && attempt > math.MaxInt64/int(delay) {
+			delay = math.MaxInt64
+		} else {
+			delay *= time.Duration(attempt)
+		}
+	case BackoffExponential:
+		for i := 1; i < attempt && delay > 0 && (b.Max == 0 || delay < b.Max); i++ {
+			if delay > math.MaxInt64/2 {
+				delay = math.MaxInt64
+				break
+			}
+			delay *= 2
+		}
+	case BackoffConstant:
+	}
+
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+
+	return
+}
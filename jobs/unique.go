@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UniqueScope determines which of the existing jobs with the same fingerprint a job duplicates. Duplicate jobs are not
+// enqueued. See [FingerprintJob].
+type UniqueScope int
+
+const (
+	// UniqueWhileActive jobs duplicate jobs that are queued or being processed. It's the scope of jobs that don't
+	// configure their uniqueness.
+	UniqueWhileActive UniqueScope = iota
+	// UniqueWhileQueued jobs duplicate jobs that are queued, but not jobs that are being processed
+	UniqueWhileQueued
+	// UniqueWithinWindow jobs duplicate jobs that are queued or being processed, and jobs that were processed within
+	// their window, e.g. at most one job per hour
+	UniqueWithinWindow
+	// NotUnique jobs never duplicate other jobs
+	NotUnique
+)
+
+// Unique configures how jobs are deduplicated
+//
+// Jobs that don't configure their uniqueness use their handler's, if any. See
+// [pkg/github.com/acaloiaro/neoq/handler.Unique].
+type Unique struct {
+	Scope  UniqueScope   `json:"scope"`
+	Window time.Duration `json:"window,omitempty"` // how long processed jobs are duplicated for, with UniqueWithinWindow
+	// The payload fields that jobs are fingerprinted by, e.g. a customer ID. Jobs are fingerprinted by their entire
+	// payloads when Keys is empty.
+	Keys []string `json:"keys,omitempty"`
+}
+
+// UniqueWhile deduplicates jobs with the given scope, fingerprinting them by the payload fields keys, if any
+func UniqueWhile(scope UniqueScope, keys ...string) *Unique {
+	return &Unique{Scope: scope, Keys: keys}
+}
+
+// UniqueWithin deduplicates jobs that are queued, being processed, or were processed within window, fingerprinting
+// them by the payload fields keys, if any
+func UniqueWithin(window time.Duration, keys ...string) *Unique {
+	return &Unique{Scope: UniqueWithinWindow, Window: window, Keys: keys}
+}
+
+// UniqueScope is the job's uniqueness scope, which is [UniqueWhileActive] when the job doesn't configure its uniqueness
+func (j *Job) UniqueScope() UniqueScope {
+	if j.Unique == nil {
+		return UniqueWhileActive
+	}
+
+	return j.Unique.Scope
+}
+
+// UniqueWindow is how long after being processed that jobs with the same fingerprint duplicate the job, which is zero
+// unless the job's scope is [UniqueWithinWindow]
+func (j *Job) UniqueWindow() time.Duration {
+	if j.UniqueScope() != UniqueWithinWindow {
+		return 0
+	}
+
+	return j.Unique.Window
+}
+
+// uniquePayload is the JSON encoding of the payload fields named by the job's unique keys
+//
+// Raw payloads are decoded by the codec that encoded them, and must be objects.
+func (j *Job) uniquePayload() (js []byte, err error) {
+	payload := j.Payload
+	if j.RawPayload != nil {
+		err = j.DecodePayload(&payload)
+		if err != nil {
+			return
+		}
+	}
+
+	fields := make(map[string]any, len(j.Unique.Keys))
+	for _, key := range j.Unique.Keys {
+		if v, ok := payload[key]; ok {
+			fields[key] = v
+		}
+	}
+
+	return json.Marshal(fields)
+}
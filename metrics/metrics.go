@@ -0,0 +1,265 @@
+// Package metrics reports Prometheus metrics for neoq queues and workers
+//
+// Metrics are collected by instrumenting backends with [Metrics.Instrument], and are exposed by [Metrics.Handler] in
+// the Prometheus exposition format.
+//
+//	m, _ := metrics.New()
+//	nq, _ := neoq.New(ctx, neoq.WithBackend(postgres.Backend), m.Instrument())
+//	m.Observe(nq)
+//	http.Handle("/metrics", m.Handler())
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/acaloiaro/neoq"
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "neoq"
+	// DefaultStatsTimeout is the longest that collecting queue stats may take during a scrape
+	DefaultStatsTimeout = 5 * time.Second
+)
+
+// Metrics collects metrics for a neoq backend
+type Metrics struct {
+	registry     *prometheus.Registry
+	buckets      []float64
+	statsTimeout time.Duration
+
+	enqueued  *prometheus.CounterVec
+	succeeded *prometheus.CounterVec
+	failed    *prometheus.CounterVec
+	retried   *prometheus.CounterVec
+	dead      *prometheus.CounterVec
+	skipped   *prometheus.CounterVec
+	snoozed   *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	inFlight  *prometheus.GaugeVec
+
+	mu      *sync.RWMutex
+	backend neoq.Neoq
+}
+
+// Option is a function that sets optional configuration for Metrics
+type Option func(m *Metrics)
+
+// WithRegistry configures the registry that metrics are registered with, and exposed from. By default, metrics are
+// registered with a new registry.
+func WithRegistry(registry *prometheus.Registry) Option {
+	return func(m *Metrics) {
+		m.registry = registry
+	}
+}
+
+// WithBuckets configures the buckets of the job duration histogram, in seconds. By default,
+// [prometheus.DefBuckets] are used.
+func WithBuckets(buckets []float64) Option {
+	return func(m *Metrics) {
+		m.buckets = buckets
+	}
+}
+
+// WithStatsTimeout configures the longest that collecting queue stats may take during a scrape
+func WithStatsTimeout(timeout time.Duration) Option {
+	return func(m *Metrics) {
+		m.statsTimeout = timeout
+	}
+}
+
+// New creates new Metrics, registering its collectors with its registry
+func New(opts ...Option) (m *Metrics, err error) {
+	m = &Metrics{
+		registry:     prometheus.NewRegistry(),
+		buckets:      prometheus.DefBuckets,
+		statsTimeout: DefaultStatsTimeout,
+		mu:           &sync.RWMutex{},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	m.enqueued = newCounterVec("jobs_enqueued_total", "The number of jobs enqueued.")
+	m.succeeded = newCounterVec("jobs_processed_total", "The number of jobs processed successfully.")
+	m.failed = newCounterVec("jobs_failed_total", "The number of job executions that failed.")
+	m.retried = newCounterVec("jobs_retried_total", "The number of failed jobs scheduled to be retried.")
+	m.dead = newCounterVec("jobs_dead_total", "The number of jobs moved to the dead queue.")
+	m.skipped = newCounterVec("jobs_skipped_total", "The number of jobs skipped because their deadlines had passed.")
+	m.snoozed = newCounterVec("jobs_snoozed_total", "The number of times that handlers snoozed their jobs.")
+	m.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "The time that job handlers spent running.",
+		Buckets:   m.buckets,
+	}, []string{"queue", "status"})
+	m.inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "jobs_in_flight",
+		Help:      "The number of jobs that handlers are currently running.",
+	}, []string{"queue"})
+
+	collectors := []prometheus.Collector{
+		m.enqueued, m.succeeded, m.failed, m.retried, m.dead, m.skipped, m.snoozed, m.duration, m.inFlight,
+		&statsCollector{metrics: m},
+	}
+	for _, c := range collectors {
+		if err = m.registry.Register(c); err != nil {
+			return nil, fmt.Errorf("unable to register metrics: %w", err)
+		}
+	}
+
+	return
+}
+
+// Instrument is a [neoq.ConfigOption] that instruments backends to report job metrics
+func (m *Metrics) Instrument() neoq.ConfigOption {
+	return func(c *neoq.Config) {
+		neoq.WithEnqueueInterceptor(m.interceptEnqueue)(c)
+		neoq.WithOnJobStarted(m.jobStarted)(c)
+		neoq.WithOnJobSucceeded(m.jobFinished)(c)
+		neoq.WithOnJobFailed(m.jobFinished)(c)
+		neoq.WithOnJobRetried(m.countEvent(m.retried))(c)
+		neoq.WithOnJobDead(m.countEvent(m.dead))(c)
+		neoq.WithOnJobSkipped(m.countEvent(m.skipped))(c)
+		neoq.WithOnJobSnoozed(m.jobSnoozed)(c)
+	}
+}
+
+// Observe reports the queue stats of nq, e.g. queue depth and the age of the oldest pending job, when metrics are
+// scraped. See [neoq.Neoq.QueueStats].
+func (m *Metrics) Observe(nq neoq.Neoq) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.backend = nq
+}
+
+// Handler exposes metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) interceptEnqueue(ctx context.Context, job *jobs.Job, next neoq.EnqueueFunc) (jobID string,
+	err error,
+) {
+	jobID, err = next(ctx, job)
+	if err == nil && jobID != jobs.DuplicateJobID {
+		m.enqueued.WithLabelValues(job.Queue).Inc()
+	}
+
+	return
+}
+
+func (m *Metrics) jobStarted(_ context.Context, event neoq.JobEvent) {
+	m.inFlight.WithLabelValues(event.Job.Queue).Inc()
+}
+
+func (m *Metrics) jobFinished(_ context.Context, event neoq.JobEvent) {
+	status := "succeeded"
+	counter := m.succeeded
+	if event.Type == neoq.JobFailed {
+		status = "failed"
+		counter = m.failed
+	}
+
+	m.inFlight.WithLabelValues(event.Job.Queue).Dec()
+	counter.WithLabelValues(event.Job.Queue).Inc()
+	m.duration.WithLabelValues(event.Job.Queue, status).Observe(event.Duration.Seconds())
+}
+
+func (m *Metrics) jobSnoozed(_ context.Context, event neoq.JobEvent) {
+	m.inFlight.WithLabelValues(event.Job.Queue).Dec()
+	m.snoozed.WithLabelValues(event.Job.Queue).Inc()
+}
+
+func (m *Metrics) countEvent(counter *prometheus.CounterVec) neoq.JobHook {
+	return func(_ context.Context, event neoq.JobEvent) {
+		counter.WithLabelValues(event.Job.Queue).Inc()
+	}
+}
+
+func newCounterVec(name, help string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, []string{"queue"})
+}
+
+// statsCollector collects the queue stats of the observed backend when metrics are scraped
+type statsCollector struct {
+	metrics *Metrics
+}
+
+var (
+	pendingDesc = prometheus.NewDesc(namespace+"_queue_pending_jobs",
+		"The number of jobs that are due to be processed, but have not yet been processed.", []string{"queue"}, nil)
+	oldestPendingDesc = prometheus.NewDesc(namespace+"_queue_oldest_pending_job_age_seconds",
+		"The time since the oldest pending job became due.", []string{"queue"}, nil)
+	concurrencyDesc = prometheus.NewDesc(namespace+"_handler_concurrency",
+		"The number of jobs that the queue's handler processes concurrently.", []string{"queue"}, nil)
+	notificationsDesc = prometheus.NewDesc(namespace+"_listener_notifications_total",
+		"The number of new job notifications received by the queue's listener.", []string{"queue"}, nil)
+	reconnectsDesc = prometheus.NewDesc(namespace+"_listener_reconnects_total",
+		"The number of times the queue's listener has reconnected.", []string{"queue"}, nil)
+	globalConcurrencyDesc = prometheus.NewDesc(namespace+"_queue_global_concurrency",
+		"The number of jobs that may be processed at once across every process.", []string{"queue"}, nil)
+	globalRunningDesc = prometheus.NewDesc(namespace+"_queue_global_running_jobs",
+		"The number of jobs being processed across every process, on queues with a global concurrency.",
+		[]string{"queue"}, nil)
+)
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingDesc
+	ch <- oldestPendingDesc
+	ch <- concurrencyDesc
+	ch <- notificationsDesc
+	ch <- reconnectsDesc
+	ch <- globalConcurrencyDesc
+	ch <- globalRunningDesc
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.metrics.mu.RLock()
+	backend := c.metrics.backend
+	c.metrics.mu.RUnlock()
+	if backend == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.metrics.statsTimeout)
+	defer cancel()
+
+	stats, err := backend.QueueStats(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(pendingDesc, err)
+		return
+	}
+
+	now := time.Now()
+	for _, qs := range stats {
+		ch <- prometheus.MustNewConstMetric(pendingDesc, prometheus.GaugeValue, float64(qs.Pending), qs.Queue)
+		ch <- prometheus.MustNewConstMetric(concurrencyDesc, prometheus.GaugeValue, float64(qs.Concurrency), qs.Queue)
+		ch <- prometheus.MustNewConstMetric(notificationsDesc, prometheus.CounterValue, float64(qs.Notifications),
+			qs.Queue)
+		ch <- prometheus.MustNewConstMetric(reconnectsDesc, prometheus.CounterValue, float64(qs.ListenerReconnects),
+			qs.Queue)
+		ch <- prometheus.MustNewConstMetric(globalConcurrencyDesc, prometheus.GaugeValue, float64(qs.GlobalConcurrency),
+			qs.Queue)
+		ch <- prometheus.MustNewConstMetric(globalRunningDesc, prometheus.GaugeValue, float64(qs.GlobalRunning), qs.Queue)
+
+		var age time.Duration
+		if qs.Pending > 0 && !qs.OldestPending.IsZero() {
+			age = now.Sub(qs.OldestPending)
+		}
+		ch <- prometheus.MustNewConstMetric(oldestPendingDesc, prometheus.GaugeValue, age.Seconds(), qs.Queue)
+	}
+}
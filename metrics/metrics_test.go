@@ -0,0 +1,117 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acaloiaro/neoq"
+	"github.com/acaloiaro/neoq/backends/memory"
+	"github.com/acaloiaro/neoq/handler"
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/acaloiaro/neoq/metrics"
+)
+
+const queue = "testing"
+
+var errJobFailed = errors.New("job failed")
+
+// TestMetrics tests that job metrics and queue stats are exposed in the Prometheus exposition format
+func TestMetrics(t *testing.T) {
+	m, err := metrics.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	nq, err := neoq.New(ctx, neoq.WithBackend(memory.Backend), m.Instrument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nq.Shutdown(ctx)
+	m.Observe(nq)
+
+	done := make(chan bool, 2) // nolint: gomnd
+	h := handler.New(queue, func(ctx context.Context) (err error) {
+		defer func() { done <- true }()
+
+		j, err := jobs.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		if j.Payload["fail"] == true {
+			return errJobFailed
+		}
+
+		return
+	}, handler.Concurrency(1))
+	if err = nq.Start(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range []map[string]any{{"fail": false}, {"fail": true}} {
+		if _, err = nq.Enqueue(ctx, &jobs.Job{Queue: queue, Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal(jobs.ErrJobTimeout)
+		case <-done:
+		}
+	}
+
+	expected := []string{
+		`neoq_jobs_enqueued_total{queue="testing"} 2`,
+		`neoq_jobs_processed_total{queue="testing"} 1`,
+		`neoq_jobs_failed_total{queue="testing"} 1`,
+		`neoq_jobs_retried_total{queue="testing"} 1`,
+		`neoq_job_duration_seconds_count{queue="testing",status="succeeded"} 1`,
+		`neoq_jobs_in_flight{queue="testing"} 0`,
+		`neoq_queue_pending_jobs{queue="testing"} 0`,
+		`neoq_handler_concurrency{queue="testing"} 1`,
+	}
+
+	// the failed job's outcome is recorded just after its handler returns
+	var body string
+	timeout := time.After(5 * time.Second)
+	for !containsAll(body, expected) {
+		select {
+		case <-timeout:
+			t.Fatalf("expected metrics to contain %v, got:\n%s", expected, body)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		body = scrape(t, m)
+	}
+}
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func containsAll(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if !strings.Contains(s, substr) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -5,9 +5,13 @@ import (
 	"errors"
 	"time"
 
+	"github.com/acaloiaro/neoq/codec"
 	"github.com/acaloiaro/neoq/handler"
 	"github.com/acaloiaro/neoq/jobs"
 	"github.com/acaloiaro/neoq/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -18,9 +22,16 @@ const (
 	// to wait until the job's RunAfter, scheduling the job to be run exactly at RunAfter
 	DefaultFutureJobWindow  = 30 * time.Second
 	DefaultJobCheckInterval = 1 * time.Second
+	// the number of jobs listed per page when listing jobs without specifying a page size
+	DefaultPageSize = 100
+	// the time that pending jobs wait for each level of priority that they gain, see WithPriorityAging
+	DefaultPriorityAging = 1 * time.Minute
 )
 
-var ErrBackendNotSpecified = errors.New("a backend must be specified")
+var (
+	ErrBackendNotSpecified = errors.New("a backend must be specified")
+	ErrEnqueueManyAborted  = errors.New("jobs were not enqueued because an enqueue interceptor rejected another job")
+)
 
 // Config configures neoq and its backends
 //
@@ -29,14 +40,24 @@ var ErrBackendNotSpecified = errors.New("a backend must be specified")
 // per-handler basis.
 type Config struct {
 	BackendInitializer     BackendInitializer
-	BackendAuthPassword    string           // password with which to authenticate to the backend's data provider
-	BackendConcurrency     int              // total number of backend processes available to process jobs
-	ConnectionString       string           // a string containing connection details for the backend
-	JobCheckInterval       time.Duration    // the interval of time between checking for new future/retry jobs
-	FutureJobWindow        time.Duration    // time duration between current time and job.RunAfter that goroutines schedule for future jobs
-	IdleTransactionTimeout int              // the number of milliseconds PgBackend transaction may idle before the connection is killed
-	ShutdownTimeout        time.Duration    // duration to wait for jobs to finish during shutdown
-	LogLevel               logging.LogLevel // the log level of the default logger
+	BackendAuthPassword    string                        // password with which to authenticate to the backend's data provider
+	BackendConcurrency     int                           // total number of backend processes available to process jobs
+	ConnectionString       string                        // a string containing connection details for the backend
+	JobCheckInterval       time.Duration                 // the interval of time between checking for new future/retry jobs
+	FutureJobWindow        time.Duration                 // time duration between current time and job.RunAfter that goroutines schedule for future jobs
+	IdleTransactionTimeout int                           // the number of milliseconds PgBackend transaction may idle before the connection is killed
+	ShutdownTimeout        time.Duration                 // duration to wait for jobs to finish during shutdown
+	LogLevel               logging.LogLevel              // the log level of the default logger
+	Codecs                 map[string]codec.Codec        // codecs that encode job payloads, by queue name
+	Middleware             []handler.Middleware          // middleware that wraps every handler's middleware
+	EnqueueInterceptors    []EnqueueInterceptor          // interceptors that every enqueued job passes through
+	JobHooks               map[JobEventType][]JobHook    // hooks that are called for every job lifecycle event
+	TracerProvider         trace.TracerProvider          // the provider of the tracer that creates neoq's spans
+	Propagator             propagation.TextMapPropagator // the propagator that persists trace context with jobs
+	ContextPropagators     []ContextPropagator           // propagators that carry context values in job metadata
+	PriorityAging          time.Duration                 // the time that pending jobs wait for each priority they gain
+	CompletedJobRetention  time.Duration                 // the time that backends which remove completed jobs keep them
+	DependencyPolicy       jobs.DependencyPolicy         // what happens to waiting jobs when the jobs they depend on die
 }
 
 // ConfigOption is a function that sets optional backend configuration
@@ -47,6 +68,217 @@ func NewConfig() *Config {
 	return &Config{
 		FutureJobWindow:  DefaultFutureJobWindow,
 		JobCheckInterval: DefaultJobCheckInterval,
+		PriorityAging:    DefaultPriorityAging,
+	}
+}
+
+// Codec is the codec that encodes the payloads of jobs on queue. [codec.JSON] is the default codec.
+func (c *Config) Codec(queue string) codec.Codec {
+	if cdc, ok := c.Codecs[queue]; ok {
+		return cdc
+	}
+
+	return codec.JSON
+}
+
+// HandlerMiddleware is the middleware that backends apply to h: backend-wide middleware wraps h's own middleware
+func (c *Config) HandlerMiddleware(h handler.Handler) []handler.Middleware {
+	mw := make([]handler.Middleware, 0, len(c.Middleware)+len(h.Middleware))
+	mw = append(mw, c.Middleware...)
+	return append(mw, h.Middleware...)
+}
+
+// EnqueueFunc enqueues a job, returning its ID
+type EnqueueFunc func(ctx context.Context, job *jobs.Job) (jobID string, err error)
+
+// EnqueueInterceptor intercepts jobs before they're enqueued. Interceptors call next, at most once, to continue
+// enqueueing the job.
+//
+// Interceptors may modify the job before calling next, short-circuit by returning an error without calling next, or
+// observe the job ID returned by next.
+type EnqueueInterceptor func(ctx context.Context, job *jobs.Job, next EnqueueFunc) (jobID string, err error)
+
+// InterceptEnqueue passes job through the configured enqueue interceptors, the first interceptor being the outermost,
+// before enqueueing it with enqueue
+//
+// Enqueueing is traced, and the trace context of the ctx that reaches enqueue is persisted with the job, so that
+// handlers' spans are children of the span that enqueued their jobs. Context values are copied into the job's metadata
+// by the configured context propagators.
+func (c *Config) InterceptEnqueue(ctx context.Context, job *jobs.Job, enqueue EnqueueFunc) (jobID string, err error) {
+	ctx, span := c.startEnqueueSpan(ctx, job)
+	defer func() {
+		span.SetAttributes(attribute.String("messaging.message.id", jobID))
+		EndSpan(span, err)
+	}()
+
+	return c.intercept(ctx, job, enqueue)
+}
+
+// intercept passes job through the configured enqueue interceptors before enqueueing it with enqueue, persisting the
+// trace context and metadata of the ctx that reaches enqueue with the job
+func (c *Config) intercept(ctx context.Context, job *jobs.Job, enqueue EnqueueFunc) (jobID string, err error) {
+	next := func(ctx context.Context, job *jobs.Job) (string, error) {
+		c.injectTraceContext(ctx, job)
+		c.injectMetadata(ctx, job)
+		return enqueue(ctx, job)
+	}
+	for i := len(c.EnqueueInterceptors) - 1; i >= 0; i-- {
+		interceptor, n := c.EnqueueInterceptors[i], next
+		next = func(ctx context.Context, job *jobs.Job) (string, error) {
+			return interceptor(ctx, job, n)
+		}
+	}
+
+	return next(ctx, job)
+}
+
+// InterceptEnqueueMany passes every job through the configured enqueue interceptors, then enqueues all jobs that
+// make it through the interceptors at once, with enqueueMany. Interceptors observe the job IDs returned by
+// enqueueMany for their jobs.
+//
+// Jobs are intercepted one at a time, in order. If any interceptor rejects its job with an error, no jobs are
+// enqueued, later jobs are not intercepted, and the rejection's error is returned. The interceptors of earlier jobs
+// observe [ErrEnqueueManyAborted].
+//
+// Backends that enqueue jobs individually may fail after some of the jobs were enqueued, in which case they return the
+// IDs of those jobs along with the error, and empty IDs for the rest. The interceptors of jobs that were enqueued
+// observe their job IDs, and the interceptors of the rest observe the error.
+//
+// Enqueueing many jobs is traced with a single span. Without interceptors, jobs are enqueued directly; with them, each
+// job's interceptors wait in their own goroutine for the jobs to be enqueued, so that they can observe their job IDs.
+func (c *Config) InterceptEnqueueMany(ctx context.Context, js []*jobs.Job,
+	enqueueMany func(ctx context.Context, js []*jobs.Job) (jobIDs []string, err error),
+) (jobIDs []string, err error) {
+	ctx, span := c.startEnqueueManySpan(ctx, len(js))
+	defer func() { EndSpan(span, err) }()
+
+	if len(c.EnqueueInterceptors) == 0 {
+		for _, job := range js {
+			c.injectTraceContext(ctx, job)
+			c.injectMetadata(ctx, job)
+		}
+
+		if len(js) == 0 {
+			return []string{}, nil
+		}
+
+		return enqueueMany(ctx, js)
+	}
+
+	type result struct {
+		jobID string
+		err   error
+	}
+
+	type interceptedJob struct {
+		job    *jobs.Job
+		result chan result
+	}
+
+	jobIDs = make([]string, len(js))
+	errs := make([]error, len(js))
+	intercepted := make(chan interceptedJob, 1)
+	returned := make(chan int, len(js))
+	batch := []interceptedJob{}
+	for i, job := range js {
+		go func(i int, job *jobs.Job) {
+			jobIDs[i], errs[i] = c.intercept(ctx, job, func(_ context.Context, job *jobs.Job) (string, error) {
+				res := make(chan result, 1)
+				intercepted <- interceptedJob{job: job, result: res}
+				r := <-res
+				return r.jobID, r.err
+			})
+			returned <- i
+		}(i, job)
+
+		// wait for the job to either make it through its interceptors, or to be short-circuited by them, before
+		// intercepting the next job
+		select {
+		case ij := <-intercepted:
+			batch = append(batch, ij)
+			continue
+		case <-returned:
+		}
+
+		if errs[i] != nil {
+			err = errs[i]
+			break
+		}
+	}
+
+	if err != nil {
+		for _, ij := range batch {
+			ij.result <- result{err: ErrEnqueueManyAborted}
+		}
+	} else {
+		batchJobs := make([]*jobs.Job, len(batch))
+		for i, ij := range batch {
+			batchJobs[i] = ij.job
+		}
+
+		var batchJobIDs []string
+		var batchErr error
+		if len(batchJobs) > 0 {
+			batchJobIDs, batchErr = enqueueMany(ctx, batchJobs)
+		}
+
+		for i, ij := range batch {
+			r := result{err: batchErr}
+			if i < len(batchJobIDs) && batchJobIDs[i] != "" {
+				r = result{jobID: batchJobIDs[i]}
+			}
+			ij.result <- r
+		}
+	}
+
+	for range batch {
+		<-returned
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range errs {
+		if e != nil {
+			return jobIDs, e
+		}
+	}
+
+	return jobIDs, nil
+}
+
+// JobEventType is the type of transition in a job's lifecycle that a JobEvent describes
+type JobEventType int
+
+const (
+	JobStarted   JobEventType = iota // the job's handler is about to run
+	JobSucceeded                     // the job's handler ran successfully
+	JobFailed                        // the job's handler returned an error
+	JobRetried                       // the failed job was scheduled to be retried
+	JobDead                          // the job was moved to the dead queue
+	JobSkipped                       // the job was not run because its deadline had passed
+	JobSnoozed                       // the job's handler snoozed it, see jobs.Snooze
+)
+
+// JobEvent describes a transition in a job's lifecycle
+type JobEvent struct {
+	Type     JobEventType
+	Job      *jobs.Job
+	Attempt  int           // the job's attempt number, starting at 1
+	Duration time.Duration // the time the job's handler spent running, for events that follow the handler running
+	Err      error         // the job's error, for JobFailed, JobRetried, JobDead, JobSkipped, and JobSnoozed events
+}
+
+// JobHook is a function that is called when jobs transition through their lifecycle
+//
+// Hooks are called synchronously by the worker processing the job, so they should return quickly
+type JobHook func(ctx context.Context, event JobEvent)
+
+// FireJobEvent calls the hooks that are configured for the event's type
+func (c *Config) FireJobEvent(ctx context.Context, event JobEvent) {
+	for _, hook := range c.JobHooks[event.Type] {
+		hook(ctx, event)
 	}
 }
 
@@ -63,6 +295,51 @@ type Neoq interface {
 	// Enqueue queues jobs to be executed asynchronously
 	Enqueue(ctx context.Context, job *jobs.Job) (jobID string, err error)
 
+	// EnqueueMany queues many jobs at once, returning their job IDs in the same order as jobs
+	//
+	// Jobs that are duplicates are not queued, and have the job ID [jobs.DuplicateJobID]
+	EnqueueMany(ctx context.Context, js []*jobs.Job) (jobIDs []string, err error)
+
+	// EnqueueBatch queues a batch of jobs, returning the batch's ID
+	//
+	// Once every job in the batch has been processed or died, the batch's callback job is queued with a summary of the
+	// batch. See [jobs.Batch].
+	EnqueueBatch(ctx context.Context, batch *jobs.Batch) (batchID string, err error)
+
+	// GetBatch retrieves the status of the batch with the given ID, as returned by EnqueueBatch
+	//
+	// [jobs.ErrBatchNotFound] is returned when no batch with the given ID is known to the backend
+	GetBatch(ctx context.Context, batchID string) (status *jobs.BatchStatus, err error)
+
+	// GetJob retrieves the job with the given ID, as returned by Enqueue
+	//
+	// [jobs.ErrJobNotFound] is returned when no job with the given ID is known to the backend
+	GetJob(ctx context.Context, jobID string) (job *jobs.Job, err error)
+
+	// ListDeadJobs lists jobs that have exhausted their retries
+	//
+	// For available list options, see [neoq.ListOption]
+	ListDeadJobs(ctx context.Context, opts ...ListOption) (deadJobs []*jobs.Job, err error)
+
+	// RequeueDeadJobs moves dead jobs back onto their queues with their retries reset, returning the number of jobs
+	// that were requeued
+	//
+	// Dead jobs that duplicate a job already on the queue are not requeued
+	RequeueDeadJobs(ctx context.Context, jobIDs ...string) (count int, err error)
+
+	// DeleteDeadJobs permanently deletes dead jobs, returning the number of jobs that were deleted
+	DeleteDeadJobs(ctx context.Context, jobIDs ...string) (count int, err error)
+
+	// PurgeDeadJobs permanently deletes all dead jobs on a queue, or on all queues when queue is empty, returning the
+	// number of jobs that were deleted
+	PurgeDeadJobs(ctx context.Context, queue string) (count int, err error)
+
+	// QueueStats reports the state of queues: their pending jobs, and the handlers processing them
+	//
+	// Stats are reported for every queue with a handler, and for every queue with pending jobs when the backend is able
+	// to list them
+	QueueStats(ctx context.Context) (stats []QueueStats, err error)
+
 	// Start starts processing jobs on the queue specified in the Handler
 	Start(ctx context.Context, h handler.Handler) (err error)
 
@@ -78,6 +355,70 @@ type Neoq interface {
 	Shutdown(ctx context.Context)
 }
 
+// QueueStats describes the state of a queue
+type QueueStats struct {
+	Queue              string
+	Pending            int       // the number of jobs that are due to be processed, but have not yet been processed
+	OldestPending      time.Time // the time that the oldest pending job became due, when known
+	Concurrency        int       // the number of jobs that the queue's handler processes concurrently in this process
+	Notifications      int64     // the number of new job notifications received by the queue's listener (Postgres)
+	ListenerReconnects int64     // the number of times the queue's listener has reconnected (Postgres)
+	GlobalConcurrency  int       // the number of jobs that may be processed at once across every process (Postgres)
+	GlobalRunning      int       // the number of jobs being processed across every process, when globally limited (Postgres)
+}
+
+// ListOptions filters and paginates job listings
+type ListOptions struct {
+	Queue    string // only list jobs on this queue. Jobs on all queues are listed when empty
+	Page     int    // the page of jobs to list, starting at 1
+	PageSize int    // the maximum number of jobs per page
+}
+
+// ListOption is a function that sets optional job listing configuration
+type ListOption func(o *ListOptions)
+
+// NewListOptions initializes new ListOptions with defaults and applies opts to them
+func NewListOptions(opts ...ListOption) *ListOptions {
+	o := &ListOptions{
+		Page:     1,
+		PageSize: DefaultPageSize,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.Page < 1 {
+		o.Page = 1
+	}
+
+	if o.PageSize < 1 {
+		o.PageSize = DefaultPageSize
+	}
+
+	return o
+}
+
+// Offset is the number of jobs preceding the configured page
+func (o *ListOptions) Offset() int {
+	return (o.Page - 1) * o.PageSize
+}
+
+// ListQueue configures job listings to only list jobs on the specified queue
+func ListQueue(queue string) ListOption {
+	return func(o *ListOptions) {
+		o.Queue = queue
+	}
+}
+
+// ListPage configures job listings to list a specific page of jobs, starting at page 1
+func ListPage(page, pageSize int) ListOption {
+	return func(o *ListOptions) {
+		o.Page = page
+		o.PageSize = pageSize
+	}
+}
+
 // New creates a new backend instance for job processing.
 //
 // By default, neoq initializes [memory.Backend] if New() is called without a backend configuration option.
@@ -122,6 +463,37 @@ func WithJobCheckInterval(interval time.Duration) ConfigOption {
 	}
 }
 
+// WithPriorityAging configures the time that pending jobs wait for each level of priority that they gain, so that
+// jobs with low priorities are not starved by a steady stream of jobs with higher priorities. E.g. with the default of
+// one minute, a job with priority 0 that has been pending for two minutes is processed before a job with priority 1
+// that has only just become due.
+//
+// Aging of zero or less processes jobs strictly by priority. The Postgres backend ages jobs when they're scheduled, so
+// changes to the aging apply to jobs that are enqueued or rescheduled after the change. The Redis backend ignores this
+// option, see [pkg/github.com/acaloiaro/neoq/backends/redis.Backend].
+func WithPriorityAging(aging time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.PriorityAging = aging
+	}
+}
+
+// WithDependencyPolicy configures what happens to jobs that are waiting for other jobs when the jobs that they depend
+// on die. By default, waiting jobs are cancelled. See [jobs.Job.DependsOn].
+func WithDependencyPolicy(policy jobs.DependencyPolicy) ConfigOption {
+	return func(c *Config) {
+		c.DependencyPolicy = policy
+	}
+}
+
+// WithCompletedJobRetention configures the time that backends which remove completed jobs keep them for, so that they
+// can be looked up with GetJob. By default, the in-memory backend keeps completed jobs for an hour, and the Redis backend
+// removes them as soon as they complete. The Postgres backend keeps completed jobs, and ignores this option.
+func WithCompletedJobRetention(retention time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.CompletedJobRetention = retention
+	}
+}
+
 // WithLogLevel configures the log level for neoq's default logger. By default, log level is "INFO".
 // if SetLogger is used, WithLogLevel has no effect on the set logger
 func WithLogLevel(level logging.LogLevel) ConfigOption {
@@ -129,3 +501,110 @@ func WithLogLevel(level logging.LogLevel) ConfigOption {
 		c.LogLevel = level
 	}
 }
+
+// WithCodec configures the codec that encodes the payloads of jobs enqueued on queue. By default, payloads are JSON
+// objects stored as-is.
+//
+// Jobs created with [jobs.NewEncoded] are always encoded by the codec they were created with.
+func WithCodec(queue string, cdc codec.Codec) ConfigOption {
+	return func(c *Config) {
+		if c.Codecs == nil {
+			c.Codecs = map[string]codec.Codec{}
+		}
+
+		c.Codecs[queue] = cdc
+	}
+}
+
+// WithMiddleware configures middleware that wraps every job execution on every queue, including periodic jobs
+//
+// Middleware is applied in the order it's given, i.e. the first middleware is the outermost. Backend-wide middleware
+// wraps the middleware of individual handlers, configured with [handler.WithMiddleware].
+func WithMiddleware(mw ...handler.Middleware) ConfigOption {
+	return func(c *Config) {
+		c.Middleware = append(c.Middleware, mw...)
+	}
+}
+
+// WithEnqueueInterceptor configures interceptors that every job passes through before it's enqueued, and before it's
+// fingerprinted, including jobs enqueued in bulk or in transactions
+//
+// Interceptors are applied in the order they're given, i.e. the first interceptor is the outermost.
+func WithEnqueueInterceptor(interceptors ...EnqueueInterceptor) ConfigOption {
+	return func(c *Config) {
+		c.EnqueueInterceptors = append(c.EnqueueInterceptors, interceptors...)
+	}
+}
+
+// WithOnJobStarted configures hooks that are called before jobs' handlers run
+func WithOnJobStarted(hooks ...JobHook) ConfigOption {
+	return withJobHooks(JobStarted, hooks)
+}
+
+// WithOnJobSucceeded configures hooks that are called when jobs' handlers run successfully
+func WithOnJobSucceeded(hooks ...JobHook) ConfigOption {
+	return withJobHooks(JobSucceeded, hooks)
+}
+
+// WithOnJobFailed configures hooks that are called when jobs' handlers return errors
+//
+// Failed jobs are followed by either a [JobRetried] or [JobDead] event
+func WithOnJobFailed(hooks ...JobHook) ConfigOption {
+	return withJobHooks(JobFailed, hooks)
+}
+
+// WithOnJobRetried configures hooks that are called when failed jobs are scheduled to be retried
+func WithOnJobRetried(hooks ...JobHook) ConfigOption {
+	return withJobHooks(JobRetried, hooks)
+}
+
+// WithOnJobDead configures hooks that are called when jobs are moved to the dead queue
+func WithOnJobDead(hooks ...JobHook) ConfigOption {
+	return withJobHooks(JobDead, hooks)
+}
+
+// WithOnJobSkipped configures hooks that are called when jobs are not run because their deadlines have passed
+//
+// Skipped jobs are not retried, and are followed by a [JobDead] event
+func WithOnJobSkipped(hooks ...JobHook) ConfigOption {
+	return withJobHooks(JobSkipped, hooks)
+}
+
+// WithOnJobSnoozed configures hooks that are called when jobs' handlers snooze them. See [jobs.Snooze].
+func WithOnJobSnoozed(hooks ...JobHook) ConfigOption {
+	return withJobHooks(JobSnoozed, hooks)
+}
+
+func withJobHooks(eventType JobEventType, hooks []JobHook) ConfigOption {
+	return func(c *Config) {
+		if c.JobHooks == nil {
+			c.JobHooks = map[JobEventType][]JobHook{}
+		}
+
+		c.JobHooks[eventType] = append(c.JobHooks[eventType], hooks...)
+	}
+}
+
+// WithTracerProvider configures the tracer provider that creates neoq's spans. By default, the global tracer provider
+// is used.
+func WithTracerProvider(tp trace.TracerProvider) ConfigOption {
+	return func(c *Config) {
+		c.TracerProvider = tp
+	}
+}
+
+// WithPropagator configures the propagator that persists the trace context of enqueueing contexts with jobs, and
+// restores it when jobs are handled. By default, W3C trace context and baggage are propagated.
+func WithPropagator(p propagation.TextMapPropagator) ConfigOption {
+	return func(c *Config) {
+		c.Propagator = p
+	}
+}
+
+// WithContextPropagator configures propagators that copy values from the contexts that jobs are enqueued with into
+// their metadata, and from their metadata into the contexts that their handlers run with
+func WithContextPropagator(propagators ...ContextPropagator) ConfigOption {
+	return func(c *Config) {
+		c.ContextPropagators = append(c.ContextPropagators, propagators...)
+	}
+}
@@ -0,0 +1,73 @@
+package neoq
+
+import (
+	"context"
+
+	"github.com/acaloiaro/neoq/jobs"
+)
+
+// ContextPropagator carries values from the contexts that jobs are enqueued with to the contexts that their handlers
+// run with, by way of job metadata
+type ContextPropagator interface {
+	// Inject copies values from ctx into metadata
+	Inject(ctx context.Context, metadata map[string]string)
+	// Extract returns a copy of ctx that carries the values in metadata
+	Extract(ctx context.Context, metadata map[string]string) context.Context
+}
+
+// ContextValuePropagator propagates the string context value stored under key as the metadata header named header
+func ContextValuePropagator(header string, key any) ContextPropagator {
+	return contextValuePropagator{header: header, key: key}
+}
+
+type contextValuePropagator struct {
+	header string
+	key    any
+}
+
+func (p contextValuePropagator) Inject(ctx context.Context, metadata map[string]string) {
+	if v, ok := ctx.Value(p.key).(string); ok {
+		metadata[p.header] = v
+	}
+}
+
+func (p contextValuePropagator) Extract(ctx context.Context, metadata map[string]string) context.Context {
+	if v, ok := metadata[p.header]; ok {
+		return context.WithValue(ctx, p.key, v)
+	}
+
+	return ctx
+}
+
+// ExtractMetadata returns a copy of ctx that carries the context values propagated in job's metadata
+func (c *Config) ExtractMetadata(ctx context.Context, job *jobs.Job) context.Context {
+	for _, p := range c.ContextPropagators {
+		ctx = p.Extract(ctx, job.Metadata)
+	}
+
+	return ctx
+}
+
+// injectMetadata copies context values from ctx into job's metadata. Metadata that is set on jobs explicitly takes
+// precedence over propagated values.
+func (c *Config) injectMetadata(ctx context.Context, job *jobs.Job) {
+	if len(c.ContextPropagators) == 0 {
+		return
+	}
+
+	metadata := map[string]string{}
+	for _, p := range c.ContextPropagators {
+		p.Inject(ctx, metadata)
+	}
+
+	for header, v := range metadata {
+		if _, ok := job.Metadata[header]; ok {
+			continue
+		}
+
+		if job.Metadata == nil {
+			job.Metadata = map[string]string{}
+		}
+		job.Metadata[header] = v
+	}
+}
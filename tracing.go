@@ -0,0 +1,109 @@
+package neoq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acaloiaro/neoq/jobs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer that creates neoq's spans
+const TracerName = "github.com/acaloiaro/neoq"
+
+// Tracer is the tracer that creates neoq's spans. By default, the global tracer provider creates it.
+func (c *Config) Tracer() trace.Tracer {
+	tp := c.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(TracerName)
+}
+
+// TextMapPropagator is the propagator that persists trace context with jobs. By default, W3C trace context and
+// baggage are propagated.
+func (c *Config) TextMapPropagator() propagation.TextMapPropagator {
+	if c.Propagator != nil {
+		return c.Propagator
+	}
+
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// StartJobSpan starts the span in which jobs are processed, as a child of the span that enqueued them
+//
+// The span starts at start, which may precede the job being fetched. Backends end the span once the job's status is
+// updated.
+func (c *Config) StartJobSpan(ctx context.Context, job *jobs.Job, start time.Time) (context.Context, trace.Span) {
+	if len(job.TraceContext) > 0 {
+		ctx = c.TextMapPropagator().Extract(ctx, propagation.MapCarrier(job.TraceContext))
+	}
+
+	jobID := job.Fingerprint
+	if job.ID != 0 {
+		jobID = fmt.Sprint(job.ID)
+	}
+
+	return c.Tracer().Start(ctx, "neoq.process",
+		trace.WithTimestamp(start),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "neoq"),
+			attribute.String("messaging.destination.name", job.Queue),
+			attribute.String("messaging.message.id", jobID),
+			attribute.Int("neoq.job.retries", job.Retries),
+		))
+}
+
+// StartHandlerSpan starts the span in which job handlers run, as a child of the span in which jobs are processed.
+// Handlers are executed with the returned context.
+func (c *Config) StartHandlerSpan(ctx context.Context, job *jobs.Job) (context.Context, trace.Span) {
+	return c.Tracer().Start(ctx, "neoq.handle", trace.WithAttributes(
+		attribute.String("messaging.destination.name", job.Queue),
+	))
+}
+
+// EndSpan records err on span, if it's not nil, and ends span. Jobs that are snoozed are not recorded as errors.
+func EndSpan(span trace.Span, err error) {
+	if _, snoozed := jobs.Snoozed(err); err != nil && !snoozed {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// startEnqueueSpan starts the span in which jobs are enqueued
+func (c *Config) startEnqueueSpan(ctx context.Context, job *jobs.Job) (context.Context, trace.Span) {
+	return c.Tracer().Start(ctx, "neoq.enqueue",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "neoq"),
+			attribute.String("messaging.destination.name", job.Queue),
+		))
+}
+
+// startEnqueueManySpan starts the span in which many jobs are enqueued at once
+func (c *Config) startEnqueueManySpan(ctx context.Context, count int) (context.Context, trace.Span) {
+	return c.Tracer().Start(ctx, "neoq.enqueue_many",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "neoq"),
+			attribute.Int("messaging.batch.message_count", count),
+		))
+}
+
+// injectTraceContext persists the trace context of ctx with job
+func (c *Config) injectTraceContext(ctx context.Context, job *jobs.Job) {
+	carrier := propagation.MapCarrier{}
+	c.TextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		job.TraceContext = carrier
+	}
+}